@@ -0,0 +1,153 @@
+package answer
+
+import (
+	"strings"
+)
+
+type BlockType string
+
+const (
+	BlockTypeParagraph BlockType = "paragraph"
+	BlockTypeCodeBlock BlockType = "codeblock"
+)
+
+type InlineType string
+
+const (
+	InlineTypeText     InlineType = "text"
+	InlineTypeCitation InlineType = "citation"
+)
+
+// Answer is a structured version of a generated answer, built from the same events that are streamed to clients
+type Answer struct {
+	Blocks []Block `json:"blocks"`
+}
+
+// Block is either a paragraph of inlines or a fenced code block
+type Block struct {
+	Type    BlockType `json:"type"`
+	Inlines []Inline  `json:"inlines,omitempty"`
+	// Language is the tag from the opening fence of a code block, if there was one
+	Language string `json:"language,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+type Inline struct {
+	Type InlineType `json:"type"`
+	Text string     `json:"text,omitempty"`
+	// DocumentIndex is the zero-indexed position of the cited document in the documents the answer was generated from
+	DocumentIndex int `json:"documentIndex"`
+}
+
+// Citation ties a span of the rendered answer text to the document that supports it. Offsets are byte offsets.
+type Citation struct {
+	DocumentIndex int `json:"documentIndex"`
+	// SpanStart and SpanEnd delimit the sentence the citation follows
+	SpanStart int `json:"spanStart"`
+	SpanEnd   int `json:"spanEnd"`
+}
+
+// CodeBlock locates a fenced code block, including its fences, in the rendered answer text
+type CodeBlock struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// Annotated is the answer rendered as Markdown without citation markers, along with where citations and code blocks
+// land in it
+type Annotated struct {
+	Text       string      `json:"text"`
+	Citations  []Citation  `json:"citations"`
+	CodeBlocks []CodeBlock `json:"codeBlocks"`
+}
+
+func (a Answer) Annotate() Annotated {
+	var (
+		sb         strings.Builder
+		citations  = []Citation{}
+		codeBlocks = []CodeBlock{}
+	)
+
+	for i, block := range a.Blocks {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+
+		switch block.Type {
+		case BlockTypeCodeBlock:
+			start := sb.Len()
+			fenced := block.fenced()
+			sb.WriteString(fenced)
+			codeBlocks = append(codeBlocks, CodeBlock{Language: block.Language, Code: fenced, Start: start, End: sb.Len()})
+		case BlockTypeParagraph:
+			paragraphStart := sb.Len()
+			previousCitationEnd := paragraphStart
+			for _, inline := range block.Inlines {
+				if inline.Type == InlineTypeText {
+					sb.WriteString(inline.Text)
+					continue
+				}
+
+				end := paragraphStart + len(strings.TrimRight(sb.String()[paragraphStart:], " \t"))
+				start := paragraphStart + sentenceStart(sb.String()[paragraphStart:end], previousCitationEnd-paragraphStart)
+				citations = append(citations, Citation{DocumentIndex: inline.DocumentIndex, SpanStart: start, SpanEnd: end})
+				previousCitationEnd = end
+			}
+		}
+	}
+
+	return Annotated{Text: sb.String(), Citations: citations, CodeBlocks: codeBlocks}
+}
+
+// CitedDocumentIndices returns each cited document index once, in order of first citation
+func (a Answer) CitedDocumentIndices() []int {
+	var (
+		indices []int
+		seen    = make(map[int]struct{})
+	)
+	for _, block := range a.Blocks {
+		for _, inline := range block.Inlines {
+			if inline.Type != InlineTypeCitation {
+				continue
+			}
+			if _, ok := seen[inline.DocumentIndex]; ok {
+				continue
+			}
+			seen[inline.DocumentIndex] = struct{}{}
+			indices = append(indices, inline.DocumentIndex)
+		}
+	}
+	return indices
+}
+
+func (b Block) fenced() string {
+	if b.Code == "" {
+		return codeBlockMarker + b.Language + "\n" + codeBlockMarker
+	}
+	return codeBlockMarker + b.Language + "\n" + b.Code + "\n" + codeBlockMarker
+}
+
+// sentenceStart finds where the sentence ending at the end of text begins. Consecutive citations, ie
+// "<cited>1</cited><cited>2</cited>", share the same sentence, so the previous citation only bounds the search when it
+// ends before the text does.
+func sentenceStart(text string, previousCitationEnd int) int {
+	trimmed := strings.TrimRight(text, ".!?")
+
+	start := 0
+	for _, boundary := range []string{". ", "? ", "! ", "\n"} {
+		if i := strings.LastIndex(trimmed, boundary); i >= 0 && i+len(boundary) > start {
+			start = i + len(boundary)
+		}
+	}
+	if previousCitationEnd < len(text) && previousCitationEnd > start {
+		start = previousCitationEnd
+	}
+
+	// Skip leading whitespace so the span starts at the sentence's first character
+	for start < len(text) && (text[start] == ' ' || text[start] == '\n' || text[start] == '\t') {
+		start++
+	}
+	return start
+}
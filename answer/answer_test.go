@@ -0,0 +1,114 @@
+package answer
+
+import (
+	"encoding/json"
+	"github.com/coopslarhette/raglib/lib/document"
+	"raglib-demo/api/sse"
+	"testing"
+)
+
+func build(events ...sse.Event) Answer {
+	b := NewBuilder()
+	for _, e := range events {
+		b.Add(e)
+	}
+	return b.Answer()
+}
+
+func TestBuilder(t *testing.T) {
+	a := build(
+		sse.NewTextEvent("Go has goroutines"),
+		sse.NewCitationEvent(0),
+		sse.NewTextEvent(".\n\nThey are "),
+		sse.NewTextEvent("cheap."),
+		sse.NewCitationEvent(1),
+		sse.NewCitationEvent(2),
+		sse.NewTextEvent("\n\n"),
+		sse.NewTextEvent(" "),
+		sse.NewCodeBlockEvent("```go\n\ngo f()\n ```"),
+		sse.NewTextEvent("\n"),
+		sse.NewCodeBlockEvent("```"),
+	)
+
+	expected := Answer{Blocks: []Block{
+		{Type: BlockTypeParagraph, Inlines: []Inline{
+			{Type: InlineTypeText, Text: "Go has goroutines"},
+			{Type: InlineTypeCitation, DocumentIndex: 0},
+			{Type: InlineTypeText, Text: "."},
+		}},
+		{Type: BlockTypeParagraph, Inlines: []Inline{
+			{Type: InlineTypeText, Text: "They are cheap."},
+			{Type: InlineTypeCitation, DocumentIndex: 1},
+			{Type: InlineTypeCitation, DocumentIndex: 2},
+		}},
+		{Type: BlockTypeCodeBlock, Language: "go", Code: "go f()"},
+		{Type: BlockTypeCodeBlock},
+	}}
+
+	got, _ := json.Marshal(a)
+	want, _ := json.Marshal(expected)
+	if string(got) != string(want) {
+		t.Fatalf("Unexpected answer.\nGot:      %s\nExpected: %s", got, want)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	a := build(
+		sse.NewTextEvent("Go has goroutines. They are cheap "),
+		sse.NewCitationEvent(1),
+		sse.NewCitationEvent(2),
+		sse.NewTextEvent(". Example:\n"),
+		sse.NewCodeBlockEvent("```go\ngo f()\n```"),
+	)
+
+	annotated := a.Annotate()
+
+	expectedText := "Go has goroutines. They are cheap . Example:\n\n```go\ngo f()\n```"
+	if annotated.Text != expectedText {
+		t.Fatalf("Unexpected text. Got: %q, Expected: %q", annotated.Text, expectedText)
+	}
+
+	expectedCitations := []Citation{
+		{DocumentIndex: 1, SpanStart: 19, SpanEnd: 33},
+		{DocumentIndex: 2, SpanStart: 19, SpanEnd: 33},
+	}
+	if len(annotated.Citations) != len(expectedCitations) {
+		t.Fatalf("Unexpected number of citations. Got: %d, Expected: %d", len(annotated.Citations), len(expectedCitations))
+	}
+	for i, c := range annotated.Citations {
+		if c != expectedCitations[i] {
+			t.Errorf("Citation [%d]; Got: %+v, Expected: %+v", i, c, expectedCitations[i])
+		}
+	}
+
+	if len(annotated.CodeBlocks) != 1 {
+		t.Fatalf("Unexpected number of code blocks. Got: %d, Expected: 1", len(annotated.CodeBlocks))
+	}
+	codeBlock := annotated.CodeBlocks[0]
+	if codeBlock.Language != "go" || annotated.Text[codeBlock.Start:codeBlock.End] != codeBlock.Code {
+		t.Errorf("Unexpected code block: %+v", codeBlock)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	a := build(
+		sse.NewTextEvent("Channels connect goroutines"),
+		sse.NewCitationEvent(1),
+		sse.NewTextEvent(". See the notes"),
+		sse.NewCitationEvent(0),
+		sse.NewCitationEvent(1),
+		sse.NewTextEvent("."),
+	)
+	documents := []document.Document{
+		{Passages: []document.Passage{{Text: "Team notes\n on   channels"}}},
+		{WebReference: &document.WebReference{Title: "Effective Go [docs]", Link: "https://go.dev/doc/effective_go"}},
+	}
+
+	expected := "Channels connect goroutines[^2]. See the notes[^1][^2].\n" +
+		"\n[^2]: [Effective Go \\[docs\\]](https://go.dev/doc/effective_go)" +
+		"\n[^1]: Document 1, \"Team notes on channels\""
+
+	if md := a.Markdown(documents); md != expected {
+		t.Errorf("Unexpected Markdown.\nGot:      %q\nExpected: %q", md, expected)
+	}
+}
@@ -0,0 +1,118 @@
+package answer
+
+import (
+	"log/slog"
+	"raglib-demo/api/sse"
+	"strings"
+)
+
+const codeBlockMarker = "```"
+
+// Builder assembles the flat text/citation/codeblock event stream from the ChunkProcessor into an Answer. Blank lines
+// in text start a new paragraph and code blocks always stand on their own.
+type Builder struct {
+	blocks    []Block
+	paragraph []Inline
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// IsAnswerEvent reports whether event is part of the answer itself, rather than metadata sent alongside it
+func IsAnswerEvent(event sse.Event) bool {
+	switch event.EventType {
+	case "text", "citation", "codeblock":
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Builder) Add(event sse.Event) {
+	switch event.EventType {
+	case "text":
+		text, _ := event.Data.(string)
+		b.addText(text)
+	case "citation":
+		index, _ := event.Data.(int)
+		b.paragraph = append(b.paragraph, Inline{Type: InlineTypeCitation, DocumentIndex: index})
+	case "codeblock":
+		code, _ := event.Data.(string)
+		b.finishParagraph()
+		b.blocks = append(b.blocks, parseCodeBlock(code))
+	default:
+		slog.Warn("unexpected event type when building answer", "type", event.EventType)
+	}
+}
+
+func (b *Builder) Answer() Answer {
+	b.finishParagraph()
+
+	blocks := make([]Block, len(b.blocks))
+	copy(blocks, b.blocks)
+	return Answer{Blocks: blocks}
+}
+
+func (b *Builder) addText(text string) {
+	parts := strings.Split(text, "\n\n")
+	for i, part := range parts {
+		if i > 0 {
+			b.finishParagraph()
+		}
+		if part == "" {
+			continue
+		}
+
+		if last := len(b.paragraph) - 1; last >= 0 && b.paragraph[last].Type == InlineTypeText {
+			b.paragraph[last].Text += part
+		} else {
+			b.paragraph = append(b.paragraph, Inline{Type: InlineTypeText, Text: part})
+		}
+	}
+}
+
+// finishParagraph closes the paragraph being built, trimming whitespace at its edges that only separated it from the
+// surrounding blocks
+func (b *Builder) finishParagraph() {
+	defer func() { b.paragraph = nil }()
+
+	inlines := b.paragraph
+	if len(inlines) > 0 && inlines[0].Type == InlineTypeText {
+		inlines[0].Text = strings.TrimLeft(inlines[0].Text, " \t\n")
+		if inlines[0].Text == "" {
+			inlines = inlines[1:]
+		}
+	}
+	if last := len(inlines) - 1; last >= 0 && inlines[last].Type == InlineTypeText {
+		inlines[last].Text = strings.TrimRight(inlines[last].Text, " \t\n")
+		if inlines[last].Text == "" {
+			inlines = inlines[:last]
+		}
+	}
+
+	if len(inlines) == 0 {
+		return
+	}
+	b.blocks = append(b.blocks, Block{Type: BlockTypeParagraph, Inlines: inlines})
+}
+
+// parseCodeBlock splits a fenced code block, ie "```go\nfmt.Println()\n```", into its language tag and code. Blocks
+// cut off before the closing fence keep whatever code was generated.
+func parseCodeBlock(raw string) Block {
+	raw = strings.TrimLeft(raw, " \t")
+	raw = strings.TrimPrefix(raw, codeBlockMarker)
+
+	fenceLine, body, _ := strings.Cut(raw, "\n")
+
+	body = strings.TrimRight(body, " \t")
+	body = strings.TrimSuffix(body, codeBlockMarker)
+	body = strings.TrimRight(body, " \t\n")
+	body = strings.TrimLeft(body, "\n")
+
+	return Block{
+		Type:     BlockTypeCodeBlock,
+		Language: strings.TrimSpace(fenceLine),
+		Code:     body,
+	}
+}
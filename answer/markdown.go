@@ -0,0 +1,87 @@
+package answer
+
+import (
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"strings"
+)
+
+// footnoteSnippetLength caps how much passage text is used to describe documents without a web reference
+const footnoteSnippetLength = 80
+
+// Markdown renders the answer with citations as footnote references, ie "[^1]", followed by a footnote for each cited
+// document. Footnotes are numbered from 1, so document index 0 becomes "[^1]".
+func (a Answer) Markdown(documents []document.Document) string {
+	var sb strings.Builder
+
+	for i, block := range a.Blocks {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+
+		switch block.Type {
+		case BlockTypeCodeBlock:
+			sb.WriteString(block.fenced())
+		case BlockTypeParagraph:
+			for _, inline := range block.Inlines {
+				if inline.Type == InlineTypeCitation {
+					sb.WriteString(footnoteReference(inline.DocumentIndex))
+				} else {
+					sb.WriteString(inline.Text)
+				}
+			}
+		}
+	}
+
+	cited := a.CitedDocumentIndices()
+	if len(cited) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, index := range cited {
+		sb.WriteString(fmt.Sprintf("\n%s: %s", footnoteReference(index), describeDocument(index, documents)))
+	}
+
+	return sb.String()
+}
+
+func footnoteReference(documentIndex int) string {
+	return fmt.Sprintf("[^%d]", documentIndex+1)
+}
+
+func describeDocument(index int, documents []document.Document) string {
+	if index < 0 || index >= len(documents) {
+		return "Unknown source"
+	}
+
+	d := documents[index]
+	if d.WebReference != nil && d.WebReference.Link != "" {
+		title := d.WebReference.Title
+		if title == "" {
+			title = d.WebReference.Link
+		}
+		return fmt.Sprintf("[%s](%s)", escapeLinkText(title), d.WebReference.Link)
+	}
+
+	for _, p := range d.Passages {
+		text := strings.Join(strings.Fields(p.Text), " ")
+		if text == "" {
+			continue
+		}
+		if len(text) > footnoteSnippetLength {
+			text = strings.TrimSpace(truncateToRuneBoundary(text, footnoteSnippetLength)) + "…"
+		}
+		return fmt.Sprintf("Document %d, \"%s\"", index+1, text)
+	}
+	return fmt.Sprintf("Document %d", index+1)
+}
+
+func escapeLinkText(s string) string {
+	return strings.NewReplacer("[", "\\[", "]", "\\]").Replace(s)
+}
+
+func truncateToRuneBoundary(s string, n int) string {
+	for n > 0 && n < len(s) && (s[n]&0xC0) == 0x80 {
+		n--
+	}
+	return s[:n]
+}
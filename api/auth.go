@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"math"
+	"net/http"
+	"raglib-demo/auth"
+	"raglib-demo/corpus"
+	"strconv"
+)
+
+// apiKeyQueryParam lets EventSource clients, which can't set headers, send their API key
+const apiKeyQueryParam = "api_key"
+
+// apiKeyFromQuery moves an API key sent as a query parameter into the X-API-Key header, before anything logs the URL
+func apiKeyFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if key := query.Get(apiKeyQueryParam); key != "" {
+			query.Del(apiKeyQueryParam)
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+			if r.Header.Get(auth.APIKeyHeader) == "" {
+				r.Header.Set(auth.APIKeyHeader, key)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate rejects requests without a valid API key, or that are over their key's rate limit. It does nothing
+// when the server has no keyring.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.keyring == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := s.keyring.Authenticate(auth.Credential(r))
+		var rateLimited *auth.RateLimitError
+		if errors.As(err, &rateLimited) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+			render.Render(w, r, TooManyRequests(fmt.Sprintf("key, %v, is over its rate limit", principal.Name)))
+			return
+		} else if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="raglib"`)
+			render.Render(w, r, Unauthorized(err.Error()))
+			return
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("auth.principal", principal.Name))
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// principalName is the name of the request's API key, it's empty when the server has no keyring
+func principalName(ctx context.Context) string {
+	if principal := auth.PrincipalFrom(ctx); principal != nil {
+		return principal.Name
+	}
+	return ""
+}
+
+// authorizeCorpora returns an error if the request's principal may not search every corpus in corpora
+func authorizeCorpora(r *http.Request, corpora []string) error {
+	principal := auth.PrincipalFrom(r.Context())
+	if principal == nil {
+		return nil
+	}
+
+	for _, c := range corpora {
+		if !principal.CanAccess(c) {
+			return fmt.Errorf("key, %v, may not search corpus, %v", principal.Name, c)
+		}
+	}
+	return nil
+}
+
+// useQuota counts a search against the request's principal's daily quota
+func (s *Server) useQuota(r *http.Request) error {
+	principal := auth.PrincipalFrom(r.Context())
+	if s.keyring == nil || principal == nil {
+		return nil
+	}
+
+	if err := s.keyring.UseQuota(principal); err != nil {
+		return fmt.Errorf("key, %v: %w", principal.Name, err)
+	}
+	return nil
+}
+
+// ingestionCorpora lists the corpora that search the collection documents are ingested into, adding documents
+// requires access to all of them
+func (s *Server) ingestionCorpora() []string {
+	var names []string
+	for _, definition := range s.corpora.Definitions() {
+		for _, rc := range definition.Retrievers {
+			if rc.Type == corpus.TypeQdrant && rc.Collection == PersonalCollectionName {
+				names = append(names, definition.Name)
+				break
+			}
+		}
+	}
+	return names
+}
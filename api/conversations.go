@@ -0,0 +1,250 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"raglib-demo/answer"
+	"raglib-demo/conversation"
+	"raglib-demo/filter"
+	"raglib-demo/fusion"
+	"raglib-demo/grounding"
+	"raglib-demo/queryplan"
+	"raglib-demo/rerank"
+	"time"
+)
+
+type CreateConversationRequest struct {
+	// Corpora are searched for messages that don't name their own
+	Corpora []string `json:"corpus"`
+}
+
+func (req *CreateConversationRequest) Bind(r *http.Request) error {
+	if len(req.Corpora) == 0 {
+		return fmt.Errorf("at least one 'corpus' is required")
+	}
+	return nil
+}
+
+type ConversationMessageRequest struct {
+	Message string   `json:"message"`
+	Corpora []string `json:"corpus,omitempty"`
+	Fusion  string   `json:"fusion,omitempty"`
+	// Weights are per-source fusion weights, ie {"exa": 2}
+	Weights map[string]float64 `json:"weights,omitempty"`
+	// Grounding is how citations are scored for support, see grounding.ParseMethod
+	Grounding string `json:"grounding,omitempty"`
+	// Filter narrows retrieval from ingested documents, the same as /search's filter parameters
+	Filter *FilterRequest `json:"filter,omitempty"`
+	// Rerank and RerankCutoff override the server's default reranking, see rerank.ParseMethod
+	Rerank       string   `json:"rerank,omitempty"`
+	RerankCutoff *float64 `json:"rerankCutoff,omitempty"`
+	// Plan overrides the server's default query planning, see queryplan.ParseMethod
+	Plan string `json:"plan,omitempty"`
+	// Model, Temperature, MaxTokens and Style override how the answer is generated, the same as /search's
+	// parameters. Model has to be one the server allows.
+	Model       string   `json:"model,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"maxTokens,omitempty"`
+	Style       string   `json:"style,omitempty"`
+
+	filter filter.Filter
+}
+
+type FilterRequest struct {
+	Tags         []string `json:"tags,omitempty"`
+	SourcePrefix string   `json:"sourcePrefix,omitempty"`
+	Author       string   `json:"author,omitempty"`
+	// After and Before are dates as accepted by filter.ParseDate, ie "2024-06-01" or "6mo"
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+func (req *ConversationMessageRequest) Bind(r *http.Request) error {
+	if len(req.Message) == 0 {
+		return fmt.Errorf("'message' is required")
+	}
+	if _, err := fusion.New(req.Fusion, req.Weights); err != nil {
+		return err
+	}
+	if err := fusion.ValidateWeights(req.Weights); err != nil {
+		return fmt.Errorf("'weights' is invalid: %w", err)
+	}
+	method, err := grounding.ParseMethod(req.Grounding)
+	if err != nil {
+		return err
+	}
+	req.Grounding = method
+
+	if req.Filter != nil {
+		f, err := parseFilter(req.Filter.Tags, req.Filter.SourcePrefix, req.Filter.Author, req.Filter.After, req.Filter.Before)
+		if err != nil {
+			return fmt.Errorf("'filter' is invalid: %w", err)
+		}
+		req.filter = f
+	}
+
+	if req.Rerank != "" {
+		if _, err := rerank.ParseMethod(req.Rerank); err != nil {
+			return err
+		}
+	}
+	if req.RerankCutoff != nil {
+		if err := rerank.ValidateCutoff(*req.RerankCutoff); err != nil {
+			return err
+		}
+	}
+	if req.Plan != "" {
+		if _, err := queryplan.ParseMethod(req.Plan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateConversationRequest
+	if err := render.Bind(r, &req); err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+	if _, err := s.lookupCorpora(req.Corpora); err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+	if err := authorizeCorpora(r, req.Corpora); err != nil {
+		render.Render(w, r, Forbidden(err.Error()))
+		return
+	}
+
+	c, err := s.conversations.Create(principalName(r.Context()), req.Corpora)
+	if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, c)
+}
+
+func (s *Server) getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := s.getOwnConversation(r)
+	if errors.Is(err, conversation.ErrNotFound) {
+		render.Render(w, r, NotFound(err.Error()))
+		return
+	} else if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+	// Turns include documents from the conversation's corpora
+	if err := authorizeCorpora(r, c.Corpora); err != nil {
+		render.Render(w, r, Forbidden(err.Error()))
+		return
+	}
+
+	render.JSON(w, r, c)
+}
+
+// conversationMessageHandler answers a message in the context of the conversation so far. The response is the same
+// SSE stream, or JSON, as /search.
+func (s *Server) conversationMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		s.resumeSearch(w, r, lastEventID)
+		return
+	}
+
+	c, err := s.getOwnConversation(r)
+	if errors.Is(err, conversation.ErrNotFound) {
+		render.Render(w, r, NotFound(err.Error()))
+		return
+	} else if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+
+	var req ConversationMessageRequest
+	if err := render.Bind(r, &req); err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+
+	stream, err := wantsStream(r)
+	if err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+
+	settings, err := generationSettings(s.cfg.Models, req.Model, req.Temperature, req.MaxTokens, req.Style)
+	if err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+
+	corpora := req.Corpora
+	if len(corpora) == 0 {
+		corpora = c.Corpora
+	}
+	if err := errors.Join(authorizeCorpora(r, corpora), authorizeCorpora(r, c.Corpora)); err != nil {
+		render.Render(w, r, Forbidden(err.Error()))
+		return
+	}
+	// Rewriting is a model call too, so it only happens within the quota
+	if err := s.useQuota(r); err != nil {
+		render.Render(w, r, TooManyRequests(err.Error()))
+		return
+	}
+
+	standaloneQuery, err := s.queryRewriter.Rewrite(r.Context(), c.Turns, req.Message)
+	if err != nil {
+		// Retrieving with the raw follow-up is worse, but better than failing the message
+		slog.Warn("failed to rewrite follow-up question, retrieving with it as is", "conversation", c.ID, "err", err)
+		standaloneQuery = req.Message
+	}
+
+	params := searchParams{
+		query:          standaloneQuery,
+		prompt:         conversation.Prompt(c.Turns, req.Message),
+		corpora:        corpora,
+		fusionStrategy: req.Fusion,
+		fusionWeights:  req.Weights,
+		stream:         stream,
+		grounding:      req.Grounding,
+		filter:         req.filter,
+		rerank:         req.Rerank,
+		rerankCutoff:   req.RerankCutoff,
+		queryPlanning:  req.Plan,
+		generation:     settings,
+		onAnswered: func(documents []document.Document, a answer.Answer) {
+			turn := conversation.Turn{
+				Question:        req.Message,
+				StandaloneQuery: standaloneQuery,
+				Corpora:         corpora,
+				Documents:       documents,
+				Answer:          a.Annotate().Text,
+				AnsweredAt:      time.Now().UTC(),
+			}
+			if err := s.conversations.AppendTurn(c.ID, turn); err != nil {
+				slog.Error("failed to record conversation turn", "conversation", c.ID, "err", err)
+			}
+		},
+	}
+
+	s.search(w, r, params)
+}
+
+// getOwnConversation gets the request's conversation if it was started with the request's API key. Other keys'
+// conversations are reported as missing, like their searches in the history.
+func (s *Server) getOwnConversation(r *http.Request) (conversation.Conversation, error) {
+	c, err := s.conversations.Get(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		return conversation.Conversation{}, err
+	}
+	if c.Principal != principalName(r.Context()) {
+		return conversation.Conversation{}, conversation.ErrNotFound
+	}
+	return c, nil
+}
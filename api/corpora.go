@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"github.com/coopslarhette/raglib/lib/retrieval/exa"
+	"github.com/coopslarhette/raglib/lib/retrieval/serp"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"raglib-demo/cache"
+	"raglib-demo/corpus"
+	"raglib-demo/fixtures"
+	"raglib-demo/metrics"
+	"raglib-demo/qdrantsearch"
+	"raglib-demo/tracing"
+)
+
+type CorporaResponse struct {
+	Corpora []corpus.Definition `json:"corpora"`
+}
+
+// corporaHandler lists the corpora the request's key may search
+func (s *Server) corporaHandler(w http.ResponseWriter, r *http.Request) {
+	corpora := []corpus.Definition{}
+	for _, definition := range s.corpora.Definitions() {
+		if authorizeCorpora(r, []string{definition.Name}) == nil {
+			corpora = append(corpora, definition)
+		}
+	}
+
+	render.JSON(w, r, CorporaResponse{Corpora: corpora})
+}
+
+func (s *Server) retrieverFactories() map[string]corpus.RetrieverFactory {
+	exaClient := exa.NewClient(s.cfg.APIKeys.Exa, s.httpClient)
+	serpClient := serp.NewClient(s.cfg.APIKeys.SERP, s.httpClient)
+
+	factories := map[string]corpus.RetrieverFactory{
+		corpus.TypeQdrant: func(config corpus.RetrieverConfig) (retrieval.Retriever, error) {
+			if config.Collection == "" {
+				return nil, fmt.Errorf("qdrant retrievers require a collection")
+			}
+			if s.qdrantPointsClient == nil {
+				return nil, fmt.Errorf("qdrant retrievers require a Qdrant client")
+			}
+			r := qdrantsearch.NewRetriever(s.qdrantPointsClient, s.embedder, config.Collection, qdrantsearch.KeywordFilter(config.Filters))
+			if config.Hybrid == nil {
+				return r, nil
+			}
+			if s.denseOnlyCollections[config.Collection] {
+				slog.Warn("collection has no sparse vectors, hybrid search falls back to dense search", "collection", config.Collection)
+				return r, nil
+			}
+			return r.WithHybrid(qdrantsearch.HybridWeights{Dense: config.Hybrid.Dense, Sparse: config.Hybrid.Sparse}), nil
+		},
+		corpus.TypeExa: func(config corpus.RetrieverConfig) (retrieval.Retriever, error) {
+			return exa.NewRetriever(exaClient), nil
+		},
+		corpus.TypeSERP: func(config corpus.RetrieverConfig) (retrieval.Retriever, error) {
+			return serp.NewRetriever(serpClient), nil
+		},
+	}
+
+	for retrieverType, factory := range s.retrieverFactoryOverrides {
+		factories[retrieverType] = factory
+	}
+	return factories
+}
+
+// cachedRetrieverFactories wraps every retriever in the retrieval cache, metrics are recorded for the queries that
+// miss it and every query gets a span. Fixtures are recorded outside the cache, so cache hits are recorded too, and
+// replayed retrievers aren't built at all.
+func (s *Server) cachedRetrieverFactories() map[string]corpus.RetrieverFactory {
+	factories := s.retrieverFactories()
+	for retrieverType, factory := range factories {
+		factory := factory
+		factories[retrieverType] = func(config corpus.RetrieverConfig) (retrieval.Retriever, error) {
+			var r retrieval.Retriever
+			if s.fixtureMode != fixtures.ModeReplay {
+				built, err := factory(config)
+				if err != nil {
+					return nil, err
+				}
+				instrumented := metrics.NewRetriever(built, config.SourceName(), s.metrics)
+				r = cache.NewRetriever(instrumented, s.retrievalCache, config.Identity(), retrievalCacheTTL)
+			}
+			if s.fixtures != nil {
+				r = fixtures.NewRetriever(r, s.fixtures, s.fixtureMode, config.SourceName(), config.Identity())
+			}
+			return tracing.NewRetriever(r, config.SourceName()), nil
+		}
+	}
+	return factories
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"github.com/go-chi/render"
+	"net/http"
+	"raglib-demo/ingestion"
+	"strings"
+	"time"
+)
+
+type IngestDocumentsRequest struct {
+	Documents []IngestDocument `json:"documents"`
+}
+
+type IngestDocument struct {
+	Content string `json:"content"`
+	// Format is one of "text", "markdown" or "html", defaults to "text"
+	Format string `json:"format"`
+	Source string `json:"source"`
+	Title  string `json:"title"`
+	// Tags, Author and Date can be filtered on at query time, see filter.Filter
+	Tags   []string `json:"tags,omitempty"`
+	Author string   `json:"author,omitempty"`
+	// Date is when the document was written, RFC 3339 or YYYY-MM-DD, it defaults to the time of ingestion
+	Date string `json:"date,omitempty"`
+}
+
+type IngestDocumentsResponse struct {
+	Documents []ingestion.IngestedDocument `json:"documents"`
+}
+
+func (req *IngestDocumentsRequest) Bind(r *http.Request) error {
+	if len(req.Documents) == 0 {
+		return fmt.Errorf("at least one document is required")
+	}
+
+	for i, d := range req.Documents {
+		if len(d.Content) == 0 {
+			return fmt.Errorf("document %d is missing 'content'", i)
+		}
+		if len(d.Source) == 0 {
+			return fmt.Errorf("document %d is missing 'source'", i)
+		}
+		if _, err := ingestion.ParseFormat(d.Format); err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+		if _, err := parseDocumentDate(d.Date); err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) ingestDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeCorpora(r, s.ingestionCorpora()); err != nil {
+		render.Render(w, r, Forbidden(err.Error()))
+		return
+	}
+
+	var req IngestDocumentsRequest
+	if err := render.Bind(r, &req); err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+
+	sources := make([]ingestion.Source, 0, len(req.Documents))
+	for _, d := range req.Documents {
+		// Already validated in Bind
+		format, _ := ingestion.ParseFormat(d.Format)
+		date, _ := parseDocumentDate(d.Date)
+		sources = append(sources, ingestion.Source{
+			Content: d.Content,
+			Format:  format,
+			Source:  d.Source,
+			Title:   d.Title,
+			Tags:    d.Tags,
+			Author:  strings.TrimSpace(d.Author),
+			Date:    date,
+		})
+	}
+
+	if s.ingestionPipeline == nil {
+		render.Render(w, r, InternalServerError("document ingestion requires a Qdrant client"))
+		return
+	}
+
+	ingested, err := s.ingestionPipeline.Ingest(r.Context(), sources)
+	if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, IngestDocumentsResponse{Documents: ingested})
+}
+
+// parseDocumentDate parses an optional document date, the zero time if there isn't one
+func parseDocumentDate(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'date', %q, is invalid, expected RFC 3339 or YYYY-MM-DD", raw)
+}
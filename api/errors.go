@@ -12,6 +12,10 @@ const (
 	ErrCodeUnknown ErrorCode = iota
 	ErrCodeMalformedRequest
 	ErrCodeInternalServer
+	ErrCodeNotFound
+	ErrCodeUnauthorized
+	ErrCodeForbidden
+	ErrCodeTooManyRequests
 )
 
 type ErrResponse struct {
@@ -45,6 +49,42 @@ func MalformedRequest(details string) render.Renderer {
 	)
 }
 
+func NotFound(details string) render.Renderer {
+	return NewErrorResponse(
+		http.StatusNotFound,
+		ErrCodeNotFound,
+		"Not found",
+		details,
+	)
+}
+
+func Unauthorized(details string) render.Renderer {
+	return NewErrorResponse(
+		http.StatusUnauthorized,
+		ErrCodeUnauthorized,
+		"Unauthorized",
+		details,
+	)
+}
+
+func Forbidden(details string) render.Renderer {
+	return NewErrorResponse(
+		http.StatusForbidden,
+		ErrCodeForbidden,
+		"Forbidden",
+		details,
+	)
+}
+
+func TooManyRequests(details string) render.Renderer {
+	return NewErrorResponse(
+		http.StatusTooManyRequests,
+		ErrCodeTooManyRequests,
+		"Too many requests",
+		details,
+	)
+}
+
 func InternalServerError(details string) render.Renderer {
 	return NewErrorResponse(
 		http.StatusInternalServerError,
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"net/http"
+	"raglib-demo/history"
+	"time"
+	"unicode/utf8"
+)
+
+// maxFeedbackCommentLength is in characters
+const maxFeedbackCommentLength = 2000
+
+type FeedbackRequest struct {
+	SearchID string `json:"searchId"`
+	// Rating is "up" or "down"
+	Rating  string `json:"rating"`
+	Comment string `json:"comment,omitempty"`
+	// IrrelevantCitations are indexes into the search's citations, as in its JSON response or history record, whose
+	// source was irrelevant
+	IrrelevantCitations []int `json:"irrelevantCitations,omitempty"`
+}
+
+func (req *FeedbackRequest) Bind(r *http.Request) error {
+	if req.SearchID == "" {
+		return fmt.Errorf("'searchId' is required")
+	}
+	if _, err := history.ParseRating(req.Rating); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(req.Comment) > maxFeedbackCommentLength {
+		return fmt.Errorf("'comment' can be at most %d characters", maxFeedbackCommentLength)
+	}
+	return nil
+}
+
+// feedbackHandler attaches a rating, and optionally a comment and irrelevant sources, to a recorded search. Sending
+// feedback for the same search again replaces it.
+func (s *Server) feedbackHandler(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		render.Render(w, r, NotFound("search history is disabled, so feedback can't be recorded"))
+		return
+	}
+
+	var req FeedbackRequest
+	if err := render.Bind(r, &req); err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+
+	record, err := s.getOwnSearch(r, req.SearchID)
+	if errors.Is(err, history.ErrNotFound) {
+		render.Render(w, r, NotFound(err.Error()))
+		return
+	} else if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+
+	seen := make(map[int]bool)
+	var irrelevant []int
+	for _, i := range req.IrrelevantCitations {
+		if i < 0 || i >= len(record.Citations) {
+			render.Render(w, r, MalformedRequest(fmt.Sprintf("'irrelevantCitations' has %d but the search has %d citations", i, len(record.Citations))))
+			return
+		}
+		if !seen[i] {
+			seen[i] = true
+			irrelevant = append(irrelevant, i)
+		}
+	}
+
+	feedback := history.Feedback{
+		Rating:              req.Rating,
+		Comment:             req.Comment,
+		IrrelevantCitations: irrelevant,
+		CreatedAt:           time.Now().UTC(),
+	}
+	if err := s.history.SetFeedback(r.Context(), record.ID, feedback); errors.Is(err, history.ErrNotFound) {
+		render.Render(w, r, NotFound(err.Error()))
+		return
+	} else if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, feedback)
+}
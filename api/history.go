@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"raglib-demo/answer"
+	"raglib-demo/auth"
+	"raglib-demo/history"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type HistoryResponse struct {
+	Searches []history.Record `json:"searches"`
+}
+
+// recordSearch saves a search to the history. Failing to record a search doesn't fail it, so errors are only logged.
+func (s *Server) recordSearch(ctx context.Context, id string, params searchParams, started time.Time, retrieved retrievalResult, a answer.Answer, verification verificationResult, searchErr error) {
+	if s.history == nil {
+		return
+	}
+
+	annotated := a.Annotate()
+	record := history.Record{
+		ID:        id,
+		CreatedAt: started.UTC(),
+		Query:     params.query,
+		Corpora:   params.corpora,
+		Documents: retrieved.documents,
+		Answer:    annotated.Text,
+		Citations: annotated.Citations,
+		Model:     params.generation.Model.String(),
+		LatencyMs: time.Since(started).Milliseconds(),
+	}
+	if principal := auth.PrincipalFrom(ctx); principal != nil {
+		record.Principal = principal.Name
+	}
+	if verification.metadata != nil {
+		record.Cached = verification.metadata.Cached
+	}
+	if searchErr != nil {
+		record.Error = searchErr.Error()
+	}
+
+	// The client may already be gone, the search should be recorded regardless
+	if err := s.history.Save(context.WithoutCancel(ctx), record); err != nil {
+		slog.Error("failed to record search", "search", id, "err", err)
+	}
+}
+
+// listHistoryHandler lists past searches, most recent first. With authentication, a key only sees its own searches.
+func (s *Server) listHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		render.Render(w, r, NotFound("search history is disabled"))
+		return
+	}
+
+	limit, err := parseBoundedInt(r.URL.Query().Get("limit"), "limit", defaultHistoryLimit, 1, maxHistoryLimit)
+	if err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+	offset, err := parseBoundedInt(r.URL.Query().Get("offset"), "offset", 0, 0, -1)
+	if err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
+
+	q := history.Query{Limit: limit, Offset: offset}
+	if principal := auth.PrincipalFrom(r.Context()); principal != nil {
+		q.Principal = principal.Name
+	}
+
+	records, err := s.history.List(r.Context(), q)
+	if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+	if records == nil {
+		records = []history.Record{}
+	}
+
+	render.JSON(w, r, HistoryResponse{Searches: records})
+}
+
+func (s *Server) getHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		render.Render(w, r, NotFound("search history is disabled"))
+		return
+	}
+
+	record, err := s.getOwnSearch(r, chi.URLParam(r, "searchID"))
+	if errors.Is(err, history.ErrNotFound) {
+		render.Render(w, r, NotFound(err.Error()))
+		return
+	} else if err != nil {
+		render.Render(w, r, InternalServerError(err.Error()))
+		return
+	}
+
+	render.JSON(w, r, record)
+}
+
+// getOwnSearch gets a recorded search made with the request's API key. Other keys' searches are reported as missing,
+// rather than forbidden, so their IDs can't be probed.
+func (s *Server) getOwnSearch(r *http.Request, id string) (history.Record, error) {
+	record, err := s.history.Get(r.Context(), id)
+	if err != nil {
+		return history.Record{}, err
+	}
+	if principal := auth.PrincipalFrom(r.Context()); principal != nil && record.Principal != principal.Name {
+		return history.Record{}, history.ErrNotFound
+	}
+	return record, nil
+}
+
+// parseBoundedInt parses an optional integer parameter, max is ignored when it's negative
+func parseBoundedInt(raw, name string, fallback, min, max int) (int, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min || (max >= 0 && v > max) {
+		if max >= 0 {
+			return 0, fmt.Errorf("'%v', %q, must be a whole number from %d to %d", name, raw, min, max)
+		}
+		return 0, fmt.Errorf("'%v', %q, must be a whole number of at least %d", name, raw, min)
+	}
+	return v, nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"net/http"
+	"raglib-demo/auth"
+	"raglib-demo/corpus"
+	"raglib-demo/history"
+	"raglib-demo/ingestion"
+	"raglib-demo/llm"
+	"raglib-demo/models"
+)
+
+// Generator streams an answer to query grounded in documents with settings, sending raw chunks, citations still
+// inline, to chunks. It closes chunks once it's done and returns the tokens used. models.Generator is the real
+// implementation.
+type Generator interface {
+	Generate(ctx context.Context, query string, documents []document.Document, settings models.Settings, chunks chan<- string) (models.Usage, error)
+}
+
+// Option swaps out one of the Server's external dependencies, anything left unset is built from the config
+type Option func(*Server)
+
+// WithPointsClient sets the Qdrant client used by qdrant retrievers and document ingestion. Without one, corpora
+// can't use qdrant retrievers and POST /documents isn't available.
+func WithPointsClient(points qdrant.PointsClient) Option {
+	return func(s *Server) {
+		s.qdrantPointsClient = points
+	}
+}
+
+// WithDenseOnlyCollections marks Qdrant collections that don't have the ingestion.SparseVectorName vector, ie ones
+// created before hybrid search. Hybrid retrievers over them fall back to dense search, and ingestion into them only
+// writes dense vectors.
+func WithDenseOnlyCollections(collectionNames ...string) Option {
+	return func(s *Server) {
+		if s.denseOnlyCollections == nil {
+			s.denseOnlyCollections = make(map[string]bool)
+		}
+		for _, name := range collectionNames {
+			s.denseOnlyCollections[name] = true
+		}
+	}
+}
+
+// WithHTTPClient sets the client used for web search APIs. The default client propagates trace context.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Server) {
+		s.httpClient = client
+	}
+}
+
+func WithGenerator(generator Generator) Option {
+	return func(s *Server) {
+		s.generator = generator
+	}
+}
+
+// WithCompleter sets the model used for auxiliary calls, like query rewriting and judging citations
+func WithCompleter(completer llm.Completer) Option {
+	return func(s *Server) {
+		s.completer = completer
+	}
+}
+
+// WithHistory sets where searches are recorded, instead of the store in the config
+func WithHistory(store history.Store) Option {
+	return func(s *Server) {
+		s.history = store
+	}
+}
+
+func WithEmbedder(embedder ingestion.Embedder) Option {
+	return func(s *Server) {
+		s.embedder = embedder
+	}
+}
+
+// WithKeyring requires every request, other than /health and /metrics, to have one of keyring's API keys. Without
+// it the API is open.
+func WithKeyring(keyring *auth.Keyring) Option {
+	return func(s *Server) {
+		s.keyring = keyring
+	}
+}
+
+// WithRetrieverFactory replaces how retrievers of retrieverType are built, ie to point a corpus's exa retriever at
+// a fake. Retrievers built by it are still cached.
+func WithRetrieverFactory(retrieverType string, factory corpus.RetrieverFactory) Option {
+	return func(s *Server) {
+		if s.retrieverFactoryOverrides == nil {
+			s.retrieverFactoryOverrides = make(map[string]corpus.RetrieverFactory)
+		}
+		s.retrieverFactoryOverrides[retrieverType] = factory
+	}
+}
@@ -2,24 +2,39 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/coopslarhette/raglib/lib/document"
-	"github.com/coopslarhette/raglib/lib/generation"
-	"github.com/coopslarhette/raglib/lib/retrieval"
-	"github.com/coopslarhette/raglib/lib/retrieval/exa"
-	"github.com/coopslarhette/raglib/lib/retrieval/qdrant"
-	"github.com/coopslarhette/raglib/lib/retrieval/serp"
 	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 	"log/slog"
 	"net/http"
+	"raglib-demo/answer"
 	"raglib-demo/api/sse"
+	"raglib-demo/assembly"
+	"raglib-demo/cache"
+	"raglib-demo/config"
+	"raglib-demo/corpus"
+	"raglib-demo/filter"
+	"raglib-demo/fixtures"
+	"raglib-demo/fusion"
+	"raglib-demo/grounding"
+	"raglib-demo/metrics"
+	"raglib-demo/models"
+	"raglib-demo/queryplan"
+	"raglib-demo/rerank"
+	"raglib-demo/tracing"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
-type SearchResponse struct {
-	Answer string `json:"answer"`
-}
+// PersonalCollectionName is the Qdrant collection backing the "personal" corpus, documents are written to it via
+// POST /documents
+const PersonalCollectionName = "text_collection"
 
 type TextChunk struct {
 	Type  string `json:"type"`
@@ -31,67 +46,304 @@ type CitationChunk struct {
 	Value int    `json:"value"`
 }
 
-func validateAndExtractParams(r *http.Request) (query string, corpora []string, err error) {
+type searchParams struct {
+	// query is what's retrieved with
+	query string
+	// prompt is what the answerer is asked, it defaults to query but can carry extra context like earlier
+	// conversation turns
+	prompt  string
+	corpora []string
+	// fusionStrategy and fusionWeights override the selected corpora's fusion policy when set
+	fusionStrategy string
+	fusionWeights  map[string]float64
+	// stream is false when the client asked for a single JSON response instead of an SSE stream
+	stream bool
+	// grounding is how citations are scored for support, one of the grounding.Method* values
+	grounding string
+	// filter narrows retrieval from ingested documents by their metadata
+	filter filter.Filter
+	// rerank and rerankCutoff override the server's default reranking when set
+	rerank       string
+	rerankCutoff *float64
+	// queryPlanning overrides the server's default query planning when set, one of the queryplan.Method* values
+	queryPlanning string
+	// generation is the model and parameters the answer is generated with, resolved against the server's allow-list
+	generation models.Settings
+	// onAnswered, when set, is called once the answer has been generated successfully
+	onAnswered func(documents []document.Document, a answer.Answer)
+}
+
+func validateAndExtractParams(r *http.Request, modelConfig config.ModelConfig) (searchParams, error) {
 	queryParams := r.URL.Query()
-	query = queryParams.Get("q")
-	corpora = queryParams["corpus"]
+	query := queryParams.Get("q")
+	corpora := queryParams["corpus"]
 
 	if len(corpora) == 0 {
-		return "", nil, fmt.Errorf("at least one 'corpus' parameter is required")
+		return searchParams{}, fmt.Errorf("at least one 'corpus' parameter is required")
 	}
 	if len(query) == 0 {
-		return "", nil, fmt.Errorf("query parameter, 'q', is required")
+		return searchParams{}, fmt.Errorf("query parameter, 'q', is required")
+	}
+
+	weights, err := fusion.ParseWeights(queryParams.Get("weights"))
+	if err != nil {
+		return searchParams{}, fmt.Errorf("query parameter, 'weights', is invalid: %w", err)
+	}
+	strategy := queryParams.Get("fusion")
+	if _, err := fusion.New(strategy, weights); err != nil {
+		return searchParams{}, err
+	}
+
+	stream, err := wantsStream(r)
+	if err != nil {
+		return searchParams{}, err
+	}
+
+	groundingMethod, err := grounding.ParseMethod(queryParams.Get("grounding"))
+	if err != nil {
+		return searchParams{}, err
+	}
+
+	f, err := parseFilter(queryParams["tag"], queryParams.Get("source_prefix"), queryParams.Get("author"), queryParams.Get("after"), queryParams.Get("before"))
+	if err != nil {
+		return searchParams{}, err
+	}
+
+	rerankMethod, rerankCutoff, err := parseRerank(queryParams.Get("rerank"), queryParams.Get("rerank_cutoff"))
+	if err != nil {
+		return searchParams{}, err
+	}
+
+	var planning string
+	if raw := queryParams.Get("plan"); raw != "" {
+		if planning, err = queryplan.ParseMethod(raw); err != nil {
+			return searchParams{}, err
+		}
+	}
+
+	var temperature *float32
+	if raw := queryParams.Get("temperature"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 32)
+		if err != nil {
+			return searchParams{}, fmt.Errorf("'temperature' must be a number")
+		}
+		t := float32(parsed)
+		temperature = &t
+	}
+	var maxTokens *int
+	if raw := queryParams.Get("max_tokens"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return searchParams{}, fmt.Errorf("'max_tokens' must be a whole number")
+		}
+		maxTokens = &parsed
+	}
+	settings, err := generationSettings(modelConfig, queryParams.Get("model"), temperature, maxTokens, queryParams.Get("style"))
+	if err != nil {
+		return searchParams{}, err
+	}
+
+	return searchParams{
+		query:          query,
+		prompt:         query,
+		corpora:        corpora,
+		fusionStrategy: strategy,
+		fusionWeights:  weights,
+		stream:         stream,
+		grounding:      groundingMethod,
+		filter:         f,
+		rerank:         rerankMethod,
+		rerankCutoff:   rerankCutoff,
+		queryPlanning:  planning,
+		generation:     settings,
+	}, nil
+}
+
+// generationSettings resolves a request's model and generation parameters against the server's allow-list, anything
+// left unset gets the server's default. raglib's answerer uses its own parameters, so they can't be set for it.
+func generationSettings(modelConfig config.ModelConfig, model string, temperature *float32, maxTokens *int, style string) (models.Settings, error) {
+	settings := models.Settings{Model: modelConfig.Answer}
+	if model != "" {
+		resolved, err := models.Resolve(modelConfig.AllowedModels(), model)
+		if err != nil {
+			return models.Settings{}, err
+		}
+		settings.Model = resolved
 	}
 
-	return query, corpora, nil
+	if !settings.Model.Configurable() {
+		if temperature != nil || maxTokens != nil || style != "" {
+			return models.Settings{}, fmt.Errorf("%v uses raglib's own temperature, max tokens and style, pick another model to set them", settings.Model)
+		}
+		return settings, nil
+	}
+	settings.MaxTokens = modelConfig.MaxTokens
+
+	if temperature != nil {
+		if err := models.ValidateTemperature(settings.Model, *temperature); err != nil {
+			return models.Settings{}, err
+		}
+		settings.Temperature = *temperature
+	}
+
+	if maxTokens != nil {
+		if *maxTokens <= 0 || *maxTokens > modelConfig.MaxTokens {
+			return models.Settings{}, fmt.Errorf("max tokens must be between 1 and %v", modelConfig.MaxTokens)
+		}
+		settings.MaxTokens = *maxTokens
+	}
+
+	parsedStyle, err := models.ParseStyle(style)
+	if err != nil {
+		return models.Settings{}, err
+	}
+	settings.Style = parsedStyle
+	return settings, nil
+}
+
+// parseRerank validates a request's reranking overrides, empty values leave the server's defaults in place
+func parseRerank(method, cutoff string) (string, *float64, error) {
+	if method != "" {
+		if _, err := rerank.ParseMethod(method); err != nil {
+			return "", nil, err
+		}
+	}
+	if cutoff == "" {
+		return method, nil, nil
+	}
+
+	parsed, err := strconv.ParseFloat(cutoff, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("'rerank_cutoff' must be a number")
+	}
+	if err := rerank.ValidateCutoff(parsed); err != nil {
+		return "", nil, err
+	}
+	return method, &parsed, nil
+}
+
+// parseFilter builds the metadata filter from client input, dates can be absolute or relative, see filter.ParseDate
+func parseFilter(tags []string, sourcePrefix, author, after, before string) (filter.Filter, error) {
+	now := time.Now()
+	afterDate, err := filter.ParseDate(after, now)
+	if err != nil {
+		return filter.Filter{}, fmt.Errorf("'after' is invalid: %w", err)
+	}
+	beforeDate, err := filter.ParseDate(before, now)
+	if err != nil {
+		return filter.Filter{}, fmt.Errorf("'before' is invalid: %w", err)
+	}
+	return filter.New(tags, sourcePrefix, author, afterDate, beforeDate)
+}
+
+// wantsStream reports whether the response should be an SSE stream. An explicit 'stream' parameter wins, otherwise
+// clients that accept JSON but not event streams get JSON.
+func wantsStream(r *http.Request) (bool, error) {
+	if raw := r.URL.Query().Get("stream"); raw != "" {
+		stream, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false, fmt.Errorf("query parameter, 'stream', must be a boolean")
+		}
+		return stream, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/event-stream") {
+		return false, nil
+	}
+	return true, nil
 }
 
 func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
-	query, corpora, err := validateAndExtractParams(r)
+	// Browsers reconnect to the same URL with Last-Event-ID set, so a dropped stream picks up its existing session
+	// rather than running the search again
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		s.resumeSearch(w, r, lastEventID)
+		return
+	}
+
+	params, err := validateAndExtractParams(r, s.cfg.Models)
 	if err != nil {
 		render.Render(w, r, MalformedRequest(err.Error()))
 		return
 	}
+	if err := authorizeCorpora(r, params.corpora); err != nil {
+		render.Render(w, r, Forbidden(err.Error()))
+		return
+	}
+	if err := s.useQuota(r); err != nil {
+		render.Render(w, r, TooManyRequests(err.Error()))
+		return
+	}
 
+	s.search(w, r, params)
+}
+
+// search runs retrieval and generation for params, responding with either an SSE stream or JSON. Callers count the
+// search against the quota first, before any model calls of their own.
+func (s *Server) search(w http.ResponseWriter, r *http.Request, params searchParams) {
 	ctx := r.Context()
+	started := time.Now()
 
-	documents, err := s.doRetrieval(ctx, corpora, query)
+	retrieved, err := s.doRetrieval(ctx, params)
 	if err != nil {
+		s.recordSearch(ctx, uuid.NewString(), params, started, retrieved, answer.Answer{}, verificationResult{}, err)
 		render.Render(w, r, InternalServerError(err.Error()))
 		return
 	}
 
-	g, gctx := errgroup.WithContext(ctx)
+	if !params.stream {
+		searchID := uuid.NewString()
+		processedEventChan := make(chan sse.Event, 1)
+		builder := answer.NewBuilder()
+		var verification verificationResult
+
+		generationCtx, cancel := context.WithTimeout(ctx, s.cfg.Retrieval.GenerationTimeout.Duration)
+		defer cancel()
+		g, gctx := errgroup.WithContext(generationCtx)
+		g.Go(func() error {
+			return s.verifiedAnswerEvents(gctx, params, retrieved, processedEventChan)
+		})
+		g.Go(func() error {
+			for event := range processedEventChan {
+				if answer.IsAnswerEvent(event) {
+					builder.Add(event)
+				} else {
+					verification.add(event)
+				}
+			}
+			return nil
+		})
 
-	answerer := generation.NewAnswerer(s.modelProvider)
-	shouldStream := true
+		if err := g.Wait(); err != nil {
+			s.recordSearch(ctx, searchID, params, started, retrieved, builder.Answer(), verification, err)
+			render.Render(w, r, InternalServerError(fmt.Sprintf("error generating answer: %v", err)))
+			return
+		}
 
-	rawChunkChan := make(chan string, 1)
-	processedEventChan := make(chan sse.Event, 1)
+		a := builder.Answer()
+		if params.onAnswered != nil {
+			params.onAnswered(retrieved.documents, a)
+		}
+		s.recordSearch(ctx, searchID, params, started, retrieved, a, verification, nil)
 
-	g.Go(func() error {
-		return answerer.Generate(gctx, query, documents, rawChunkChan, shouldStream)
-	})
+		render.JSON(w, r, newSearchResponse(searchID, a, retrieved, verification))
+		return
+	}
 
-	if !shouldStream {
-		select {
-		case text := <-rawChunkChan:
-			if err := g.Wait(); err != nil {
-				render.Render(w, r, InternalServerError(fmt.Sprintf("error generating answer: %v", err)))
-				return
-			}
-			render.JSON(w, r, SearchResponse{text})
-		case <-gctx.Done():
-			render.Render(w, r, InternalServerError("context cancelled"))
-		}
+	sessionID := uuid.NewString()
+	owner := sse.Owner{Principal: principalName(ctx), Corpora: params.corpora}
+	if err := s.eventLog.Create(sessionID, owner); err != nil {
+		render.Render(w, r, InternalServerError(fmt.Sprintf("error creating search session: %v", err)))
 		return
 	}
 
-	chunkProcessor := ChunkProcessor{}
-	g.Go(func() error {
-		chunkProcessor.ProcessChunks(gctx, rawChunkChan, processedEventChan)
-		return nil
-	})
+	// Generation is detached from the request so it carries on if the client drops and later resumes
+	sessionCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.cfg.Retrieval.GenerationTimeout.Duration)
+	go func() {
+		defer cancel()
+		s.runSession(sessionCtx, sessionID, params, retrieved, started)
+	}()
 
 	stream := sse.NewStream(w)
 	if err := stream.Establish(); err != nil {
@@ -99,170 +351,591 @@ func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	documentsReference := sse.Event{EventType: "documentsreference", Data: documents}
-	if err := stream.Write(documentsReference); err != nil {
-		slog.Error("error occurred writing documents reference to stream", "err", err)
+	if err := s.writeSessionToStream(ctx, stream, sessionID, 0); err != nil {
+		slog.Error("error occurred streaming search session", "session", sessionID, "err", err)
 	}
+}
 
-	g.Go(func() error {
-		return s.writeEventsToStream(gctx, stream, processedEventChan)
-	})
+func (s *Server) resumeSearch(w http.ResponseWriter, r *http.Request, lastEventID string) {
+	sessionID, sequence, err := sse.ParseEventID(lastEventID)
+	if err != nil {
+		render.Render(w, r, MalformedRequest(err.Error()))
+		return
+	}
 
-	if err := g.Wait(); err != nil {
-		slog.Error("error occurred", "err", err)
-		if err := stream.Error("Internal server error occurred."); err != nil {
-			slog.Error("error occurred writing error to stream", "err", err)
-		}
+	// Other keys' sessions are treated as unknown, like their searches in the history, so their IDs can't be probed
+	owner, ok := s.eventLog.Owner(sessionID)
+	if !ok || owner.Principal != principalName(r.Context()) {
+		// 204 tells EventSource to stop reconnecting, re-running the search would duplicate what the client already has
+		slog.Info("client tried to resume unknown search session", "session", sessionID)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if err := authorizeCorpora(r, owner.Corpora); err != nil {
+		render.Render(w, r, Forbidden(err.Error()))
+		return
+	}
+
+	stream := sse.NewStream(w)
+	if err := stream.Establish(); err != nil {
+		render.Render(w, r, InternalServerError(fmt.Sprintf("error establishing stream: %v", err)))
+		return
+	}
+
+	if err := s.writeSessionToStream(r.Context(), stream, sessionID, sequence); err != nil {
+		slog.Error("error occurred resuming search session", "session", sessionID, "err", err)
+	}
+}
+
+// runSession generates the answer for a streamed search, appending every event to the session's log. The search is
+// recorded before the done event, so it's in the history by the time the client has the whole answer.
+func (s *Server) runSession(ctx context.Context, sessionID string, params searchParams, retrieved retrievalResult, started time.Time) {
+	appendEvent := func(e sse.Event) {
+		if _, err := s.eventLog.Append(sessionID, e); err != nil {
+			slog.Error("failed to append event to search session", "session", sessionID, "type", e.EventType, "err", err)
+		}
+	}
+	defer func() {
+		if err := s.eventLog.Finish(sessionID); err != nil {
+			slog.Error("failed to finish search session", "session", sessionID, "err", err)
+		}
+	}()
+
+	if retrieved.plan != nil {
+		appendEvent(sse.Event{EventType: queryplan.EventType, Data: *retrieved.plan})
+	}
+	appendEvent(sse.Event{EventType: "documentsreference", Data: retrieved.referencedDocuments()})
+	if len(retrieved.degraded) > 0 {
+		appendEvent(sse.Event{EventType: "degradedsources", Data: retrieved.degraded})
+	}
+	appendEvent(sse.Event{EventType: assembly.EventType, Data: retrieved.context})
+
+	processedEventChan := make(chan sse.Event, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.verifiedAnswerEvents(ctx, params, retrieved, processedEventChan)
+	}()
+
+	builder := answer.NewBuilder()
+	var verification verificationResult
+	for event := range processedEventChan {
+		if answer.IsAnswerEvent(event) {
+			builder.Add(event)
+		} else {
+			verification.add(event)
+		}
+		appendEvent(event)
+	}
+
+	err := <-errChan
+	if err == nil && params.onAnswered != nil {
+		params.onAnswered(retrieved.documents, builder.Answer())
+	}
+	s.recordSearch(ctx, sessionID, params, started, retrieved, builder.Answer(), verification, err)
+
+	appendEvent(sse.Event{EventType: "done", Data: "DONE"})
+	if err != nil {
+		slog.Error("error occurred generating answer", "session", sessionID, "err", err)
+		appendEvent(sse.NewErrorEvent("Internal server error occurred."))
+	}
 }
 
-func (s *Server) doRetrieval(ctx context.Context, corpora []string, query string) ([]document.Document, error) {
-	retrievers, err := s.determineRetrievers(corpora)
+// verifiedAnswerEvents is answerEvents, over the assembled context, with the citations checked against the whole
+// retrieved documents. Out of range citations are dropped, and citationwarning and groundedness events are
+// added, followed by the metadata event. Verification runs after the answer cache so cached answers are checked the
+// same way.
+func (s *Server) verifiedAnswerEvents(ctx context.Context, params searchParams, retrieved retrievalResult, processedEventChan chan<- sse.Event) error {
+	defer close(processedEventChan)
+
+	answerEventChan := make(chan sse.Event, 1)
+	type answerResult struct {
+		metadata models.Metadata
+		err      error
+	}
+	resultChan := make(chan answerResult, 1)
+	go func() {
+		metadata, err := s.answerEvents(ctx, params, retrieved.context.Documents, answerEventChan)
+		resultChan <- answerResult{metadata, err}
+	}()
+
+	verifier := grounding.NewVerifier(retrieved.documents, s.groundingJudge(params.grounding), params.grounding)
+	verifier.Process(ctx, answerEventChan, processedEventChan)
+
+	result := <-resultChan
+	if result.err != nil {
+		return result.err
+	}
+	// Only complete answers get a groundedness report
+	verifier.Finish(ctx, processedEventChan)
+
+	select {
+	case processedEventChan <- sse.Event{EventType: models.EventType, Data: result.metadata}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (s *Server) groundingJudge(method string) grounding.Judge {
+	switch method {
+	case grounding.MethodLexical:
+		return grounding.LexicalJudge{}
+	case grounding.MethodLLM:
+		return grounding.NewLLMJudge(s.completer)
+	default:
+		return nil
+	}
+}
+
+// answerEvents sends the processed events of the answer to processedEventChan, closing it once done, and returns
+// what the answer was generated with. Answers come from the answer cache when the same query over the same corpora and
+// documents was answered recently with the same settings.
+func (s *Server) answerEvents(ctx context.Context, params searchParams, documents []document.Document, processedEventChan chan<- sse.Event) (models.Metadata, error) {
+	metadata := models.Metadata{Settings: params.generation}
+	cacheKey := cache.AnswerKey(params.prompt, params.generation.Key(), params.corpora, documents)
+
+	var (
+		cached []sse.Event
+		ok     bool
+		err    error
+	)
+	// Recorded searches always generate so the answer ends up in the fixtures
+	if s.fixtureMode != fixtures.ModeRecord {
+		cached, ok, err = s.answerCache.Get(ctx, cacheKey)
+		if err != nil {
+			slog.Warn("answer cache get failed", "err", err)
+		}
+	}
+	if ok {
+		defer close(processedEventChan)
+		metadata.Cached = true
+		for _, event := range cached {
+			select {
+			case processedEventChan <- event:
+			case <-ctx.Done():
+				return metadata, ctx.Err()
+			}
+		}
+		return metadata, nil
+	}
+
+	generatedEventChan := make(chan sse.Event, 1)
+	errChan := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		var err error
+		metadata.Usage, err = s.generateAnswer(ctx, params.prompt, documents, params.generation, generatedEventChan)
+		errChan <- err
+	}()
+
+	var events []sse.Event
+	for event := range generatedEventChan {
+		if len(events) == 0 {
+			s.metrics.ObserveTimeToFirstToken(time.Since(start))
+		}
+		events = append(events, event)
+		processedEventChan <- event
+	}
+	close(processedEventChan)
+
+	err = <-errChan
+	s.metrics.ObserveGeneration(metrics.ErrorOutcome(err), time.Since(start))
+	if err != nil {
+		return metadata, err
+	}
+
+	if err := s.answerCache.Put(ctx, cacheKey, events); err != nil {
+		slog.Warn("answer cache put failed", "err", err)
+	}
+	return metadata, nil
+}
+
+// generateAnswer streams the answer through the ChunkProcessor, processedEventChan is closed once it's done. Answers
+// are always generated as a stream, JSON responses are assembled from the same processed events so they get the same
+// citation and code block handling.
+func (s *Server) generateAnswer(ctx context.Context, query string, documents []document.Document, settings models.Settings, processedEventChan chan<- sse.Event) (models.Usage, error) {
+	g, gctx := errgroup.WithContext(ctx)
+
+	rawChunkChan := make(chan string, 1)
+
+	var usage models.Usage
+	g.Go(func() error {
+		ctx, span := tracing.Start(gctx, "generator.Generate", attribute.Int("generation.documents", len(documents)), attribute.String("generation.model", settings.Model.String()))
+		var err error
+		usage, err = s.generator.Generate(ctx, query, documents, settings, rawChunkChan)
+		span.SetAttributes(attribute.Int("generation.prompt_tokens", usage.PromptTokens), attribute.Int("generation.completion_tokens", usage.CompletionTokens))
+		tracing.End(span, err)
+		return err
+	})
+
+	chunkProcessor := ChunkProcessor{}
+	g.Go(func() error {
+		ctx, span := tracing.Start(gctx, "ChunkProcessor.ProcessChunks")
+		chunkProcessor.ProcessChunks(ctx, rawChunkChan, processedEventChan)
+		span.End()
+		return nil
+	})
+
+	err := g.Wait()
+	return usage, err
+}
+
+func (s *Server) doRetrieval(ctx context.Context, params searchParams) (result retrievalResult, err error) {
+	ctx, span := tracing.Start(ctx, "doRetrieval", attribute.StringSlice("retrieval.corpora", params.corpora), attribute.String("retrieval.filter", params.filter.Key()))
+	defer func() {
+		span.SetAttributes(attribute.Int("retrieval.documents", len(result.documents)), attribute.Int("retrieval.degraded", len(result.degraded)))
+		tracing.End(span, err)
+	}()
+
+	corpora, err := s.lookupCorpora(params.corpora)
+	if err != nil {
+		return retrievalResult{}, fmt.Errorf("failed to determine retrievers: %w", err)
+	}
+
+	strategy, err := corpus.ChooseFusion(corpora, params.fusionStrategy, params.fusionWeights)
 	if err != nil {
-		return nil, fmt.Errorf("failed to determine retrievers: %w", err)
+		return retrievalResult{}, fmt.Errorf("failed to determine fusion strategy: %w", err)
 	}
 
-	documents, err := retrieveAllDocuments(ctx, query, retrievers)
+	var retrievers []corpus.SourceRetriever
+	for _, c := range corpora {
+		retrievers = append(retrievers, c.Retrievers()...)
+	}
+
+	method, cutoff := s.rerankSettings(params)
+	limit := documentCountToReturn
+	if method != rerank.MethodOff {
+		limit = rerankCandidateCount
+	}
+
+	// Without a plan the query is retrieved as is
+	queries, rankingQuery := []string{params.query}, params.query
+	plan := s.planQuery(ctx, params)
+	if plan != nil {
+		queries, rankingQuery = plan.SubQueries, plan.Rewritten
+	}
+
+	// Retrievers are shared by every request, so the filter travels with the context
+	ctx = filter.WithContext(ctx, params.filter)
+	result, err = retrieveSubQueries(ctx, queries, retrievers, strategy, s.cfg.Retrieval.RetrieverTimeout.Duration, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve documents: %w", err)
+		return retrievalResult{}, fmt.Errorf("failed to retrieve documents: %w", err)
 	}
-	return documents, nil
+	result.plan = plan
+
+	if method != rerank.MethodOff {
+		result.documents, result.relevance = s.rerankDocuments(ctx, method, cutoff, rankingQuery, result.documents)
+	}
+
+	result.context = s.assembleContext(ctx, rankingQuery, result.documents, s.cfg.Models.ContextBudget(params.generation.Model.Name))
+
+	s.metrics.ObserveDocumentsReturned(len(result.documents))
+	if overlap, ok := fusion.SERPExaOverlap(result.lists); ok {
+		s.metrics.ObserveSERPExaOverlap(overlap)
+	}
+	return result, nil
 }
 
-func (s *Server) determineRetrievers(corpora []string) ([]retrieval.Retriever, error) {
-	personalCollectionName := "text_collection"
-	var retrieversByCorpus = map[string][]retrieval.Retriever{
-		"personal": {
-			qdrant.NewRetriever(s.qdrantPointsClient, s.modelProvider.OpenAIClient, personalCollectionName),
-		},
-		"web": {
-			exa.NewRetriever(s.exaAPIClient),
-			serp.NewRetriever(s.serpAPIClient),
-		},
+// assembleContext picks the passages the answer is generated from, see assembly.Assemble
+func (s *Server) assembleContext(ctx context.Context, query string, documents []document.Document, budget int) assembly.Context {
+	_, span := tracing.Start(ctx, "assembleContext", attribute.Int("context.budget", budget))
+	defer span.End()
+
+	assembled := assembly.Assemble(query, documents, budget)
+	span.SetAttributes(attribute.Int("context.tokens", assembled.Tokens), attribute.Int("context.passages", len(assembled.Passages)), attribute.Int("context.dropped", assembled.Dropped))
+	return assembled
+}
+
+// rerankSettings is the reranking method and cutoff for a search, the request's or the server's defaults
+func (s *Server) rerankSettings(params searchParams) (string, float64) {
+	method, cutoff := params.rerank, s.cfg.Retrieval.RerankCutoff
+	if method == "" {
+		method = s.cfg.Retrieval.Rerank
+	}
+	if params.rerankCutoff != nil {
+		cutoff = *params.rerankCutoff
 	}
+	return method, cutoff
+}
 
-	retrievers, err := corporaToRetrievers(corpora, retrieversByCorpus)
+// rerankDocuments reorders the fused candidates by relevance to query and drops those below cutoff. Reranking is an
+// improvement rather than a requirement, if it fails the top candidates are used in their fused order.
+func (s *Server) rerankDocuments(ctx context.Context, method string, cutoff float64, query string, candidates []document.Document) (documents []document.Document, relevance []float64) {
+	ctx, span := tracing.Start(ctx, "rerank", attribute.String("rerank.method", method), attribute.Float64("rerank.cutoff", cutoff), attribute.Int("rerank.candidates", len(candidates)))
+	var err error
+	defer func() {
+		span.SetAttributes(attribute.Int("rerank.kept", len(documents)))
+		tracing.End(span, err)
+	}()
+
+	documents, relevance, err = rerank.Rerank(ctx, s.reranker(method), query, candidates, cutoff, documentCountToReturn)
 	if err != nil {
-		return nil, err
+		slog.Warn("reranking failed, using fused order", "method", method, "err", err)
+		return candidates[:min(documentCountToReturn, len(candidates))], nil
 	}
-	return retrievers, nil
+	return documents, relevance
 }
 
-func corporaToRetrievers(corporaSelection []string, retrieversByCorpus map[string][]retrieval.Retriever) ([]retrieval.Retriever, error) {
-	var retrievers []retrieval.Retriever
+func (s *Server) reranker(method string) rerank.Reranker {
+	if method == rerank.MethodLLM {
+		return rerank.NewLLM(s.completer)
+	}
+	return rerank.Lexical{}
+}
 
-	for _, corpus := range corporaSelection {
-		corpusRetrievers, ok := retrieversByCorpus[corpus]
+// planQuery rewrites and decomposes the search's query, nil means it's retrieved as is. Like reranking, planning is an
+// improvement rather than a requirement, if the model can't plan the query the rules do instead.
+func (s *Server) planQuery(ctx context.Context, params searchParams) *queryplan.Plan {
+	method := params.queryPlanning
+	if method == "" {
+		method = s.cfg.Retrieval.QueryPlanning
+	}
+	if method == queryplan.MethodOff || method == "" {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "planQuery", attribute.String("queryplan.method", method))
+	defer span.End()
+
+	var planner queryplan.Planner = queryplan.NewRules()
+	if method == queryplan.MethodLLM {
+		planner = queryplan.NewLLM(s.completer)
+	}
+	plan, err := planner.Plan(ctx, params.query)
+	if err != nil {
+		slog.Warn("query planning failed, using rules", "method", method, "err", err)
+		plan, _ = queryplan.NewRules().Plan(ctx, params.query)
+	}
+	span.SetAttributes(attribute.Int("queryplan.sub_queries", len(plan.SubQueries)))
+	return &plan
+}
+
+func (s *Server) lookupCorpora(names []string) ([]corpus.Corpus, error) {
+	corpora := make([]corpus.Corpus, 0, len(names))
+	for _, name := range names {
+		c, ok := s.corpora.Get(name)
 		if !ok {
-			return nil, fmt.Errorf("corpus, %v, is invalid", corpus)
+			return nil, fmt.Errorf("corpus, %v, is invalid", name)
 		}
-		retrievers = append(retrievers, corpusRetrievers...)
+		corpora = append(corpora, c)
 	}
-
-	return retrievers, nil
+	return corpora, nil
 }
 
-func (s *Server) writeEventsToStream(ctx context.Context, stream sse.Stream, processedEventChan <-chan sse.Event) error {
+// writeSessionToStream writes the session's events after sequence number after to the stream, following the session
+// until it finishes or the client goes away
+func (s *Server) writeSessionToStream(ctx context.Context, stream sse.Stream, sessionID string, after uint64) (err error) {
+	defer s.metrics.StreamOpened()()
+
+	ctx, span := tracing.Start(ctx, "writeSessionToStream", attribute.String("sse.session", sessionID), attribute.Int64("sse.resumed_after", int64(after)))
+	written := 0
 	defer func() {
-		if err := stream.Write(sse.Event{EventType: "done", Data: "DONE"}); err != nil {
-			slog.Error("failed to write final done event", "err", err)
-		}
+		span.SetAttributes(attribute.Int("sse.events_written", written))
+		tracing.End(span, err)
 	}()
 
 	for {
-		select {
-		case chunk, ok := <-processedEventChan:
-			if !ok {
+		events, finished, err := s.eventLog.Read(ctx, sessionID, after)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				// Client went away, generation carries on for if it resumes
 				return nil
 			}
-			if err := stream.Write(chunk); err != nil {
+			return fmt.Errorf("failed to read search session: %w", err)
+		}
+
+		for _, event := range events {
+			if event.EventType == "error" {
+				message, _ := event.Data.(string)
+				err = stream.Error(message)
+			} else {
+				err = stream.Write(event)
+			}
+			if err != nil {
 				return fmt.Errorf("failed to write event to stream: %w", err)
 			}
-		case <-ctx.Done():
-			// Return reason context is done, or nil
-			return ctx.Err()
+			s.metrics.EventWritten(event.EventType)
+			written++
+			after++
+		}
+
+		if finished && len(events) == 0 {
+			return nil
 		}
 	}
 }
 
-func retrieveAllDocuments(ctx context.Context, q string, retrievers []retrieval.Retriever) ([]document.Document, error) {
+// Return 6 documents because based of some YOLO intuition that it should contain sufficient amount / highly relevant content
+// but not swamp the model with text, also 6 docs looks nicest in the UI
+const documentCountToReturn = 6
+
+// rerankCandidateCount is how many fused documents the reranker chooses documentCountToReturn from
+const rerankCandidateCount = 18
+
+type DegradationReason string
+
+const (
+	DegradationReasonTimeout DegradationReason = "timeout"
+	DegradationReasonError   DegradationReason = "error"
+	DegradationReasonEmpty   DegradationReason = "empty"
+)
+
+// DegradedSource describes a retriever whose results are missing from the answer. It is sent to the client, so
+// Message should never contain raw upstream errors.
+type DegradedSource struct {
+	Source  string            `json:"source"`
+	Reason  DegradationReason `json:"reason"`
+	Message string            `json:"message"`
+}
+
+type retrievalResult struct {
+	documents []document.Document
+	degraded  []DegradedSource
+	// lists are the results of each retriever before fusion
+	lists []fusion.RankedList
+	// relevance is each document's reranking score, nil when documents weren't reranked
+	relevance []float64
+	// context is the passages of documents the answer is generated from
+	context assembly.Context
+	// plan is what the query was turned into before retrieval, nil when it wasn't planned
+	plan *queryplan.Plan
+}
+
+// referencedDocuments are the documents as sent to clients, with their relevance scores
+func (r retrievalResult) referencedDocuments() []ReferencedDocument {
+	referenced := make([]ReferencedDocument, len(r.documents))
+	for i, d := range r.documents {
+		referenced[i] = ReferencedDocument{Document: d}
+		if i < len(r.relevance) {
+			referenced[i].Relevance = &r.relevance[i]
+		}
+	}
+	return referenced
+}
+
+// retrieveAllDocuments queries every retriever concurrently and fuses whatever comes back, keeping the top limit. Each retriever gets its own
+// timeout so one slow provider can't hold up the whole answer. Retrievers that fail,
+// time out or return nothing are recorded as degraded rather than failing the request, unless every retriever failed.
+func retrieveAllDocuments(ctx context.Context, q string, retrievers []corpus.SourceRetriever, strategy fusion.Strategy, timeout time.Duration, limit int) (retrievalResult, error) {
 	var (
-		wg           errgroup.Group
-		mu           sync.Mutex
-		docsBySource = make(map[string][]document.Document)
+		wg       sync.WaitGroup
+		lists    = make([]fusion.RankedList, len(retrievers))
+		degraded = make([]*DegradedSource, len(retrievers))
 	)
 
-	for _, r := range retrievers {
-		r := r // capture loop variable
-		wg.Go(func() error {
-			docs, err := r.Query(ctx, q, 20)
+	for i, r := range retrievers {
+		i, r := i, r // capture loop variables
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			docs, err := r.Retriever.Query(rctx, q, r.TopK)
 			if err != nil {
-				return err
+				degraded[i] = degradedFromError(ctx, r.Source, err, timeout)
+				return
 			}
-
 			if len(docs) == 0 {
-				return nil
+				degraded[i] = &DegradedSource{Source: r.Source, Reason: DegradationReasonEmpty, Message: "no results"}
 			}
 
-			mu.Lock()
-			docsBySource[docs[0].WebReference.APISource] = docs
-			mu.Unlock()
-
-			return nil
-		})
+			lists[i] = fusion.RankedList{Source: r.Source, Documents: docs}
+		}()
 	}
 
-	if err := wg.Wait(); err != nil {
-		return nil, fmt.Errorf("error while retrieving documents: %v", err)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return retrievalResult{}, err
 	}
 
-	exaDocs, ok := docsBySource["exa"]
-	if !ok {
-		return nil, fmt.Errorf("no Exa documents found")
+	result := retrievalResult{lists: lists}
+	failed := 0
+	for _, d := range degraded {
+		if d == nil {
+			continue
+		}
+		if d.Reason != DegradationReasonEmpty {
+			failed++
+		}
+		result.degraded = append(result.degraded, *d)
 	}
 
-	exaDocsByURL := make(map[string]document.Document, len(exaDocs))
-	for _, d := range exaDocs {
-		exaDocsByURL[d.WebReference.Link] = d
+	if len(retrievers) > 0 && failed == len(retrievers) {
+		return retrievalResult{}, fmt.Errorf("all retrievers failed")
 	}
 
-	serpDocs, ok := docsBySource["serp"]
-	if !ok {
-		return nil, fmt.Errorf("no SERP documents found")
+	result.documents = strategy.Fuse(lists, limit)
+	return result, nil
+}
+
+// retrieveSubQueries is retrieveAllDocuments for each query, concurrently, with the results of each fused by reciprocal
+// rank so every sub-query is represented. Retrievers degraded for any sub-query are reported once, and retrieval only
+// fails if it failed for every sub-query.
+func retrieveSubQueries(ctx context.Context, queries []string, retrievers []corpus.SourceRetriever, strategy fusion.Strategy, timeout time.Duration, limit int) (retrievalResult, error) {
+	if len(queries) == 1 {
+		return retrieveAllDocuments(ctx, queries[0], retrievers, strategy, timeout, limit)
 	}
 
-	seen := make(map[string]struct{})
+	var (
+		wg      sync.WaitGroup
+		results = make([]retrievalResult, len(queries))
+		errs    = make([]error, len(queries))
+	)
+	for i, q := range queries {
+		i, q := i, q // capture loop variables
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = retrieveAllDocuments(ctx, q, retrievers, strategy, timeout, limit)
+		}()
+	}
+	wg.Wait()
 
-	// Return 6 documents because based of some YOLO intuition that it should contain sufficient amount / highly relevant content
-	// but not swamp the model with text, also 6 docs looks nicest in the UI
-	const documentCountToReturn = 6
-	ret := make([]document.Document, 0, documentCountToReturn)
+	if err := ctx.Err(); err != nil {
+		return retrievalResult{}, err
+	}
 
-	// Take any docs ranked highly via SERP that we have full text coverage for first
-	for _, fromSerp := range serpDocs {
-		if len(ret) >= documentCountToReturn {
-			break
-		}
-		fromExa, exists := exaDocsByURL[fromSerp.WebReference.Link]
-		if !exists {
+	var (
+		merged       retrievalResult
+		perSubQuery  []fusion.RankedList
+		degradedSeen = make(map[string]bool)
+		failed       error
+	)
+	for i, r := range results {
+		if errs[i] != nil {
+			failed = errs[i]
 			continue
 		}
-
-		seen[fromSerp.WebReference.Link] = struct{}{}
-		ret = append(ret, fromExa)
+		perSubQuery = append(perSubQuery, fusion.RankedList{Source: queries[i], Documents: r.documents})
+		merged.lists = append(merged.lists, r.lists...)
+		for _, d := range r.degraded {
+			if !degradedSeen[d.Source] {
+				degradedSeen[d.Source] = true
+				merged.degraded = append(merged.degraded, d)
+			}
+		}
 	}
+	if len(perSubQuery) == 0 {
+		return retrievalResult{}, failed
+	}
+
+	merged.documents = fusion.ReciprocalRank{K: fusion.DefaultRRFK}.Fuse(perSubQuery, limit)
+	return merged, nil
+}
 
-	slog.Info("SERP / Exa response stats", "Number SERP results Exa has coverage for", len(ret), "Num SERP retrieved", len(serpDocs), "Num Exa retrieved", len(exaDocs))
+func degradedFromError(ctx context.Context, source string, err error, timeout time.Duration) *DegradedSource {
+	slog.Warn("retriever degraded", "source", source, "err", err)
 
-	// To back-fill result to 6 documents, use highest ranked Exa results
-	for i := 0; len(ret) < documentCountToReturn && i < len(exaDocs); i++ {
-		fromExa := exaDocs[i]
-		if _, exists := seen[fromExa.WebReference.Link]; exists {
-			continue
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return &DegradedSource{
+			Source:  source,
+			Reason:  DegradationReasonTimeout,
+			Message: fmt.Sprintf("timed out after %v", timeout),
 		}
-		ret = append(ret, fromExa)
 	}
 
-	return ret, nil
+	return &DegradedSource{Source: source, Reason: DegradationReasonError, Message: "retriever request failed"}
 }
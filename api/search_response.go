@@ -0,0 +1,88 @@
+package api
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"log/slog"
+	"raglib-demo/answer"
+	"raglib-demo/api/sse"
+	"raglib-demo/assembly"
+	"raglib-demo/grounding"
+	"raglib-demo/models"
+	"raglib-demo/queryplan"
+)
+
+// SearchResponse is the non-streaming equivalent of the /search event stream. Offsets are byte offsets into Answer.
+type SearchResponse struct {
+	// ID identifies the search in the history, see GET /history/{searchID}
+	ID string `json:"id"`
+	// Answer is the Markdown answer, including code blocks, with citation markers removed
+	Answer     string             `json:"answer"`
+	Citations  []answer.Citation  `json:"citations"`
+	CodeBlocks []answer.CodeBlock `json:"codeBlocks"`
+	// Structured is the answer as paragraphs, inline citations and code blocks
+	Structured answer.Answer `json:"structured"`
+	// Markdown is the answer with footnote style citations, suitable for export
+	Markdown        string               `json:"markdown"`
+	Documents       []ReferencedDocument `json:"documents"`
+	DegradedSources []DegradedSource     `json:"degradedSources,omitempty"`
+	// Context is the passages of Documents the answer was generated from
+	Context assembly.Context `json:"context"`
+	// QueryPlan is what the query was rewritten and split into before retrieval, it's only set when it was planned
+	QueryPlan *queryplan.Plan `json:"queryPlan,omitempty"`
+	// CitationWarnings lists citations that were dropped or look unsupported by the document they cite
+	CitationWarnings []grounding.Warning `json:"citationWarnings,omitempty"`
+	Groundedness     *grounding.Report   `json:"groundedness,omitempty"`
+	// Metadata is the model the answer was generated with and the tokens it used
+	Metadata *models.Metadata `json:"metadata,omitempty"`
+}
+
+// ReferencedDocument is a document the answer can cite, as sent in documentsreference events and JSON responses
+type ReferencedDocument struct {
+	document.Document
+	// Relevance is the reranker's score, from 0 to 1, it's only set when documents were reranked
+	Relevance *float64 `json:"relevance,omitempty"`
+}
+
+// verificationResult collects the citationwarning, groundedness and metadata events for the JSON response
+type verificationResult struct {
+	warnings []grounding.Warning
+	report   *grounding.Report
+	metadata *models.Metadata
+}
+
+func (v *verificationResult) add(event sse.Event) {
+	switch data := event.Data.(type) {
+	case grounding.Warning:
+		v.warnings = append(v.warnings, data)
+	case grounding.Report:
+		v.report = &data
+	case models.Metadata:
+		v.metadata = &data
+	default:
+		slog.Warn("unexpected event type when building search response", "type", event.EventType)
+	}
+}
+
+func newSearchResponse(id string, a answer.Answer, retrieved retrievalResult, verification verificationResult) SearchResponse {
+	documents := retrieved.documents
+	if documents == nil {
+		documents = []document.Document{}
+	}
+
+	annotated := a.Annotate()
+	return SearchResponse{
+		ID:               id,
+		Answer:           annotated.Text,
+		Citations:        annotated.Citations,
+		CodeBlocks:       annotated.CodeBlocks,
+		Structured:       a,
+		Markdown:         a.Markdown(documents),
+		Documents:        retrieved.referencedDocuments(),
+		DegradedSources:  retrieved.degraded,
+		Context:          retrieved.context,
+		QueryPlan:        retrieved.plan,
+		CitationWarnings: verification.warnings,
+		Groundedness:     verification.report,
+		Metadata:         verification.metadata,
+	}
+}
@@ -1,8 +1,14 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
 	"raglib-demo/api/sse"
+	"raglib-demo/corpus"
+	"raglib-demo/fusion"
 	"testing"
+	"time"
 )
 
 func TestProcessAndBufferChunks(t *testing.T) {
@@ -294,7 +300,7 @@ func TestProcessAndBufferChunks(t *testing.T) {
 			}()
 
 			p := ChunkProcessor{}
-			go p.ProcessChunks(responseChan, bufferedChunkChan)
+			go p.ProcessChunks(context.Background(), responseChan, bufferedChunkChan)
 
 			var outputEvents []sse.Event
 			for event := range bufferedChunkChan {
@@ -316,3 +322,89 @@ func TestProcessAndBufferChunks(t *testing.T) {
 		})
 	}
 }
+
+type stubRetriever struct {
+	docs  []document.Document
+	err   error
+	block bool
+}
+
+func (r stubRetriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	if r.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return r.docs, r.err
+}
+
+func TestRetrieveAllDocumentsDegradesGracefully(t *testing.T) {
+	exaDocs := []document.Document{
+		{WebReference: &document.WebReference{Link: "https://a.com", APISource: "exa"}},
+		{WebReference: &document.WebReference{Link: "https://b.com", APISource: "exa"}},
+	}
+
+	testCases := []struct {
+		name             string
+		retrievers       []corpus.SourceRetriever
+		expectErr        bool
+		expectedDocCount int
+		expectedDegraded []DegradedSource
+	}{
+		{
+			name: "SERP errors",
+			retrievers: []corpus.SourceRetriever{
+				{Source: fusion.SourceExa, TopK: 20, Retriever: stubRetriever{docs: exaDocs}},
+				{Source: fusion.SourceSERP, TopK: 20, Retriever: stubRetriever{err: errors.New("quota exceeded")}},
+			},
+			expectedDocCount: 2,
+			expectedDegraded: []DegradedSource{{Source: fusion.SourceSERP, Reason: DegradationReasonError}},
+		},
+		{
+			name: "Exa times out and SERP is empty",
+			retrievers: []corpus.SourceRetriever{
+				{Source: fusion.SourceExa, TopK: 20, Retriever: stubRetriever{block: true}},
+				{Source: fusion.SourceSERP, TopK: 20, Retriever: stubRetriever{}},
+			},
+			expectedDocCount: 0,
+			expectedDegraded: []DegradedSource{
+				{Source: fusion.SourceExa, Reason: DegradationReasonTimeout},
+				{Source: fusion.SourceSERP, Reason: DegradationReasonEmpty},
+			},
+		},
+		{
+			name: "Every retriever fails",
+			retrievers: []corpus.SourceRetriever{
+				{Source: fusion.SourceExa, TopK: 20, Retriever: stubRetriever{err: errors.New("boom")}},
+				{Source: fusion.SourceSERP, TopK: 20, Retriever: stubRetriever{block: true}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := retrieveAllDocuments(context.Background(), "query", tc.retrievers, fusion.SERPCoveredByExa{}, 10*time.Millisecond, documentCountToReturn)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(result.documents) != tc.expectedDocCount {
+				t.Errorf("Unexpected number of documents. Got: %d, Expected: %d", len(result.documents), tc.expectedDocCount)
+			}
+			if len(result.degraded) != len(tc.expectedDegraded) {
+				t.Fatalf("Unexpected number of degraded sources. Got: %+v, Expected: %+v", result.degraded, tc.expectedDegraded)
+			}
+			for i, d := range result.degraded {
+				if d.Source != tc.expectedDegraded[i].Source || d.Reason != tc.expectedDegraded[i].Reason {
+					t.Errorf("Degraded source [%d]; Got: %+v, Expected: %+v", i, d, tc.expectedDegraded[i])
+				}
+			}
+		})
+	}
+}
@@ -4,51 +4,173 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/coopslarhette/raglib/lib/generation"
 	"github.com/coopslarhette/raglib/lib/modelproviders"
-	"github.com/coopslarhette/raglib/lib/retrieval/exa"
-	"github.com/coopslarhette/raglib/lib/retrieval/serp"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 	qdrant "github.com/qdrant/go-client/qdrant"
-	"google.golang.org/grpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"raglib-demo/api/sse"
+	"raglib-demo/auth"
+	"raglib-demo/cache"
+	"raglib-demo/config"
+	"raglib-demo/conversation"
+	"raglib-demo/corpus"
+	"raglib-demo/fixtures"
+	"raglib-demo/history"
+	"raglib-demo/ingestion"
+	"raglib-demo/llm"
+	"raglib-demo/metrics"
+	"raglib-demo/models"
 	"syscall"
+	"time"
+)
+
+// searchSessionRetention is how long a finished search stays resumable
+const searchSessionRetention = 10 * time.Minute
+
+const (
+	// conversationIdleTimeout is how long a conversation can go without a message before it's dropped
+	conversationIdleTimeout = 24 * time.Hour
+	// maxConversations bounds the memory conversations, and the documents of their turns, can take
+	maxConversations = 1000
+)
+
+const (
+	retrievalCacheTTL        = 10 * time.Minute
+	retrievalCacheMaxEntries = 1000
+	retrievalCacheMaxBytes   = 64 << 20
+	answerCacheTTL           = time.Hour
+	answerCacheMaxEntries    = 500
+	answerCacheMaxBytes      = 16 << 20
 )
 
 type Server struct {
+	cfg                config.Config
 	router             *chi.Mux
 	qdrantPointsClient qdrant.PointsClient
-	serpAPIClient      *serp.Client
-	exaAPIClient       *exa.Client
-	modelProvider      *modelproviders.Facade
+	httpClient         *http.Client
+	generator          Generator
+	embedder           ingestion.Embedder
+	ingestionPipeline  *ingestion.Pipeline
+	corpora            *corpus.Registry
+	eventLog           sse.EventLog
+	retrievalCache     cache.Backend
+	answerCache        *cache.AnswerCache
+	conversations      conversation.Store
+	queryRewriter      conversation.Rewriter
+	// completer is used for auxiliary model calls, like query rewriting and judging citations
+	completer llm.Completer
+	metrics   *metrics.Metrics
+	// keyring is nil when authentication is disabled
+	keyring *auth.Keyring
+	// denseOnlyCollections can't be searched by keyword, see WithDenseOnlyCollections
+	denseOnlyCollections map[string]bool
+	// retrieverFactoryOverrides replace the built in retriever factories, see WithRetrieverFactory
+	retrieverFactoryOverrides map[string]corpus.RetrieverFactory
+	// fixtures is nil unless external calls are being recorded or replayed, as set by fixtureMode
+	fixtures    *fixtures.Store
+	fixtureMode string
+	// history is nil when searches aren't recorded
+	history history.Store
 }
 
-func NewServer(conn *grpc.ClientConn) *Server {
+func NewServer(cfg config.Config, corpusConfig corpus.Config, opts ...Option) (*Server, error) {
 	s := &Server{
-		router:             chi.NewRouter(),
-		qdrantPointsClient: qdrant.NewPointsClient(conn),
-		serpAPIClient:      serp.NewClient(os.Getenv("SERPAPI_API_KEY"), http.DefaultClient),
-		exaAPIClient:       exa.NewClient(os.Getenv("EXA_API_KEY"), http.DefaultClient),
-		modelProvider:      modelproviders.NewFacade(os.Getenv("OPENAI_API_KEY"), os.Getenv("ANTHROPIC_API_KEY"), os.Getenv("GROQ_API_KEY")),
+		cfg:            cfg,
+		router:         chi.NewRouter(),
+		httpClient:     &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		eventLog:       sse.NewMemoryEventLog(searchSessionRetention),
+		retrievalCache: cache.NewMemoryLRU(retrievalCacheMaxEntries, retrievalCacheMaxBytes),
+		answerCache:    cache.NewAnswerCache(cache.NewMemoryLRU(answerCacheMaxEntries, answerCacheMaxBytes), answerCacheTTL),
+		conversations:  conversation.NewMemoryStore(conversationIdleTimeout, maxConversations),
+		metrics:        metrics.New(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
+	modelProvider := modelproviders.NewFacade(cfg.APIKeys.OpenAI, cfg.APIKeys.Anthropic, cfg.APIKeys.Groq)
+	if s.generator == nil {
+		s.generator = models.NewGenerator(generation.NewAnswerer(modelProvider), models.NewClients(modelProvider, cfg.APIKeys.ModelProviders(), s.httpClient))
+	}
+	if s.embedder == nil {
+		s.embedder = ingestion.NewOpenAIEmbedder(modelProvider.OpenAIClient)
+	}
+	if s.completer == nil {
+		s.completer = llm.NewOpenAICompleter(modelProvider.OpenAIClient, cfg.Models.Auxiliary)
+	}
+	if s.history == nil {
+		store, err := history.Open(cfg.History.Store, cfg.History.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid history config: %w", err)
+		}
+		s.history = store
+	}
+	if err := s.useFixtures(); err != nil {
+		return nil, fmt.Errorf("invalid fixture config: %w", err)
+	}
+	s.queryRewriter = conversation.NewLLMRewriter(s.completer)
+	if s.qdrantPointsClient != nil {
+		s.ingestionPipeline = ingestion.NewPipeline(s.qdrantPointsClient, s.embedder, PersonalCollectionName, !s.denseOnlyCollections[PersonalCollectionName])
+	}
+
+	corpora, err := corpus.NewRegistry(corpusConfig, s.cachedRetrieverFactories())
+	if err != nil {
+		return nil, fmt.Errorf("invalid corpus config: %w", err)
+	}
+	s.corpora = corpora
+
 	s.useMiddleWare()
 	s.establishRoutes()
 
-	return s
+	return s, nil
+}
+
+// useFixtures wraps the generator and completer to record or replay their calls, retrievers are wrapped as they're
+// built in cachedRetrieverFactories
+func (s *Server) useFixtures() error {
+	mode, err := fixtures.ParseMode(s.cfg.Fixtures.Mode)
+	if err != nil {
+		return err
+	}
+	s.fixtureMode = mode
+	if mode == fixtures.ModeOff {
+		return nil
+	}
+
+	store, err := fixtures.NewStore(s.cfg.Fixtures.Dir)
+	if err != nil {
+		return err
+	}
+	s.fixtures = store
+	s.generator = fixtures.NewGenerator(s.generator, store, mode)
+	s.completer = fixtures.NewCompleter(s.completer, store, mode, s.cfg.Models.Auxiliary)
+	return nil
+}
+
+// Handler is the server's router, with all middleware applied
+func (s *Server) Handler() http.Handler {
+	return s.router
 }
 
 func (s *Server) Start(ctx context.Context) {
-	port := 5000
 	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: s.router,
+		Addr:              s.cfg.Server.Address,
+		Handler:           s.router,
+		ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout.Duration,
+		ReadTimeout:       s.cfg.Server.ReadTimeout.Duration,
+		WriteTimeout:      s.cfg.Server.WriteTimeout.Duration,
+		IdleTimeout:       s.cfg.Server.IdleTimeout.Duration,
 	}
 
 	opts := &slog.HandlerOptions{
@@ -60,18 +182,33 @@ func (s *Server) Start(ctx context.Context) {
 	slog.SetDefault(logger)
 
 	shutdownComplete := handleShutdown(func() {
-		if err := server.Shutdown(ctx); err != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, s.cfg.Server.ShutdownTimeout.Duration)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Printf("server.Shutdown failed: %v\n", err)
 		}
 	})
 
-	slog.Info("Starting server...", "Address", server.Addr)
-	if err := server.ListenAndServe(); errors.Is(err, http.ErrServerClosed) {
+	tls := s.cfg.Server.TLS
+	slog.Info("Starting server...", "Address", server.Addr, "TLS", tls.Enabled())
+
+	var err error
+	if tls.Enabled() {
+		err = server.ListenAndServeTLS(tls.CertFile, tls.KeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
 		<-shutdownComplete
 	} else {
 		slog.Error("http.ListenAndServe failed", "err", err)
 	}
 
+	if s.history != nil {
+		if err := s.history.Close(); err != nil {
+			slog.Error("failed to close search history", "err", err)
+		}
+	}
 	slog.Info("Shutdown gracefully")
 }
 
@@ -92,24 +229,56 @@ func handleShutdown(onShutdownSignal func()) <-chan struct{} {
 }
 
 func (s *Server) useMiddleWare() {
+	s.router.Use(apiKeyFromQuery)
 	s.router.Use(middleware.RequestID)
 	s.router.Use(middleware.RealIP)
+	s.router.Use(otelhttp.NewMiddleware("http.server"))
+	s.router.Use(traceRequest)
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
 
 	s.router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000", "https://raglib.vercel.app"},
+		AllowedOrigins:   s.cfg.Server.CORS.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", auth.APIKeyHeader},
 		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
+		AllowCredentials: s.cfg.Server.CORS.AllowCredentials,
+		MaxAge:           s.cfg.Server.CORS.MaxAge,
 	}))
 }
 
+// traceRequest attaches the request ID to the request's span, and names the span after the matched route once it's
+// known
+func traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.String("http.request_id", middleware.GetReqID(r.Context())))
+
+		next.ServeHTTP(w, r)
+
+		if pattern := chi.RouteContext(r.Context()).RoutePattern(); pattern != "" {
+			span.SetName(r.Method + " " + pattern)
+		}
+	})
+}
+
 func (s *Server) establishRoutes() {
 	s.router.Get("/health", healthHandler)
-	s.router.Get("/search", s.searchHandler)
+	s.router.Method(http.MethodGet, "/metrics", s.metrics.Handler())
+
+	s.router.Group(func(r chi.Router) {
+		r.Use(s.authenticate)
+
+		r.Get("/search", s.searchHandler)
+		r.Post("/documents", s.ingestDocumentsHandler)
+		r.Get("/corpora", s.corporaHandler)
+		r.Post("/conversations", s.createConversationHandler)
+		r.Get("/conversations/{conversationID}", s.getConversationHandler)
+		r.Post("/conversations/{conversationID}/messages", s.conversationMessageHandler)
+		r.Get("/history", s.listHistoryHandler)
+		r.Get("/history/{searchID}", s.getHistoryHandler)
+		r.Post("/feedback", s.feedbackHandler)
+	})
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
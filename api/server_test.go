@@ -0,0 +1,979 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"raglib-demo/api/sse"
+	"raglib-demo/assembly"
+	"raglib-demo/auth"
+	"raglib-demo/config"
+	"raglib-demo/conversation"
+	"raglib-demo/corpus"
+	"raglib-demo/fakes"
+	"raglib-demo/filter"
+	"raglib-demo/fixtures"
+	"raglib-demo/history"
+	"raglib-demo/models"
+	"raglib-demo/tracing"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const scriptedRetrieverType = "scripted"
+
+// newTestServer serves a single "test" corpus backed by retrievers, keyed by source name, with answers from generator
+func newTestServer(t *testing.T, retrievers map[string]*fakes.Retriever, generator *fakes.Generator, opts ...Option) *httptest.Server {
+	t.Helper()
+	return newTestServerWithConfig(t, testConfig(t), retrievers, generator, opts...)
+}
+
+func newTestServerWithConfig(t *testing.T, cfg config.Config, retrievers map[string]*fakes.Retriever, generator *fakes.Generator, opts ...Option) *httptest.Server {
+	t.Helper()
+
+	definition := corpus.Definition{Name: "test", Fusion: corpus.FusionPolicy{Strategy: "rrf"}}
+	for source := range retrievers {
+		definition.Retrievers = append(definition.Retrievers, corpus.RetrieverConfig{Type: scriptedRetrieverType, Source: source})
+	}
+
+	factory := func(rc corpus.RetrieverConfig) (retrieval.Retriever, error) {
+		return retrievers[rc.Source], nil
+	}
+
+	opts = append([]Option{
+		WithRetrieverFactory(scriptedRetrieverType, factory),
+		WithGenerator(generator),
+		WithCompleter(fakes.Completer{Reply: "1"}),
+	}, opts...)
+	s, err := NewServer(cfg, corpus.Config{Corpora: []corpus.Definition{definition}}, opts...)
+	if err != nil {
+		t.Fatalf("Expected no error creating server, got %v", err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// testConfig is the default config with searches recorded to a temporary directory
+func testConfig(t *testing.T) config.Config {
+	cfg := config.Default()
+	cfg.History.Path = filepath.Join(t.TempDir(), "history.jsonl")
+	return cfg
+}
+
+// readStream reads the whole event stream, replacing the session ID in event IDs so the output is deterministic
+func readStream(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Got: %v, Expected: %v", contentType, "text/event-stream")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := string(body)
+	if i := strings.Index(stream, "id: "); i >= 0 {
+		sessionID := stream[i+len("id: "):]
+		sessionID = sessionID[:strings.Index(sessionID, ":")]
+		stream = strings.ReplaceAll(stream, sessionID, "session")
+	}
+	return stream
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// defaultContext is the context a test server assembles from documents
+func defaultContext(query string, documents []document.Document) assembly.Context {
+	return assembly.Assemble(query, documents, config.Default().Models.ContextBudget(""))
+}
+
+func TestSearchStream(t *testing.T) {
+	documents := []document.Document{
+		{Passages: []document.Passage{{Text: "Go compiles to fast native code."}}, WebReference: &document.WebReference{Link: "https://go.dev"}},
+	}
+	retriever := &fakes.Retriever{Documents: documents}
+	generator := &fakes.Generator{
+		Chunks: []string{"Go is fast <cit", "ed>0</cited>.", " Really."},
+		Usage:  models.Usage{PromptTokens: 120, CompletionTokens: 9, TotalTokens: 129},
+	}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": retriever}, generator)
+
+	got := readStream(t, ts.URL+"/search?q=is+go+fast&corpus=test&grounding=off")
+
+	expected := fmt.Sprintf(`event: documentsreference
+data: %s
+id: session:1
+
+event: contextpassages
+data: %s
+id: session:2
+
+event: text
+data: "Go is fast "
+id: session:3
+
+event: citation
+data: 0
+id: session:4
+
+event: text
+data: "."
+id: session:5
+
+event: text
+data: " Really."
+id: session:6
+
+event: groundedness
+data: {"method":"off","score":1,"citations":1,"dropped":0,"unsupported":0}
+id: session:7
+
+event: metadata
+data: {"model":{"provider":"raglib","name":"answerer"},"temperature":0,"usage":{"promptTokens":120,"completionTokens":9,"totalTokens":129},"cached":false}
+id: session:8
+
+event: done
+data: "DONE"
+id: session:9
+
+`, mustMarshal(t, documents), mustMarshal(t, defaultContext("is go fast", documents)))
+
+	if got != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", got, expected)
+	}
+
+	if queries := retriever.Queries(); len(queries) != 1 || queries[0] != "is go fast" {
+		t.Errorf("Got: %v, Expected: %v", queries, []string{"is go fast"})
+	}
+	if queries := generator.Queries(); len(queries) != 1 || queries[0] != "is go fast" {
+		t.Errorf("Got: %v, Expected: %v", queries, []string{"is go fast"})
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	exposition, _ := io.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		`raglib_sse_events_written_total{type="text"} 3`,
+		`raglib_sse_events_written_total{type="done"} 1`,
+		"raglib_sse_active_streams 0",
+		`raglib_retriever_request_duration_seconds_count{outcome="ok",source="web"} 1`,
+		"raglib_generation_time_to_first_token_seconds_count 1",
+	} {
+		if !strings.Contains(string(exposition), expected) {
+			t.Errorf("Expected /metrics to contain %q", expected)
+		}
+	}
+}
+
+func TestSearchStreamDegradedSourceAndBadCitation(t *testing.T) {
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Rust has no garbage collector."}}}}
+	retrievers := map[string]*fakes.Retriever{
+		"good":   {Documents: documents},
+		"broken": {Err: errors.New("upstream returned 500")},
+	}
+	generator := &fakes.Generator{Chunks: []string{"Rust <cited>3</cited>"}}
+	ts := newTestServer(t, retrievers, generator)
+
+	got := readStream(t, ts.URL+"/search?q=rust+gc&corpus=test&grounding=off")
+
+	expected := fmt.Sprintf(`event: documentsreference
+data: %s
+id: session:1
+
+event: degradedsources
+data: [{"source":"broken","reason":"error","message":"retriever request failed"}]
+id: session:2
+
+event: contextpassages
+data: %s
+id: session:3
+
+event: text
+data: "Rust "
+id: session:4
+
+event: citationwarning
+data: {"citation":3,"reason":"outofrange"}
+id: session:5
+
+event: groundedness
+data: {"method":"off","score":1,"citations":1,"dropped":1,"unsupported":0}
+id: session:6
+
+event: metadata
+data: {"model":{"provider":"raglib","name":"answerer"},"temperature":0,"usage":{"promptTokens":0,"completionTokens":0,"totalTokens":0},"cached":false}
+id: session:7
+
+event: done
+data: "DONE"
+id: session:8
+
+`, mustMarshal(t, documents), mustMarshal(t, defaultContext("rust gc", documents)))
+
+	if got != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", got, expected)
+	}
+}
+
+func TestSearchStreamGenerationError(t *testing.T) {
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Zig has comptime."}}}}
+	generator := &fakes.Generator{Err: errors.New("model overloaded")}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, generator)
+
+	got := readStream(t, ts.URL+"/search?q=zig&corpus=test")
+
+	// No groundedness report for an answer that didn't finish, and the client only sees a generic error
+	expected := fmt.Sprintf(`event: documentsreference
+data: %s
+id: session:1
+
+event: contextpassages
+data: %s
+id: session:2
+
+event: done
+data: "DONE"
+id: session:3
+
+event: error
+data: Internal server error occurred.
+
+`, mustMarshal(t, documents), mustMarshal(t, defaultContext("zig", documents)))
+
+	if got != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", got, expected)
+	}
+}
+
+func TestSearchJSON(t *testing.T) {
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Goroutines are cheap to start."}}}}
+	generator := &fakes.Generator{Chunks: []string{"Goroutines are cheap <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, generator)
+
+	resp, err := http.Get(ts.URL + "/search?q=goroutines&corpus=test&stream=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got SearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Answer != "Goroutines are cheap ." {
+		t.Errorf("Got: %q, Expected: %q", got.Answer, "Goroutines are cheap .")
+	}
+	if len(got.Citations) != 1 || got.Citations[0].DocumentIndex != 0 {
+		t.Errorf("Got: %+v, Expected: a single citation of document 0", got.Citations)
+	}
+	if got.Groundedness == nil || got.Groundedness.Method != "lexical" || got.Groundedness.Score != 1 {
+		t.Errorf("Got: %+v, Expected: a fully supported lexical groundedness report", got.Groundedness)
+	}
+	if len(got.Documents) != 1 {
+		t.Errorf("Got: %v, Expected: %v", len(got.Documents), 1)
+	}
+}
+
+func TestSearchJSONGenerationTimeout(t *testing.T) {
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Goroutines are cheap to start."}}}}
+	generator := &fakes.Generator{Chunks: []string{"Goroutines are cheap."}, Delay: time.Minute}
+	cfg := testConfig(t)
+	cfg.Retrieval.GenerationTimeout = config.Duration{Duration: 50 * time.Millisecond}
+	ts := newTestServerWithConfig(t, cfg, map[string]*fakes.Retriever{"web": {Documents: documents}}, generator)
+
+	started := time.Now()
+	resp, err := http.Get(ts.URL + "/search?q=goroutines&corpus=test&stream=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Got: %v, Expected: %v", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("Got: %v, Expected: the stalled generation to be cut off by the timeout", elapsed)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	retriever := &fakes.Retriever{Documents: []document.Document{{Passages: []document.Passage{{Text: "Deploys go out on Tuesdays."}}}}}
+	generator := &fakes.Generator{Chunks: []string{"On Tuesdays <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"notes": retriever}, generator)
+
+	resp, err := http.Get(ts.URL + "/search?q=deploys&corpus=test&stream=false&tag=Platform&tag=deploys&source_prefix=notes/platform/&author=Ana&after=2024-06-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got: %v, Expected: %v", resp.StatusCode, http.StatusOK)
+	}
+
+	after := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	expected := filter.Filter{Tags: []string{"deploys", "platform"}, SourcePrefix: "notes/platform", Author: "Ana", After: &after}
+	filters := retriever.Filters()
+	if len(filters) != 1 || filters[0].Key() != expected.Key() {
+		t.Errorf("Got: %+v, Expected: %+v", filters, expected)
+	}
+
+	for _, query := range []string{"after=last+week", "after=2024-06-01&before=2024-01-01"} {
+		resp, err := http.Get(ts.URL + "/search?q=deploys&corpus=test&stream=false&" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: Got: %v, Expected: %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSearchRerank(t *testing.T) {
+	retriever := &fakes.Retriever{Documents: []document.Document{
+		{Passages: []document.Passage{{Text: "Rust has no garbage collector."}}, WebReference: &document.WebReference{Link: "https://rust-lang.org"}},
+		{Passages: []document.Passage{{Text: "Go's garbage collector has short pauses."}}, WebReference: &document.WebReference{Link: "https://go.dev"}},
+	}}
+	generator := &fakes.Generator{Chunks: []string{"Short pauses <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": retriever}, generator)
+
+	resp, err := http.Get(ts.URL + "/search?q=go+garbage+collector+pauses&corpus=test&stream=false&rerank=lexical&rerank_cutoff=0.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got SearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Documents) != 1 || got.Documents[0].WebReference.Link != "https://go.dev" {
+		t.Fatalf("Got: %+v, Expected: only the Go document to pass the cutoff", got.Documents)
+	}
+	if got.Documents[0].Relevance == nil || *got.Documents[0].Relevance != 1 {
+		t.Errorf("Got: %v, Expected: a relevance of 1", got.Documents[0].Relevance)
+	}
+
+	for _, cutoff := range []string{"2", "NaN"} {
+		resp, err = http.Get(ts.URL + "/search?q=go&corpus=test&stream=false&rerank=lexical&rerank_cutoff=" + cutoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Got: %v, Expected: %v for a cutoff of %v", resp.StatusCode, http.StatusBadRequest, cutoff)
+		}
+	}
+}
+
+func TestSearchQueryPlan(t *testing.T) {
+	retriever := &fakes.Retriever{Documents: []document.Document{{Passages: []document.Passage{{Text: "Raft elects a leader, Kubernetes stores its state in etcd."}}}}}
+	generator := &fakes.Generator{Chunks: []string{"Raft <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": retriever}, generator)
+
+	resp, err := http.Get(ts.URL + "/search?q=what+is+raft+and+how+does+k8s+use+etcd&corpus=test&stream=false&plan=rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got SearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"how does k8s (Kubernetes) use etcd", "what is raft"}
+	if got.QueryPlan == nil || got.QueryPlan.Rewritten != "what is raft and how does k8s (Kubernetes) use etcd" {
+		t.Fatalf("Got: %+v, Expected: the query rewritten with k8s expanded", got.QueryPlan)
+	}
+	queries := retriever.Queries()
+	slices.Sort(queries)
+	if !slices.Equal(queries, expected) {
+		t.Errorf("Got: %v, Expected: %v", queries, expected)
+	}
+	if len(got.Documents) != 1 {
+		t.Errorf("Got: %v, Expected: %v", len(got.Documents), 1)
+	}
+
+	// Plans are sent before the documents they found
+	stream := readStream(t, ts.URL+"/search?q=what+is+raft+and+how+does+k8s+use+etcd&corpus=test&grounding=off&plan=rules")
+	if !strings.HasPrefix(stream, "event: queryplan\ndata: "+mustMarshal(t, *got.QueryPlan)+"\nid: session:1\n") {
+		t.Errorf("Got:\n%v\nExpected the stream to start with the query plan", stream)
+	}
+
+	resp, err = http.Get(ts.URL + "/search?q=raft&corpus=test&stream=false&plan=magic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Got: %v, Expected: %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestSearchModelSelection(t *testing.T) {
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Deploys run on Tuesdays."}}}}
+	generator := &fakes.Generator{
+		Chunks: []string{"Tuesdays <cited>0</cited>."},
+		Usage:  models.Usage{PromptTokens: 80, CompletionTokens: 5, TotalTokens: 85},
+	}
+	cfg := testConfig(t)
+	cfg.Models.Allowed = []models.Model{{Provider: models.ProviderAnthropic, Name: "claude-sonnet-4-5"}}
+	ts := newTestServerWithConfig(t, cfg, map[string]*fakes.Retriever{"web": {Documents: documents}}, generator)
+
+	resp, err := http.Get(ts.URL + "/search?q=deploys&corpus=test&stream=false&model=claude-sonnet-4-5&temperature=0.7&max_tokens=500&style=step-by-step")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got SearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := models.Settings{
+		Model:       models.Model{Provider: models.ProviderAnthropic, Name: "claude-sonnet-4-5"},
+		Temperature: 0.7,
+		MaxTokens:   500,
+		Style:       models.StyleStepByStep,
+	}
+	if settings := generator.Settings(); len(settings) != 1 || settings[0] != expected {
+		t.Errorf("Unexpected generation settings. Got: %+v, Expected: %+v", settings, expected)
+	}
+	if got.Metadata == nil || got.Metadata.Settings != expected || got.Metadata.Usage.TotalTokens != 85 || got.Metadata.Cached {
+		t.Errorf("Unexpected metadata. Got: %+v, Expected: the settings and usage of an uncached answer", got.Metadata)
+	}
+
+	resp, err = http.Get(ts.URL + "/search?q=when+are+deploys&corpus=test&stream=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if settings := generator.Settings(); len(settings) != 2 || settings[1] != (models.Settings{Model: models.RaglibAnswerer}) {
+		t.Errorf("Unexpected generation settings. Got: %+v, Expected searches that don't pick a model to use %v", settings, models.RaglibAnswerer)
+	}
+
+	for _, query := range []string{
+		"model=openai/gpt-4o",
+		"model=claude-sonnet-4-5&temperature=1.5",
+		"model=claude-sonnet-4-5&max_tokens=100000",
+		"model=claude-sonnet-4-5&style=haiku",
+		// The default raglib answerer takes none of them
+		"temperature=0.5",
+		"style=concise",
+	} {
+		resp, err := http.Get(ts.URL + "/search?q=deploys&corpus=test&stream=false&" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v, Got: %v, Expected: %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSearchFixtures(t *testing.T) {
+	documents := []document.Document{
+		{Passages: []document.Passage{{Text: "Deploys go out on Tuesdays."}}, WebReference: &document.WebReference{Link: "https://wiki.example.com/deploys"}},
+	}
+	definition := corpus.Definition{
+		Name:       "test",
+		Fusion:     corpus.FusionPolicy{Strategy: "rrf"},
+		Retrievers: []corpus.RetrieverConfig{{Type: scriptedRetrieverType, Source: "wiki"}},
+	}
+	corpusConfig := corpus.Config{Corpora: []corpus.Definition{definition}}
+	dir := t.TempDir()
+
+	search := func(mode string, opts ...Option) string {
+		t.Helper()
+		cfg := testConfig(t)
+		cfg.Fixtures = config.FixturesConfig{Mode: mode, Dir: dir}
+		s, err := NewServer(cfg, corpusConfig, opts...)
+		if err != nil {
+			t.Fatalf("Expected no error creating server, got %v", err)
+		}
+		ts := httptest.NewServer(s.Handler())
+		defer ts.Close()
+		return readStream(t, ts.URL+"/search?q=deploys&corpus=test")
+	}
+
+	retriever := &fakes.Retriever{Documents: documents}
+	generator := &fakes.Generator{Chunks: []string{"On Tues", "days <cited>0</cited>."}, Usage: models.Usage{TotalTokens: 42}}
+	recorded := search(fixtures.ModeRecord,
+		WithRetrieverFactory(scriptedRetrieverType, func(corpus.RetrieverConfig) (retrieval.Retriever, error) { return retriever, nil }),
+		WithGenerator(generator),
+		WithCompleter(fakes.Completer{Reply: "1"}),
+	)
+
+	// Nothing but the fixtures can answer the replayed search
+	unbuilt := WithRetrieverFactory(scriptedRetrieverType, func(corpus.RetrieverConfig) (retrieval.Retriever, error) {
+		t.Error("Expected replaying not to build retrievers")
+		return retriever, nil
+	})
+	replayed := search(fixtures.ModeReplay, unbuilt, WithCompleter(fakes.Completer{Err: errors.New("completer called while replaying")}))
+
+	if replayed != recorded {
+		t.Errorf("Got:\n%v\nExpected:\n%v", replayed, recorded)
+	}
+	if !strings.Contains(recorded, `"On Tues"`) || !strings.Contains(recorded, `"totalTokens":42`) {
+		t.Errorf("Expected the recorded answer in the stream, got:\n%v", recorded)
+	}
+	if len(retriever.Queries()) != 1 || len(generator.Queries()) != 1 {
+		t.Errorf("Got: %v retrievals and %v generations, Expected: 1 of each", len(retriever.Queries()), len(generator.Queries()))
+	}
+
+	generator.Chunks = []string{"Something else."}
+	if changed := search(fixtures.ModeReplay, unbuilt, WithGenerator(generator)); changed != recorded {
+		t.Errorf("Expected replays to ignore the live generator, got:\n%v", changed)
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Deploys go out on Tuesdays."}}}}
+	generator := &fakes.Generator{Chunks: []string{"On Tuesdays <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"notes": {Documents: documents}}, generator)
+
+	getJSON := func(path string, v any) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var searched SearchResponse
+	getJSON("/search?q=deploys&corpus=test&stream=false", &searched)
+	readStream(t, ts.URL+"/search?q=when+are+deploys&corpus=test")
+
+	var listed HistoryResponse
+	if code := getJSON("/history", &listed); code != http.StatusOK {
+		t.Fatalf("Got: %v, Expected: %v", code, http.StatusOK)
+	}
+	if len(listed.Searches) != 2 || listed.Searches[0].Query != "when are deploys" || listed.Searches[1].ID != searched.ID {
+		t.Fatalf("Got: %+v, Expected: both searches, most recent first", listed.Searches)
+	}
+
+	var record history.Record
+	if code := getJSON("/history/"+searched.ID, &record); code != http.StatusOK {
+		t.Fatalf("Got: %v, Expected: %v", code, http.StatusOK)
+	}
+	if record.Answer != "On Tuesdays ." || len(record.Citations) != 1 || len(record.Documents) != 1 || record.Corpora[0] != "test" {
+		t.Errorf("Got: %+v, Expected: the search's answer, citation, document and corpus", record)
+	}
+	if record.Model != config.Default().Models.Answer.String() || record.Error != "" {
+		t.Errorf("Got: %v, %q, Expected: the default model and no error", record.Model, record.Error)
+	}
+
+	for path, expected := range map[string]int{
+		"/history/unknown":  http.StatusNotFound,
+		"/history?limit=0":  http.StatusBadRequest,
+		"/history?offset=1": http.StatusOK,
+	} {
+		var ignored HistoryResponse
+		if code := getJSON(path, &ignored); code != expected {
+			t.Errorf("%v, Got: %v, Expected: %v", path, code, expected)
+		}
+	}
+}
+
+func TestSearchHistoryAuth(t *testing.T) {
+	keyring, err := auth.NewKeyring(auth.KeyFile{Keys: []auth.KeyConfig{
+		{Name: "alice", Key: "alice-key", Corpora: []string{auth.AllCorpora}},
+		{Name: "bob", Key: "bob-key", Corpora: []string{auth.AllCorpora}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Keys are secret."}}}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, &fakes.Generator{Chunks: []string{"Yes."}}, WithKeyring(keyring))
+
+	get := func(key string, path string, v any) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var searched SearchResponse
+	get("alice-key", "/search?q=keys&corpus=test&stream=false", &searched)
+
+	var alices, bobs HistoryResponse
+	get("alice-key", "/history", &alices)
+	get("bob-key", "/history", &bobs)
+	if len(alices.Searches) != 1 || alices.Searches[0].Principal != "alice" || len(bobs.Searches) != 0 {
+		t.Errorf("Got: %+v and %+v, Expected: each key to only see its own searches", alices.Searches, bobs.Searches)
+	}
+
+	var record history.Record
+	if code := get("bob-key", "/history/"+searched.ID, &record); code != http.StatusNotFound {
+		t.Errorf("Got: %v, Expected: %v for another key's search", code, http.StatusNotFound)
+	}
+}
+
+func TestSearchFeedback(t *testing.T) {
+	documents := []document.Document{
+		{Passages: []document.Passage{{Text: "Deploys go out on Tuesdays."}}, WebReference: &document.WebReference{Link: "https://wiki.example.com/deploys"}},
+	}
+	generator := &fakes.Generator{Chunks: []string{"On Tuesdays <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"wiki": {Documents: documents}}, generator)
+
+	resp, err := http.Get(ts.URL + "/search?q=deploys&corpus=test&stream=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var searched SearchResponse
+	err = json.NewDecoder(resp.Body).Decode(&searched)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(body string) int {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/feedback", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"no search", `{"rating": "down"}`, http.StatusBadRequest},
+		{"unknown rating", fmt.Sprintf(`{"searchId": %q, "rating": "meh"}`, searched.ID), http.StatusBadRequest},
+		{"unknown search", `{"searchId": "unknown", "rating": "down"}`, http.StatusNotFound},
+		{"citation out of range", fmt.Sprintf(`{"searchId": %q, "rating": "down", "irrelevantCitations": [1]}`, searched.ID), http.StatusBadRequest},
+		{"thumbs down", fmt.Sprintf(`{"searchId": %q, "rating": "down", "comment": "Deploys moved", "irrelevantCitations": [0, 0]}`, searched.ID), http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if got := send(tt.body); got != tt.expected {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.name, got, tt.expected)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/history/" + searched.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var record history.Record
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Feedback == nil || record.Feedback.Rating != history.RatingDown || record.Feedback.Comment != "Deploys moved" || len(record.Feedback.IrrelevantCitations) != 1 {
+		t.Errorf("Got: %+v, Expected: the feedback stored with the search", record.Feedback)
+	}
+	if irrelevant := record.IrrelevantDocuments(); len(irrelevant) != 1 || irrelevant[0].WebReference.Link != "https://wiki.example.com/deploys" {
+		t.Errorf("Got: %+v, Expected: the cited document flagged irrelevant", irrelevant)
+	}
+}
+
+func TestSearchTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	if _, err := tracing.Setup(context.Background(), config.TracingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Traces have spans."}}}}
+	generator := &fakes.Generator{Chunks: []string{"Spans <cited>0</cited>."}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, generator)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/search?q=spans&corpus=test", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("Got: %v, Expected: %v, for span %v", got, traceID, span.Name())
+		}
+	}
+
+	for _, name := range []string{"GET /search", "doRetrieval", "retriever.Query", "generator.Generate", "ChunkProcessor.ProcessChunks", "writeSessionToStream"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Expected a %v span", name)
+		}
+	}
+
+	hasRequestID := false
+	for _, kv := range spans["GET /search"].Attributes() {
+		hasRequestID = hasRequestID || (kv.Key == "http.request_id" && kv.Value.AsString() != "")
+	}
+	if !hasRequestID {
+		t.Errorf("Expected the request span to have the request ID")
+	}
+}
+
+func TestSearchAuth(t *testing.T) {
+	keyring, err := auth.NewKeyring(auth.KeyFile{Keys: []auth.KeyConfig{
+		{Name: "internal", Key: "internal-key", Corpora: []string{auth.AllCorpora}, DailyQuota: 1},
+		{Name: "web-client", Key: "web-key", Corpora: []string{"web"}, RateLimit: auth.RateLimit{PerMinute: 1, Burst: 1}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Keys are secret."}}}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, &fakes.Generator{Chunks: []string{"Yes."}}, WithKeyring(keyring))
+
+	search := func(header string, value string, query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/search?q=keys&corpus=test&stream=false"+query, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		query    string
+		expected int
+	}{
+		{"no key", "", "", "", http.StatusUnauthorized},
+		{"unknown key", "Authorization", "Bearer nope", "", http.StatusUnauthorized},
+		{"corpus not allowed", auth.APIKeyHeader, "web-key", "", http.StatusForbidden},
+		{"rate limited", auth.APIKeyHeader, "web-key", "", http.StatusTooManyRequests},
+		{"bearer token", "Authorization", "Bearer internal-key", "", http.StatusOK},
+		{"over daily quota", "", "", "&api_key=internal-key", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		if got := search(tt.header, tt.value, tt.query).StatusCode; got != tt.expected {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.name, got, tt.expected)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Got: %v, Expected: /health to stay open", resp.StatusCode)
+	}
+}
+
+// countingCompleter replies with reply, counting how many times it was called
+type countingCompleter struct {
+	reply string
+	calls atomic.Int32
+}
+
+func (c *countingCompleter) Complete(ctx context.Context, system string, prompt string) (string, error) {
+	c.calls.Add(1)
+	return c.reply, nil
+}
+
+// requestWithKey sends body, as JSON when it's set, authenticated with key
+func requestWithKey(t *testing.T, method, url, key string, body any) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		reader = strings.NewReader(mustMarshal(t, body))
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(auth.APIKeyHeader, key)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestConversationQuotaAndWeights(t *testing.T) {
+	keyring, err := auth.NewKeyring(auth.KeyFile{Keys: []auth.KeyConfig{
+		{Name: "internal", Key: "internal-key", Corpora: []string{auth.AllCorpora}, DailyQuota: 2},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completer := &countingCompleter{reply: "standalone question"}
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Quotas reset daily."}}}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, &fakes.Generator{Chunks: []string{"Daily."}}, WithKeyring(keyring), WithCompleter(completer))
+
+	resp := requestWithKey(t, http.MethodPost, ts.URL+"/conversations", "internal-key", CreateConversationRequest{Corpora: []string{"test"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Got: %v, Expected: %v", resp.StatusCode, http.StatusCreated)
+	}
+	var c conversation.Conversation
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	messages := ts.URL + "/conversations/" + c.ID + "/messages"
+
+	resp = requestWithKey(t, http.MethodPost, messages, "internal-key", ConversationMessageRequest{Message: "when do quotas reset?", Fusion: "roundrobin", Weights: map[string]float64{"web": 1e-300}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Got: %v, Expected: %v", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// The first message needs no rewriting, the second is rewritten and the third is over the quota
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, status := range expected {
+		resp := requestWithKey(t, http.MethodPost, messages, "internal-key", ConversationMessageRequest{Message: "and weekly ones?"})
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("Message %d; Got: %v, Expected: %v", i, resp.StatusCode, status)
+		}
+	}
+	if calls := completer.calls.Load(); calls != 1 {
+		t.Errorf("Got: %v rewrites, Expected: %v", calls, 1)
+	}
+}
+
+func TestSessionAndConversationOwnership(t *testing.T) {
+	keyring, err := auth.NewKeyring(auth.KeyFile{Keys: []auth.KeyConfig{
+		{Name: "internal", Key: "internal-key", Corpora: []string{auth.AllCorpora}},
+		{Name: "other", Key: "other-key", Corpora: []string{auth.AllCorpora}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Sessions belong to their key."}}}}
+	ts := newTestServer(t, map[string]*fakes.Retriever{"web": {Documents: documents}}, &fakes.Generator{Chunks: []string{"Yes."}}, WithKeyring(keyring))
+
+	resp, err := http.Get(ts.URL + "/search?q=sessions&corpus=test&grounding=off&api_key=internal-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	i := strings.Index(string(body), "id: ")
+	if i < 0 {
+		t.Fatalf("Got:\n%s\nExpected a session", body)
+	}
+	sessionID := string(body[i+len("id: "):])
+	sessionID = sessionID[:strings.Index(sessionID, ":")]
+
+	resume := func(key string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/search?q=sessions&corpus=test", nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		req.Header.Set("Last-Event-ID", sse.EventID(sessionID, 1))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := resume("other-key"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Got: %v, Expected another key's session to be unknown", resp.StatusCode)
+	}
+	if resp := resume("internal-key"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Got: %v, Expected: %v", resp.StatusCode, http.StatusOK)
+	}
+
+	resp = requestWithKey(t, http.MethodPost, ts.URL+"/conversations", "internal-key", CreateConversationRequest{Corpora: []string{"test"}})
+	var c conversation.Conversation
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		body     any
+		expected int
+	}{
+		{"other key reading", http.MethodGet, "", "other-key", nil, http.StatusNotFound},
+		{"other key continuing", http.MethodPost, "/messages", "other-key", ConversationMessageRequest{Message: "whose is this?"}, http.StatusNotFound},
+		{"own key reading", http.MethodGet, "", "internal-key", nil, http.StatusOK},
+		{"own key continuing", http.MethodPost, "/messages", "internal-key", ConversationMessageRequest{Message: "whose is this?"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := requestWithKey(t, tt.method, ts.URL+"/conversations/"+c.ID+tt.path, tt.key, tt.body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.expected {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.name, resp.StatusCode, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownSession = errors.New("unknown session")
+
+// EventLog stores the events of each search session so a client that reconnects with Last-Event-ID can be caught up
+// and then continue following the session live
+type EventLog interface {
+	Create(sessionID string, owner Owner) error
+	// Owner is who the session was created for, ok is false if the session doesn't exist
+	Owner(sessionID string) (owner Owner, ok bool)
+	// Append stores e as the next event in the session, returning it with its ID set
+	Append(sessionID string, e Event) (Event, error)
+	// Read returns the events after sequence number after, waiting until there is at least one or the session is
+	// finished. Sequence numbers start at 1.
+	Read(ctx context.Context, sessionID string, after uint64) (events []Event, finished bool, err error)
+	Finish(sessionID string) error
+}
+
+// Owner is who a session was created for and what it searched, so it's only resumed by a client that could have
+// started it
+type Owner struct {
+	// Principal is the name of the API key the session was created with, it's empty without authentication
+	Principal string
+	Corpora   []string
+}
+
+// EventID formats a session scoped event ID, ie "3f6c...:12", so Last-Event-ID alone identifies where to resume
+func EventID(sessionID string, sequence uint64) string {
+	return fmt.Sprintf("%s:%d", sessionID, sequence)
+}
+
+func ParseEventID(id string) (sessionID string, sequence uint64, err error) {
+	i := strings.LastIndex(id, ":")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("event ID, %v, is malformed", id)
+	}
+
+	sequence, err = strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("event ID, %v, has an invalid sequence number", id)
+	}
+	return id[:i], sequence, nil
+}
+
+type memorySession struct {
+	owner      Owner
+	events     []Event
+	finished   bool
+	finishedAt time.Time
+	// updated is closed, and replaced, whenever an event is appended or the session finishes
+	updated chan struct{}
+}
+
+func (ms *memorySession) notify() {
+	close(ms.updated)
+	ms.updated = make(chan struct{})
+}
+
+// MemoryEventLog keeps sessions in memory, finished sessions are dropped once they're older than retention
+type MemoryEventLog struct {
+	mu        sync.Mutex
+	sessions  map[string]*memorySession
+	retention time.Duration
+	now       func() time.Time
+}
+
+func NewMemoryEventLog(retention time.Duration) *MemoryEventLog {
+	return &MemoryEventLog{
+		sessions:  make(map[string]*memorySession),
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+func (l *MemoryEventLog) Create(sessionID string, owner Owner) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.evictExpired()
+
+	if _, exists := l.sessions[sessionID]; exists {
+		return fmt.Errorf("session, %v, already exists", sessionID)
+	}
+	l.sessions[sessionID] = &memorySession{owner: owner, updated: make(chan struct{})}
+	return nil
+}
+
+func (l *MemoryEventLog) Owner(sessionID string) (Owner, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	session, ok := l.sessions[sessionID]
+	if !ok {
+		return Owner{}, false
+	}
+	return session.owner, true
+}
+
+func (l *MemoryEventLog) Append(sessionID string, e Event) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	session, ok := l.sessions[sessionID]
+	if !ok {
+		return Event{}, ErrUnknownSession
+	}
+	if session.finished {
+		return Event{}, fmt.Errorf("session, %v, is finished", sessionID)
+	}
+
+	e.ID = EventID(sessionID, uint64(len(session.events)+1))
+	session.events = append(session.events, e)
+	session.notify()
+
+	return e, nil
+}
+
+func (l *MemoryEventLog) Read(ctx context.Context, sessionID string, after uint64) ([]Event, bool, error) {
+	for {
+		l.mu.Lock()
+		session, ok := l.sessions[sessionID]
+		if !ok {
+			l.mu.Unlock()
+			return nil, false, ErrUnknownSession
+		}
+
+		if uint64(len(session.events)) > after || session.finished {
+			var events []Event
+			if uint64(len(session.events)) > after {
+				events = make([]Event, len(session.events)-int(after))
+				copy(events, session.events[after:])
+			}
+			finished := session.finished
+			l.mu.Unlock()
+			return events, finished, nil
+		}
+
+		updated := session.updated
+		l.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+func (l *MemoryEventLog) Finish(sessionID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	session, ok := l.sessions[sessionID]
+	if !ok {
+		return ErrUnknownSession
+	}
+	if !session.finished {
+		session.finished = true
+		session.finishedAt = l.now()
+		session.notify()
+	}
+	return nil
+}
+
+// evictExpired must be called with mu held
+func (l *MemoryEventLog) evictExpired() {
+	cutoff := l.now().Add(-l.retention)
+	for id, session := range l.sessions {
+		if session.finished && session.finishedAt.Before(cutoff) {
+			delete(l.sessions, id)
+		}
+	}
+}
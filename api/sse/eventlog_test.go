@@ -0,0 +1,94 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryEventLogReplayAndFollow(t *testing.T) {
+	log := NewMemoryEventLog(time.Minute)
+	owner := Owner{Principal: "internal", Corpora: []string{"web"}}
+	if err := log.Create("session", owner); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, e := range []Event{NewTextEvent("a"), NewCitationEvent(1)} {
+		if _, err := log.Append("session", e); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	events, finished, err := log.Read(context.Background(), "session", 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if finished || len(events) != 1 || events[0].ID != "session:2" || events[0].Data != 1 {
+		t.Fatalf("Unexpected replay. Got: %+v, finished: %v", events, finished)
+	}
+
+	// A reader that has caught up waits for the next event
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		log.Append("session", NewTextEvent("b"))
+		log.Finish("session")
+	}()
+
+	events, _, err = log.Read(context.Background(), "session", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != "session:3" {
+		t.Fatalf("Unexpected live event. Got: %+v", events)
+	}
+
+	events, finished, err = log.Read(context.Background(), "session", 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !finished || len(events) != 0 {
+		t.Fatalf("Expected finished session with no more events. Got: %+v, finished: %v", events, finished)
+	}
+
+	if got, ok := log.Owner("session"); !ok || got.Principal != owner.Principal || !reflect.DeepEqual(got.Corpora, owner.Corpora) {
+		t.Errorf("Got: %+v, Expected: %+v", got, owner)
+	}
+}
+
+func TestMemoryEventLogEviction(t *testing.T) {
+	log := NewMemoryEventLog(time.Minute)
+	now := time.Now()
+	log.now = func() time.Time { return now }
+
+	log.Create("old", Owner{})
+	log.Finish("old")
+	log.Create("running", Owner{})
+
+	now = now.Add(2 * time.Minute)
+	log.Create("new", Owner{})
+
+	if _, ok := log.Owner("old"); ok {
+		t.Errorf("Expected finished session past retention to be evicted")
+	}
+	if _, ok := log.Owner("running"); !ok {
+		t.Errorf("Expected unfinished session to be kept")
+	}
+	if _, _, err := log.Read(context.Background(), "old", 0); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("Expected ErrUnknownSession, got: %v", err)
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	sessionID, sequence, err := ParseEventID(EventID("3f6c2a1e-7d4b-4c1a-9e2f-0b8d5a6c7e9f", 12))
+	if err != nil || sessionID != "3f6c2a1e-7d4b-4c1a-9e2f-0b8d5a6c7e9f" || sequence != 12 {
+		t.Errorf("Unexpected parse. Got: %v, %v, %v", sessionID, sequence, err)
+	}
+
+	for _, id := range []string{"", "no-sequence", ":12", "session:abc"} {
+		if _, _, err := ParseEventID(id); err == nil {
+			t.Errorf("Expected error parsing %q", id)
+		}
+	}
+}
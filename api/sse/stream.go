@@ -3,7 +3,6 @@ package sse
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
 	"net/http"
 )
 
@@ -40,8 +39,13 @@ func (s *Stream) Write(e Event) error {
 		return fmt.Errorf("error marshalling event data: %v", err)
 	}
 
-	// Note: technically might be misusing the id field on an SSE event here
-	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\nid: %s\n\n", e.EventType, marshalledData, e.ID); err != nil {
+	// An empty id field would reset the client's last event ID, so only write one when the event has an ID
+	if e.ID != "" {
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\nid: %s\n\n", e.EventType, marshalledData, e.ID)
+	} else {
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", e.EventType, marshalledData)
+	}
+	if err != nil {
 		return fmt.Errorf("error writing to event stream: %v", err)
 	}
 
@@ -59,23 +63,24 @@ func (s *Stream) Error(clientSafeErrorMessage string) error {
 }
 
 type Event struct {
-	ID        uuid.UUID
+	// ID is assigned by the EventLog when the event is appended to a session, see EventID
+	ID        string
 	EventType string
 	Data      interface{}
 }
 
 func NewTextEvent(text string) Event {
-	return Event{EventType: "text", Data: text, ID: uuid.New()}
+	return Event{EventType: "text", Data: text}
 }
 
 func NewCitationEvent(citationNumber int) Event {
-	return Event{EventType: "citation", Data: citationNumber, ID: uuid.New()}
+	return Event{EventType: "citation", Data: citationNumber}
 }
 
 func NewCodeBlockEvent(code string) Event {
-	return Event{EventType: "codeblock", Data: code, ID: uuid.New()}
+	return Event{EventType: "codeblock", Data: code}
 }
 
 func NewErrorEvent(errorMessage string) Event {
-	return Event{EventType: "error", Data: errorMessage, ID: uuid.New()}
+	return Event{EventType: "error", Data: errorMessage}
 }
@@ -0,0 +1,167 @@
+package assembly
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"math"
+	"raglib-demo/ingestion"
+	"sort"
+)
+
+// EventType is the event reporting which passages the answer was generated from
+const EventType = "contextpassages"
+
+// passageSplitter cuts documents into passages small enough that the budget isn't spent on the irrelevant half of a
+// long one. There's no overlap, overlapping passages would spend the budget on the same text twice.
+var passageSplitter = ingestion.Splitter{MaxWords: 120}
+
+// charsPerToken is a rough average for English text, it's close enough for budgeting without a model's tokenizer
+const charsPerToken = 4
+
+// EstimateTokens estimates how many tokens text takes up in a prompt
+func EstimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// Passage is a passage that was sent to the model
+type Passage struct {
+	// DocumentIndex is the passage's document in the documentsreference event, the index citations use
+	DocumentIndex int `json:"documentIndex"`
+	// PassageIndex is the passage's position within its document as sent to the model
+	PassageIndex int     `json:"passageIndex"`
+	Text         string  `json:"text"`
+	Tokens       int     `json:"tokens"`
+	Score        float64 `json:"score"`
+}
+
+// Context is what the answer is generated from
+type Context struct {
+	// Documents line up with the documents Assemble was given, so citations keep their indices. Each only has its
+	// selected passages, a document without any still has its web reference.
+	Documents []document.Document `json:"-"`
+	Passages  []Passage           `json:"passages"`
+	// Tokens is the estimated size of Documents, it can exceed Budget if the web references alone do
+	Tokens int `json:"tokens"`
+	Budget int `json:"budget"`
+	// Dropped is how many passages didn't fit in the budget
+	Dropped int `json:"dropped"`
+}
+
+type candidate struct {
+	documentIndex int
+	// order is the passage's position within its document before selection
+	order  int
+	text   string
+	tokens int
+	score  float64
+}
+
+// Assemble splits documents into passages, scores each against query with BM25 and fills budget tokens with the best
+// of them. Ties go to the better ranked document, so with a query that matches nothing the budget is filled in
+// retrieval order.
+func Assemble(query string, documents []document.Document, budget int) Context {
+	result := Context{Documents: make([]document.Document, len(documents)), Budget: budget}
+
+	var candidates []candidate
+	for i, d := range documents {
+		// Documents are always sent, so their references come out of the budget first
+		result.Documents[i] = document.Document{Corpus: d.Corpus, WebReference: d.WebReference}
+		if d.WebReference != nil {
+			result.Tokens += EstimateTokens(d.WebReference.Title + d.WebReference.Link + d.WebReference.Snippet)
+		}
+
+		order := 0
+		for _, p := range d.Passages {
+			for _, text := range passageSplitter.Split(p.Text) {
+				candidates = append(candidates, candidate{documentIndex: i, order: order, text: text, tokens: EstimateTokens(text)})
+				order++
+			}
+		}
+	}
+
+	scoreBM25(query, candidates)
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].score > candidates[b].score
+	})
+
+	var selected []candidate
+	for _, c := range candidates {
+		// Smaller passages further down might still fit, so keep going
+		if result.Tokens+c.tokens > budget {
+			result.Dropped++
+			continue
+		}
+		result.Tokens += c.tokens
+		selected = append(selected, c)
+	}
+
+	// Passages read better in the order they were written
+	sort.Slice(selected, func(a, b int) bool {
+		if selected[a].documentIndex != selected[b].documentIndex {
+			return selected[a].documentIndex < selected[b].documentIndex
+		}
+		return selected[a].order < selected[b].order
+	})
+	for _, c := range selected {
+		d := &result.Documents[c.documentIndex]
+		result.Passages = append(result.Passages, Passage{
+			DocumentIndex: c.documentIndex,
+			PassageIndex:  len(d.Passages),
+			Text:          c.text,
+			Tokens:        c.tokens,
+			Score:         c.score,
+		})
+		d.Passages = append(d.Passages, document.Passage{Text: c.text})
+	}
+
+	return result
+}
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// scoreBM25 scores candidates against query, with IDF and average length taken from the candidates themselves
+func scoreBM25(query string, candidates []candidate) {
+	if len(candidates) == 0 {
+		return
+	}
+
+	queryTerms := make(map[string]struct{})
+	for _, term := range ingestion.Terms(query) {
+		queryTerms[term] = struct{}{}
+	}
+
+	frequencies := make([]map[string]int, len(candidates))
+	lengths := make([]int, len(candidates))
+	documentFrequency := make(map[string]int)
+	totalLength := 0
+	for i, c := range candidates {
+		frequencies[i] = make(map[string]int)
+		terms := ingestion.Terms(c.text)
+		for _, term := range terms {
+			if _, ok := queryTerms[term]; !ok {
+				continue
+			}
+			if frequencies[i][term] == 0 {
+				documentFrequency[term]++
+			}
+			frequencies[i][term]++
+		}
+		lengths[i] = len(terms)
+		totalLength += len(terms)
+	}
+
+	n := float64(len(candidates))
+	averageLength := max(float64(totalLength)/n, 1)
+	for i := range candidates {
+		score := 0.0
+		for term, tf := range frequencies[i] {
+			df := float64(documentFrequency[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			lengthNorm := 1 - bm25B + bm25B*float64(lengths[i])/averageLength
+			score += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*lengthNorm)
+		}
+		candidates[i].score = score
+	}
+}
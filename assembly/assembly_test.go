@@ -0,0 +1,78 @@
+package assembly
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	filler := strings.Repeat("Unrelated filler about something else entirely. ", 20)
+	documents := []document.Document{
+		{
+			Passages:     []document.Passage{{Text: filler + "\n\nThe deploy pipeline retries ERR_CONN_RESET twice."}},
+			WebReference: &document.WebReference{Title: "Runbook", Link: "notes/runbook.md"},
+		},
+		{
+			Passages:     []document.Passage{{Text: filler}},
+			WebReference: &document.WebReference{Title: "Filler", Link: "notes/filler.md"},
+		},
+		{
+			Passages: []document.Passage{{Text: "Deploys go out on Tuesdays."}, {Text: "Rollbacks need a second approval."}},
+		},
+	}
+
+	headers := EstimateTokens("Runbook"+"notes/runbook.md") + EstimateTokens("Filler"+"notes/filler.md")
+	budget := headers + EstimateTokens("The deploy pipeline retries ERR_CONN_RESET twice.") + EstimateTokens("Deploys go out on Tuesdays.")
+
+	assembled := Assemble("why do deploys hit ERR_CONN_RESET", documents, budget)
+
+	if len(assembled.Documents) != len(documents) {
+		t.Fatalf("Expected every document to keep its index. Got: %d documents, Expected: %d", len(assembled.Documents), len(documents))
+	}
+	if assembled.Documents[1].WebReference == nil || len(assembled.Documents[1].Passages) != 0 {
+		t.Errorf("Expected the filler document to keep its reference but no passages. Got: %+v", assembled.Documents[1])
+	}
+
+	expected := []Passage{
+		{DocumentIndex: 0, PassageIndex: 0, Text: "The deploy pipeline retries ERR_CONN_RESET twice."},
+		{DocumentIndex: 2, PassageIndex: 0, Text: "Deploys go out on Tuesdays."},
+	}
+	if len(assembled.Passages) != len(expected) {
+		t.Fatalf("Unexpected passages. Got: %+v, Expected: %+v", assembled.Passages, expected)
+	}
+	for i, p := range assembled.Passages {
+		if p.DocumentIndex != expected[i].DocumentIndex || p.PassageIndex != expected[i].PassageIndex || p.Text != expected[i].Text {
+			t.Errorf("Unexpected passage %d. Got: %+v, Expected: %+v", i, p, expected[i])
+		}
+		if p.Score <= 0 {
+			t.Errorf("Expected passage %d to have a positive score. Got: %v", i, p.Score)
+		}
+		if got := assembled.Documents[p.DocumentIndex].Passages[p.PassageIndex].Text; got != p.Text {
+			t.Errorf("Expected the passage to be in its document. Got: %q, Expected: %q", got, p.Text)
+		}
+	}
+
+	if assembled.Tokens != budget || assembled.Budget != budget {
+		t.Errorf("Unexpected tokens. Got: %v of %v, Expected: %v of %v", assembled.Tokens, assembled.Budget, budget, budget)
+	}
+	if assembled.Dropped != 3 {
+		t.Errorf("Unexpected dropped count. Got: %v, Expected: %v", assembled.Dropped, 3)
+	}
+}
+
+func TestAssembleKeepsWrittenOrder(t *testing.T) {
+	documents := []document.Document{
+		{Passages: []document.Passage{{Text: "Goroutines are cheap."}, {Text: "Channels connect goroutines."}}},
+	}
+
+	assembled := Assemble("channels", documents, 1000)
+
+	passages := assembled.Documents[0].Passages
+	if len(passages) != 2 || passages[0].Text != "Goroutines are cheap." || passages[1].Text != "Channels connect goroutines." {
+		t.Errorf("Expected passages in the order they were written. Got: %+v", passages)
+	}
+	if assembled.Passages[1].Score <= assembled.Passages[0].Score {
+		t.Errorf("Expected the matching passage to score higher. Got: %+v", assembled.Passages)
+	}
+}
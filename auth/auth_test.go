@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewKeyringValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []KeyConfig
+		expected string
+	}{
+		{"no name", []KeyConfig{{Key: "a", Corpora: []string{"web"}}}, "no name"},
+		{"no key", []KeyConfig{{Name: "a", Corpora: []string{"web"}}}, "key or sha256 is required"},
+		{"both", []KeyConfig{{Name: "a", Key: "a", SHA256: strings.Repeat("0", 64), Corpora: []string{"web"}}}, "not both"},
+		{"bad hash", []KeyConfig{{Name: "a", SHA256: "abc", Corpora: []string{"web"}}}, "hex encoded"},
+		{"no corpora", []KeyConfig{{Name: "a", Key: "a"}}, "must list the corpora"},
+		{"duplicate name", []KeyConfig{{Name: "a", Key: "a", Corpora: []string{"web"}}, {Name: "a", Key: "b", Corpora: []string{"web"}}}, "more than once"},
+		{"duplicate key", []KeyConfig{{Name: "a", Key: "a", Corpora: []string{"web"}}, {Name: "b", SHA256: hashCredential("a"), Corpora: []string{"web"}}}, "duplicates"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewKeyring(KeyFile{Keys: tt.keys})
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%v, Got: %v, Expected: error containing %q", tt.name, err, tt.expected)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keyring, err := NewKeyring(KeyFile{Keys: []KeyConfig{
+		{Name: "web-client", Key: "web-key", Corpora: []string{"web"}, RateLimit: RateLimit{PerMinute: 60, Burst: 2}},
+		{Name: "internal", SHA256: hashCredential("internal-key"), Corpora: []string{AllCorpora}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	keyring.now = func() time.Time { return now }
+
+	if _, err := keyring.Authenticate(""); !errors.Is(err, ErrMissingCredential) {
+		t.Errorf("Got: %v, Expected: %v", err, ErrMissingCredential)
+	}
+	if _, err := keyring.Authenticate("wrong"); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("Got: %v, Expected: %v", err, ErrInvalidCredential)
+	}
+
+	internal, err := keyring.Authenticate("internal-key")
+	if err != nil || internal.Name != "internal" || !internal.CanAccess("personal") {
+		t.Errorf("Got: %v, %v, Expected: the internal key with access to everything", internal, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		p, err := keyring.Authenticate("web-key")
+		if err != nil {
+			t.Fatalf("Expected request %d to be within the burst, got %v", i, err)
+		}
+		if !p.CanAccess("web") || p.CanAccess("personal") {
+			t.Errorf("Expected the web key to only access web")
+		}
+	}
+
+	var rateLimited *RateLimitError
+	if _, err := keyring.Authenticate("web-key"); !errors.As(err, &rateLimited) || rateLimited.RetryAfter != time.Second {
+		t.Errorf("Got: %v, Expected: rate limited for a second", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := keyring.Authenticate("web-key"); err != nil {
+		t.Errorf("Expected a token to have been refilled, got %v", err)
+	}
+}
+
+func TestUseQuota(t *testing.T) {
+	keyring, err := NewKeyring(KeyFile{Keys: []KeyConfig{{Name: "a", Key: "a", Corpora: []string{"web"}, DailyQuota: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC)
+	keyring.now = func() time.Time { return now }
+
+	p, _ := keyring.Authenticate("a")
+	for i := 0; i < 2; i++ {
+		if err := keyring.UseQuota(p); err != nil {
+			t.Fatalf("Expected search %d to be within quota, got %v", i, err)
+		}
+	}
+	if err := keyring.UseQuota(p); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Got: %v, Expected: %v", err, ErrQuotaExceeded)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := keyring.UseQuota(p); err != nil {
+		t.Errorf("Expected the quota to reset the next UTC day, got %v", err)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	tests := []struct {
+		header   string
+		value    string
+		expected string
+	}{
+		{APIKeyHeader, "key", "key"},
+		{"Authorization", "Bearer token", "token"},
+		{"Authorization", "bearer  token ", "token"},
+		{"Authorization", "Basic dXNlcjpwYXNz", ""},
+		{"Accept", "text/event-stream", ""},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/search", nil)
+		r.Header.Set(tt.header, tt.value)
+		if got := Credential(r); got != tt.expected {
+			t.Errorf("Got: %q, Expected: %q", got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the header API keys can be sent in, alternatively they can be sent as a bearer token
+const APIKeyHeader = "X-API-Key"
+
+// Credential returns the API key sent with r, from either the X-API-Key header or an Authorization bearer token
+func Credential(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the request's principal, or nil when authentication is disabled
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"os"
+	"sync"
+	"time"
+)
+
+// AllCorpora in a key's corpora allows every corpus
+const AllCorpora = "*"
+
+type KeyFile struct {
+	Keys []KeyConfig `json:"keys"`
+}
+
+// KeyConfig is a single API key. The key itself is either given as is, or as the hex SHA-256 of the key so the file
+// doesn't have to hold secrets.
+type KeyConfig struct {
+	Name   string `json:"name"`
+	Key    string `json:"key,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Corpora the key may search, "*" for all of them
+	Corpora   []string  `json:"corpora"`
+	RateLimit RateLimit `json:"rateLimit"`
+	// DailyQuota is how many searches the key may run per UTC day, 0 means no quota
+	DailyQuota int `json:"dailyQuota,omitempty"`
+}
+
+// RateLimit is a token bucket, refilled at PerMinute tokens a minute up to Burst. A zero PerMinute means no limit.
+type RateLimit struct {
+	PerMinute float64 `json:"perMinute,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+}
+
+// Principal is who a request was authenticated as
+type Principal struct {
+	Name    string
+	corpora map[string]struct{}
+	quota   *quota
+}
+
+func (p *Principal) CanAccess(corpus string) bool {
+	if _, ok := p.corpora[AllCorpora]; ok {
+		return true
+	}
+	_, ok := p.corpora[corpus]
+	return ok
+}
+
+type key struct {
+	principal *Principal
+	limiter   *rate.Limiter
+}
+
+type quota struct {
+	limit int
+
+	mu   sync.Mutex
+	day  string
+	used int
+}
+
+// Keyring holds the configured API keys along with their rate limit and quota state, which is in memory and per
+// process
+type Keyring struct {
+	keys map[string]*key
+	now  func() time.Time
+}
+
+var (
+	ErrMissingCredential = errors.New("an API key is required")
+	ErrInvalidCredential = errors.New("API key is invalid")
+	ErrQuotaExceeded     = errors.New("daily quota exceeded")
+)
+
+// RateLimitError is returned when a key has used up its token bucket
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %v", e.RetryAfter)
+}
+
+func NewKeyring(keyFile KeyFile) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*key), now: time.Now}
+
+	names := make(map[string]struct{})
+	for i, kc := range keyFile.Keys {
+		if kc.Name == "" {
+			return nil, fmt.Errorf("key %d has no name", i)
+		}
+		if _, ok := names[kc.Name]; ok {
+			return nil, fmt.Errorf("key name, %v, is used more than once", kc.Name)
+		}
+		names[kc.Name] = struct{}{}
+
+		hash, err := keyHash(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key, %v, is invalid: %w", kc.Name, err)
+		}
+		if _, ok := k.keys[hash]; ok {
+			return nil, fmt.Errorf("key, %v, duplicates another key", kc.Name)
+		}
+		if len(kc.Corpora) == 0 {
+			return nil, fmt.Errorf("key, %v, must list the corpora it may search, or \"*\"", kc.Name)
+		}
+		if kc.RateLimit.PerMinute < 0 || kc.RateLimit.Burst < 0 || kc.DailyQuota < 0 {
+			return nil, fmt.Errorf("key, %v, can't have negative limits", kc.Name)
+		}
+
+		principal := &Principal{Name: kc.Name, corpora: make(map[string]struct{}), quota: &quota{limit: kc.DailyQuota}}
+		for _, c := range kc.Corpora {
+			principal.corpora[c] = struct{}{}
+		}
+
+		limiter := rate.NewLimiter(rate.Inf, 0)
+		if kc.RateLimit.PerMinute > 0 {
+			limiter = rate.NewLimiter(rate.Limit(kc.RateLimit.PerMinute/60), max(kc.RateLimit.Burst, 1))
+		}
+
+		k.keys[hash] = &key{principal: principal, limiter: limiter}
+	}
+
+	return k, nil
+}
+
+// LoadKeyring reads a key file, unlike the corpus config a missing file is an error since it would leave the server
+// open
+func LoadKeyring(path string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+
+	var keyFile KeyFile
+	if err := json.Unmarshal(raw, &keyFile); err != nil {
+		return nil, fmt.Errorf("error parsing key file, %v: %w", path, err)
+	}
+	return NewKeyring(keyFile)
+}
+
+func keyHash(kc KeyConfig) (string, error) {
+	switch {
+	case kc.Key != "" && kc.SHA256 != "":
+		return "", fmt.Errorf("set either key or sha256, not both")
+	case kc.Key != "":
+		return hashCredential(kc.Key), nil
+	case kc.SHA256 != "":
+		decoded, err := hex.DecodeString(kc.SHA256)
+		if err != nil || len(decoded) != sha256.Size {
+			return "", fmt.Errorf("sha256 must be a hex encoded SHA-256 hash")
+		}
+		return hex.EncodeToString(decoded), nil
+	default:
+		return "", fmt.Errorf("key or sha256 is required")
+	}
+}
+
+func hashCredential(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate finds the principal for credential and takes a token from its rate limit
+func (k *Keyring) Authenticate(credential string) (*Principal, error) {
+	if credential == "" {
+		return nil, ErrMissingCredential
+	}
+
+	// Keys are looked up by hash so there's no comparison of the secret itself to time
+	entry, ok := k.keys[hashCredential(credential)]
+	if !ok {
+		return nil, ErrInvalidCredential
+	}
+
+	reservation := entry.limiter.ReserveN(k.now(), 1)
+	if delay := reservation.DelayFrom(k.now()); delay > 0 {
+		reservation.CancelAt(k.now())
+		return entry.principal, &RateLimitError{RetryAfter: delay}
+	}
+
+	return entry.principal, nil
+}
+
+// UseQuota counts a search against the principal's daily quota
+func (k *Keyring) UseQuota(p *Principal) error {
+	q := p.quota
+	if q == nil || q.limit == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	day := k.now().UTC().Format(time.DateOnly)
+	if q.day != day {
+		q.day = day
+		q.used = 0
+	}
+	if q.used >= q.limit {
+		return ErrQuotaExceeded
+	}
+	q.used++
+	return nil
+}
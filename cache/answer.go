@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"raglib-demo/api/sse"
+	"raglib-demo/fusion"
+	"sort"
+	"time"
+)
+
+// AnswerCache stores the processed event sequence of a generated answer so it can be replayed as if it had just been
+// generated
+type AnswerCache struct {
+	backend Backend
+	ttl     time.Duration
+}
+
+func NewAnswerCache(backend Backend, ttl time.Duration) *AnswerCache {
+	return &AnswerCache{backend: backend, ttl: ttl}
+}
+
+// AnswerKey identifies an answer by what it was generated from, the query, the generation settings, the corpora
+// searched and the exact set and order of documents handed to the model
+func AnswerKey(query string, settings string, corpora []string, documents []document.Document) string {
+	sortedCorpora := append([]string(nil), corpora...)
+	sort.Strings(sortedCorpora)
+
+	parts := []string{NormalizeQuery(query), settings}
+	parts = append(parts, sortedCorpora...)
+	// Empty part between corpora and documents so a corpus can't be mistaken for a document key
+	parts = append(parts, "")
+	for _, d := range documents {
+		parts = append(parts, fusion.Key(d))
+	}
+	return Key("answer", parts...)
+}
+
+type cachedEvent struct {
+	EventType string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (c *AnswerCache) Get(ctx context.Context, key string) ([]sse.Event, bool, error) {
+	raw, ok, err := c.backend.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	var cached []cachedEvent
+	if err := json.Unmarshal(raw, &cached); err != nil {
+		return nil, false, fmt.Errorf("error decoding cached answer: %w", err)
+	}
+
+	events := make([]sse.Event, 0, len(cached))
+	for _, ce := range cached {
+		event, err := decodeEvent(ce)
+		if err != nil {
+			return nil, false, err
+		}
+		events = append(events, event)
+	}
+	return events, true, nil
+}
+
+func (c *AnswerCache) Put(ctx context.Context, key string, events []sse.Event) error {
+	cached := make([]cachedEvent, 0, len(events))
+	for _, e := range events {
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			return fmt.Errorf("error encoding %v event: %w", e.EventType, err)
+		}
+		cached = append(cached, cachedEvent{EventType: e.EventType, Data: data})
+	}
+
+	raw, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("error encoding cached answer: %w", err)
+	}
+	return c.backend.Set(ctx, key, raw, c.ttl)
+}
+
+// decodeEvent restores event data to the Go types the ChunkProcessor produces, so cached events are indistinguishable
+// from freshly generated ones
+func decodeEvent(ce cachedEvent) (sse.Event, error) {
+	switch ce.EventType {
+	case "citation":
+		var citation int
+		if err := json.Unmarshal(ce.Data, &citation); err != nil {
+			return sse.Event{}, fmt.Errorf("error decoding cached citation: %w", err)
+		}
+		return sse.NewCitationEvent(citation), nil
+	case "text", "codeblock":
+		var text string
+		if err := json.Unmarshal(ce.Data, &text); err != nil {
+			return sse.Event{}, fmt.Errorf("error decoding cached %v event: %w", ce.EventType, err)
+		}
+		return sse.Event{EventType: ce.EventType, Data: text}, nil
+	default:
+		return sse.Event{}, fmt.Errorf("unexpected cached event type, %v", ce.EventType)
+	}
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Backend is a byte oriented key value store with per key expiry. It deliberately mirrors Redis GET and SET EX so a
+// Redis client can be dropped in behind it, while MemoryLRU stands in locally.
+type Backend interface {
+	// Get returns ok false, and no error, for missing or expired keys
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value under key, a ttl of zero means it never expires
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// NormalizeQuery folds queries that differ only in case or whitespace onto the same cache key
+func NormalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// Key joins parts into a fixed length key under the given namespace, ie "retrieval:3b1f..."
+func Key(namespace string, parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		// Separator so ("ab", "c") and ("a", "bc") hash differently
+		h.Write([]byte{0})
+	}
+	return namespace + ":" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
+	"raglib-demo/api/sse"
+	"raglib-demo/filter"
+	"testing"
+	"time"
+)
+
+func TestMemoryLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	lru := NewMemoryLRU(2, 10)
+	lru.now = func() time.Time { return now }
+
+	lru.Set(ctx, "a", []byte("1"), 0)
+	lru.Set(ctx, "b", []byte("2"), time.Minute)
+	// Touch "a" so "b" is least recently used
+	lru.Get(ctx, "a")
+	lru.Set(ctx, "c", []byte("3"), 0)
+
+	if _, ok, _ := lru.Get(ctx, "b"); ok {
+		t.Errorf("Expected least recently used entry to be evicted on entry limit")
+	}
+	if v, ok, _ := lru.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Errorf("Expected recently used entry to be kept")
+	}
+
+	lru.Set(ctx, "d", []byte("1234567890"), 0)
+	if lru.Len() != 1 {
+		t.Errorf("Expected entries to be evicted on byte limit, %d remain", lru.Len())
+	}
+
+	lru.Set(ctx, "e", []byte("5"), time.Minute)
+	now = now.Add(2 * time.Minute)
+	if _, ok, _ := lru.Get(ctx, "e"); ok {
+		t.Errorf("Expected expired entry to be missing")
+	}
+
+	lru.Set(ctx, "huge", []byte("way more than ten bytes"), 0)
+	if _, ok, _ := lru.Get(ctx, "huge"); ok {
+		t.Errorf("Expected value larger than the cache not to be stored")
+	}
+}
+
+type countingRetriever struct {
+	calls int
+	docs  []document.Document
+	err   error
+}
+
+func (r *countingRetriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	r.calls++
+	return r.docs, r.err
+}
+
+func TestRetriever(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryLRU(10, 0)
+	inner := &countingRetriever{docs: []document.Document{{Passages: []document.Passage{{Text: "cached"}}}}}
+	r := NewRetriever(inner, backend, "exa", time.Minute)
+
+	r.Query(ctx, "Go  Generics", 20)
+	docs, err := r.Query(ctx, "go generics", 20)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inner.calls != 1 {
+		t.Errorf("Expected normalized query to hit the cache, retriever called %d times", inner.calls)
+	}
+	if len(docs) != 1 || docs[0].Passages[0].Text != "cached" {
+		t.Errorf("Unexpected cached documents: %+v", docs)
+	}
+
+	r.Query(ctx, "go generics", 5)
+	if inner.calls != 2 {
+		t.Errorf("Expected a different topK to miss the cache")
+	}
+
+	filtered := filter.WithContext(ctx, filter.Filter{Tags: []string{"platform"}})
+	r.Query(filtered, "go generics", 20)
+	if inner.calls != 3 {
+		t.Errorf("Expected a different filter to miss the cache")
+	}
+
+	other := NewRetriever(inner, backend, "serp", time.Minute)
+	other.Query(ctx, "go generics", 20)
+	if inner.calls != 4 {
+		t.Errorf("Expected a different retriever identity to miss the cache")
+	}
+
+	failing := NewRetriever(&countingRetriever{err: errors.New("boom")}, backend, "failing", time.Minute)
+	if _, err := failing.Query(ctx, "go generics", 20); err == nil {
+		t.Errorf("Expected retriever errors to be returned")
+	}
+}
+
+func TestAnswerCacheRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	answers := NewAnswerCache(NewMemoryLRU(10, 0), time.Minute)
+
+	documents := []document.Document{{WebReference: &document.WebReference{Link: "https://go.dev"}}}
+	key := AnswerKey("What are generics?", "openai/gpt-4o", []string{"web", "personal"}, documents)
+	if key != AnswerKey("what are  generics?", "openai/gpt-4o", []string{"personal", "web"}, documents) {
+		t.Errorf("Expected answer key to ignore query case, whitespace and corpus order")
+	}
+	if key == AnswerKey("what are generics?", "openai/gpt-4o", []string{"web"}, documents) {
+		t.Errorf("Expected answer key to depend on corpora")
+	}
+	if key == AnswerKey("what are generics?", "groq/llama-3.3-70b-versatile", []string{"web", "personal"}, documents) {
+		t.Errorf("Expected answer key to depend on generation settings")
+	}
+
+	events := []sse.Event{
+		sse.NewTextEvent("Generics are type parameters "),
+		sse.NewCitationEvent(0),
+		sse.NewCodeBlockEvent("```go\nfunc F[T any]() {}\n```"),
+	}
+	if err := answers.Put(ctx, key, events); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cached, ok, err := answers.Get(ctx, key)
+	if err != nil || !ok {
+		t.Fatalf("Expected cached answer, got ok: %v, err: %v", ok, err)
+	}
+	if len(cached) != len(events) {
+		t.Fatalf("Unexpected number of events. Got: %d, Expected: %d", len(cached), len(events))
+	}
+	for i, event := range cached {
+		if event.EventType != events[i].EventType || event.Data != events[i].Data {
+			t.Errorf("Event [%d]; Got: %+v, Expected: %+v", i, event, events[i])
+		}
+	}
+}
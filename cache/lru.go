@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLRU is an in-process Backend that evicts the least recently used entries once it holds more than maxEntries
+// entries or maxBytes bytes of values
+type MemoryLRU struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	size       int
+	maxEntries int
+	maxBytes   int
+	now        func() time.Time
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemoryLRU(maxEntries int, maxBytes int) *MemoryLRU {
+	return &MemoryLRU{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		now:        time.Now,
+	}
+}
+
+func (c *MemoryLRU) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false, nil
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *MemoryLRU) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	// A value bigger than the whole cache would just evict everything and then itself
+	if c.maxBytes > 0 && len(value) > c.maxBytes {
+		return nil
+	}
+
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = c.now().Add(ttl)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += len(value)
+
+	for c.overCapacity() {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *MemoryLRU) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+func (c *MemoryLRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *MemoryLRU) overCapacity() bool {
+	return (c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes)
+}
+
+// remove must be called with mu held
+func (c *MemoryLRU) remove(element *list.Element) {
+	entry := element.Value.(*lruEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.value)
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"log/slog"
+	"raglib-demo/filter"
+	"strconv"
+	"time"
+)
+
+// Retriever caches the results of another retriever by normalized query, topK and the query's filter. Cache errors are logged and
+// treated as misses so the cache can never fail a search.
+type Retriever struct {
+	retriever retrieval.Retriever
+	backend   Backend
+	// identity distinguishes retrievers sharing a backend, ie the same source configured with different collections
+	identity string
+	ttl      time.Duration
+}
+
+func NewRetriever(retriever retrieval.Retriever, backend Backend, identity string, ttl time.Duration) Retriever {
+	return Retriever{retriever: retriever, backend: backend, identity: identity, ttl: ttl}
+}
+
+func (r Retriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	key := Key("retrieval", r.identity, strconv.FormatUint(topK, 10), NormalizeQuery(query), filter.FromContext(ctx).Key())
+
+	if raw, ok, err := r.backend.Get(ctx, key); err != nil {
+		slog.Warn("retrieval cache get failed", "retriever", r.identity, "err", err)
+	} else if ok {
+		var docs []document.Document
+		if err := json.Unmarshal(raw, &docs); err == nil {
+			return docs, nil
+		}
+		slog.Warn("discarding undecodable retrieval cache entry", "retriever", r.identity, "err", err)
+	}
+
+	docs, err := r.retriever.Query(ctx, query, topK)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't cache empty results, they're more likely a transient upstream problem than a real answer
+	if len(docs) == 0 {
+		return docs, nil
+	}
+
+	raw, err := json.Marshal(docs)
+	if err != nil {
+		slog.Warn("failed to encode retrieval cache entry", "retriever", r.identity, "err", err)
+		return docs, nil
+	}
+	if err := r.backend.Set(ctx, key, raw, r.ttl); err != nil {
+		slog.Warn("retrieval cache set failed", "retriever", r.identity, "err", err)
+	}
+
+	return docs, nil
+}
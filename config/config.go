@@ -0,0 +1,437 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"raglib-demo/fixtures"
+	"raglib-demo/history"
+	"raglib-demo/llm"
+	"raglib-demo/models"
+	"raglib-demo/queryplan"
+	"raglib-demo/rerank"
+	"slices"
+	"time"
+)
+
+// Config is everything the server needs to start. It's loaded from a JSON file, then environment variables, then
+// flags, each overriding the last.
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	Qdrant    QdrantConfig    `json:"qdrant"`
+	Retrieval RetrievalConfig `json:"retrieval"`
+	Models    ModelConfig     `json:"models"`
+	Tracing   TracingConfig   `json:"tracing"`
+	Auth      AuthConfig      `json:"auth"`
+	Fixtures  FixturesConfig  `json:"fixtures"`
+	History   HistoryConfig   `json:"history"`
+	APIKeys   APIKeys         `json:"apiKeys"`
+}
+
+type ServerConfig struct {
+	// Address is the address to listen on, ie ":5080"
+	Address string     `json:"address"`
+	TLS     TLSConfig  `json:"tls"`
+	CORS    CORSConfig `json:"cors"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are passed to http.Server, zero means no timeout.
+	// WriteTimeout bounds whole responses, streams included, so it's off by default.
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	// ShutdownTimeout is how long in flight requests get to finish on shutdown
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+// TLSConfig enables HTTPS when both files are set
+type TLSConfig struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	// MaxAge is in seconds
+	MaxAge int `json:"maxAge"`
+}
+
+type QdrantConfig struct {
+	Address string `json:"address"`
+	// CorporaPath is the corpus config file, built in corpora are used if it doesn't exist
+	CorporaPath string `json:"corporaPath"`
+}
+
+type RetrievalConfig struct {
+	// RetrieverTimeout bounds each retriever individually
+	RetrieverTimeout Duration `json:"retrieverTimeout"`
+	// GenerationTimeout bounds generating an answer, including after a streaming client disconnects
+	GenerationTimeout Duration `json:"generationTimeout"`
+	// Rerank is how documents are reranked before generation when a request doesn't say, one of off, lexical or llm
+	Rerank string `json:"rerank"`
+	// RerankCutoff is the relevance score, from 0 to 1, reranked documents need to be kept
+	RerankCutoff float64 `json:"rerankCutoff"`
+	// QueryPlanning is how queries are rewritten and split into sub-queries before retrieval when a request doesn't
+	// say, one of off, rules or llm
+	QueryPlanning string `json:"queryPlanning"`
+}
+
+type ModelConfig struct {
+	// Auxiliary is the OpenAI model used for query rewriting and judging citations
+	Auxiliary string `json:"auxiliary"`
+	// Answer is the model answers are generated with when a search doesn't pick one
+	Answer models.Model `json:"answer"`
+	// Allowed are the other models searches can pick
+	Allowed []models.Model `json:"allowed,omitempty"`
+	// MaxTokens caps how long answers can be, searches can ask for less
+	MaxTokens int `json:"maxTokens"`
+	// ContextTokens is how many tokens of passages answers are generated from, ContextTokensByModel overrides it for
+	// models with smaller or larger context windows
+	ContextTokens        int            `json:"contextTokens"`
+	ContextTokensByModel map[string]int `json:"contextTokensByModel,omitempty"`
+}
+
+// AllowedModels are the models searches can pick, the answer model first
+func (m ModelConfig) AllowedModels() []models.Model {
+	allowed := []models.Model{m.Answer}
+	for _, model := range m.Allowed {
+		if !slices.Contains(allowed, model) {
+			allowed = append(allowed, model)
+		}
+	}
+	return allowed
+}
+
+// ContextBudget is the passage token budget for generating with model
+func (m ModelConfig) ContextBudget(model string) int {
+	if budget, ok := m.ContextTokensByModel[model]; ok {
+		return budget
+	}
+	return m.ContextTokens
+}
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	// Exporter is where spans go, one of none, stdout or otlp
+	Exporter string `json:"exporter"`
+	// Endpoint is the OTLP gRPC endpoint, ie "localhost:4317". When empty the standard OTEL_EXPORTER_OTLP_*
+	// environment variables are used.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Insecure disables TLS to the OTLP endpoint
+	Insecure    bool   `json:"insecure,omitempty"`
+	ServiceName string `json:"serviceName"`
+	// SampleRatio is the fraction of traces started here that are recorded, incoming sampled traces are always recorded
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+type AuthConfig struct {
+	// KeyFile lists the API keys allowed to use the API, along with their limits. When it's empty the API is open.
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// FixturesConfig records external calls to, or replays them from, Dir, see fixtures.ParseMode
+type FixturesConfig struct {
+	Mode string `json:"mode"`
+	Dir  string `json:"dir,omitempty"`
+}
+
+// HistoryConfig is where searches are recorded, Store is one of the history.Store* values
+type HistoryConfig struct {
+	Store string `json:"store"`
+	// Path is the JSONL file or SQLite database
+	Path string `json:"path,omitempty"`
+}
+
+type APIKeys struct {
+	OpenAI    string `json:"openai,omitempty"`
+	Anthropic string `json:"anthropic,omitempty"`
+	Groq      string `json:"groq,omitempty"`
+	SERP      string `json:"serp,omitempty"`
+	Exa       string `json:"exa,omitempty"`
+}
+
+// providerKeyEnv is the environment variable each model provider's API key is set with
+var providerKeyEnv = map[string]string{
+	models.ProviderOpenAI:    "OPENAI_API_KEY",
+	models.ProviderAnthropic: "ANTHROPIC_API_KEY",
+	models.ProviderGroq:      "GROQ_API_KEY",
+}
+
+// ModelProviders are the keys answers can be generated with, by provider
+func (k APIKeys) ModelProviders() map[string]string {
+	return map[string]string{
+		models.ProviderOpenAI:    k.OpenAI,
+		models.ProviderAnthropic: k.Anthropic,
+		models.ProviderGroq:      k.Groq,
+	}
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address: ":5080",
+			CORS: CORSConfig{
+				AllowedOrigins:   []string{"http://localhost:3000", "https://raglib.vercel.app"},
+				AllowCredentials: true,
+				MaxAge:           300, // Maximum value not ignored by any of major browsers
+			},
+			ReadHeaderTimeout: Duration{10 * time.Second},
+			IdleTimeout:       Duration{2 * time.Minute},
+			ShutdownTimeout:   Duration{30 * time.Second},
+		},
+		Qdrant: QdrantConfig{
+			Address:     "localhost:6334",
+			CorporaPath: "corpora.json",
+		},
+		Retrieval: RetrievalConfig{
+			RetrieverTimeout:  Duration{8 * time.Second},
+			GenerationTimeout: Duration{2 * time.Minute},
+			Rerank:            rerank.MethodOff,
+			QueryPlanning:     queryplan.MethodOff,
+		},
+		Models: ModelConfig{
+			Auxiliary:     llm.DefaultOpenAIModel,
+			Answer:        models.RaglibAnswerer,
+			MaxTokens:     2048,
+			ContextTokens: 6000,
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterNone,
+			ServiceName: "raglib-demo",
+			SampleRatio: 1,
+		},
+		Fixtures: FixturesConfig{
+			Mode: fixtures.ModeOff,
+		},
+		History: HistoryConfig{
+			Store: history.StoreJSONL,
+			Path:  "history.jsonl",
+		},
+	}
+}
+
+// Load builds the config from defaults, the file at path if it exists, environment variables looked up with
+// lookupEnv and finally applyFlags. It doesn't validate, so the result can be printed before Validate reports
+// what's wrong with it.
+func Load(path string, lookupEnv func(string) (string, bool), applyFlags func(*Config) error) (Config, error) {
+	c := Default()
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("error reading config file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return Config{}, fmt.Errorf("error parsing config file, %v: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&c, lookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if applyFlags != nil {
+		if err := applyFlags(&c); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// Validate reports every problem with the config at once, so they can be fixed in one go
+func (c Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Server.Address); err != nil {
+		errs = append(errs, fmt.Errorf("server.address, %q, is invalid: %w", c.Server.Address, err))
+	}
+
+	if c.Server.TLS.Enabled() {
+		if c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("server.tls needs both certFile and keyFile"))
+		}
+		for _, f := range []string{c.Server.TLS.CertFile, c.Server.TLS.KeyFile} {
+			if _, err := os.Stat(f); f != "" && err != nil {
+				errs = append(errs, fmt.Errorf("server.tls file, %v, can't be read: %w", f, err))
+			}
+		}
+	}
+
+	for _, origin := range c.Server.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.Server.CORS.AllowCredentials {
+				errs = append(errs, fmt.Errorf("server.cors can't allow credentials from any origin, '*'"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("server.cors origin, %q, must be a scheme and host, ie https://example.com", origin))
+		}
+	}
+	if c.Server.CORS.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("server.cors.maxAge can't be negative"))
+	}
+
+	timeouts := map[string]Duration{
+		"server.readHeaderTimeout": c.Server.ReadHeaderTimeout,
+		"server.readTimeout":       c.Server.ReadTimeout,
+		"server.writeTimeout":      c.Server.WriteTimeout,
+		"server.idleTimeout":       c.Server.IdleTimeout,
+		"server.shutdownTimeout":   c.Server.ShutdownTimeout,
+	}
+	for name, d := range timeouts {
+		if d.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%v can't be negative", name))
+		}
+	}
+	if c.Retrieval.RetrieverTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("retrieval.retrieverTimeout must be positive"))
+	}
+	if c.Retrieval.GenerationTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("retrieval.generationTimeout must be positive"))
+	}
+
+	if _, err := rerank.ParseMethod(c.Retrieval.Rerank); err != nil {
+		errs = append(errs, fmt.Errorf("retrieval.rerank: %w", err))
+	}
+	if err := rerank.ValidateCutoff(c.Retrieval.RerankCutoff); err != nil {
+		errs = append(errs, fmt.Errorf("retrieval.rerankCutoff: %w", err))
+	}
+	if _, err := queryplan.ParseMethod(c.Retrieval.QueryPlanning); err != nil {
+		errs = append(errs, fmt.Errorf("retrieval.queryPlanning: %w", err))
+	}
+
+	if c.Qdrant.Address == "" {
+		errs = append(errs, fmt.Errorf("qdrant.address is required"))
+	}
+	if c.Models.Auxiliary == "" {
+		errs = append(errs, fmt.Errorf("models.auxiliary is required"))
+	}
+	if err := c.Models.Answer.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("models.answer: %w", err))
+	}
+	for _, m := range c.Models.Allowed {
+		if err := m.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("models.allowed, %v: %w", m, err))
+		}
+	}
+	if c.Models.MaxTokens <= 0 {
+		errs = append(errs, fmt.Errorf("models.maxTokens must be positive"))
+	}
+	if c.Models.ContextTokens <= 0 {
+		errs = append(errs, fmt.Errorf("models.contextTokens must be positive"))
+	}
+	for model, budget := range c.Models.ContextTokensByModel {
+		if budget <= 0 {
+			errs = append(errs, fmt.Errorf("models.contextTokensByModel[%v] must be positive", model))
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingExporterNone, TracingExporterStdout, TracingExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter, %q, must be one of %v, %v or %v", c.Tracing.Exporter, TracingExporterNone, TracingExporterStdout, TracingExporterOTLP))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sampleRatio must be between 0 and 1"))
+	}
+
+	store, err := history.ParseStore(c.History.Store)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("history.store: %w", err))
+	}
+	if store != history.StoreOff && c.History.Path == "" {
+		errs = append(errs, fmt.Errorf("history.path is required for the %v history store", store))
+	}
+
+	mode, err := fixtures.ParseMode(c.Fixtures.Mode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("fixtures.mode: %w", err))
+	}
+	if mode != fixtures.ModeOff && c.Fixtures.Dir == "" {
+		errs = append(errs, fmt.Errorf("fixtures.dir is required to %v fixtures", mode))
+	}
+	// Replayed searches don't call out to anything, so they don't need keys
+	if mode == fixtures.ModeReplay {
+		return errors.Join(errs...)
+	}
+
+	// OpenAI embeds documents and runs auxiliary calls, answers need a key for each provider searches can pick
+	if c.APIKeys.OpenAI == "" {
+		errs = append(errs, fmt.Errorf("an OpenAI API key is required, set OPENAI_API_KEY"))
+	}
+	missing := map[string]bool{models.ProviderOpenAI: c.APIKeys.OpenAI == ""}
+	for _, m := range c.Models.AllowedModels() {
+		provider := m.Provider
+		if provider == models.ProviderRaglib {
+			// raglib's answerer generates with Anthropic
+			provider = models.ProviderAnthropic
+		}
+		env, ok := providerKeyEnv[provider]
+		if !ok || missing[provider] || c.APIKeys.ModelProviders()[provider] != "" {
+			continue
+		}
+		missing[provider] = true
+		errs = append(errs, fmt.Errorf("an API key for %v is required to answer with %v, set %v", provider, m, env))
+	}
+
+	return errors.Join(errs...)
+}
+
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the config that's safe to print
+func (c Config) Redacted() Config {
+	redact := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redacted
+	}
+
+	c.APIKeys = APIKeys{
+		OpenAI:    redact(c.APIKeys.OpenAI),
+		Anthropic: redact(c.APIKeys.Anthropic),
+		Groq:      redact(c.APIKeys.Groq),
+		SERP:      redact(c.APIKeys.SERP),
+		Exa:       redact(c.APIKeys.Exa),
+	}
+	c.Server.CORS.AllowedOrigins = append([]string(nil), c.Server.CORS.AllowedOrigins...)
+	return c
+}
+
+// Duration is a time.Duration that reads and writes JSON as a string, ie "8s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string, ie \"8s\"")
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
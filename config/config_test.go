@@ -0,0 +1,112 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"raglib-demo/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	raw := `{"server": {"address": ":7000", "cors": {"allowedOrigins": ["https://file.example.com"]}}, "retrieval": {"retrieverTimeout": "3s"}, "models": {"auxiliary": "file-model"}}`
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"RAGLIB_LISTEN_ADDRESS":  ":8000",
+		"RAGLIB_AUXILIARY_MODEL": "env-model",
+		"OPENAI_API_KEY":         "sk-env",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	applyFlags := BindFlags(fs)
+	if err := fs.Parse([]string{"-listen", ":9000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path, lookupEnv, applyFlags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      any
+		expected any
+	}{
+		{"flag beats env and file", c.Server.Address, ":9000"},
+		{"env beats file", c.Models.Auxiliary, "env-model"},
+		{"file beats default", c.Retrieval.RetrieverTimeout.Duration, 3 * time.Second},
+		{"file replaces lists", strings.Join(c.Server.CORS.AllowedOrigins, ","), "https://file.example.com"},
+		{"default kept", c.Retrieval.GenerationTimeout.Duration, 2 * time.Minute},
+		{"secret from env", c.APIKeys.OpenAI, "sk-env"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.name, tt.got, tt.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Default()
+	valid.APIKeys = APIKeys{OpenAI: "sk-openai", Anthropic: "sk-ant"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected default config with keys to be valid, got %v", err)
+	}
+
+	invalid := valid
+	invalid.Server.Address = "5080"
+	invalid.Server.TLS.CertFile = "cert.pem"
+	invalid.Server.CORS.AllowedOrigins = []string{"*", "localhost:3000"}
+	invalid.Retrieval.RetrieverTimeout = Duration{}
+	invalid.Retrieval.Rerank = "cross-encoder"
+	invalid.Retrieval.QueryPlanning = "magic"
+	invalid.APIKeys.Anthropic = ""
+	invalid.Models.Allowed = []models.Model{{Provider: models.ProviderGroq, Name: "llama-3.3-70b-versatile"}, {Provider: "mistral", Name: "large"}}
+	invalid.Models.MaxTokens = 0
+	invalid.Fixtures.Mode = "record"
+	invalid.History.Store = "postgres"
+
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("Expected invalid config to fail validation")
+	}
+	for _, expected := range []string{"server.address", "keyFile", "any origin", "localhost:3000", "retrieverTimeout", "retrieval.rerank", "retrieval.queryPlanning", "ANTHROPIC_API_KEY", "GROQ_API_KEY", "mistral", "models.maxTokens", "fixtures.dir", "history.store"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected validation error to mention %q, got %v", expected, err)
+		}
+	}
+
+	replay := Default()
+	replay.Fixtures = FixturesConfig{Mode: "replay", Dir: "fixtures"}
+	if err := replay.Validate(); err != nil {
+		t.Errorf("Expected replaying without API keys to be valid, got %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Default()
+	c.APIKeys = APIKeys{OpenAI: "sk-openai", Exa: "exa-key"}
+
+	r := c.Redacted()
+	if r.APIKeys.OpenAI != redacted || r.APIKeys.Exa != redacted {
+		t.Errorf("Got: %+v, Expected: set keys redacted", r.APIKeys)
+	}
+	if r.APIKeys.Groq != "" {
+		t.Errorf("Got: %v, Expected: unset keys left empty", r.APIKeys.Groq)
+	}
+	if c.APIKeys.OpenAI != "sk-openai" {
+		t.Errorf("Expected redacting to leave the original config alone")
+	}
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"raglib-demo/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// override is a setting that can be set from the environment, a flag or both. API keys are environment only so they
+// don't end up in shell history or process listings.
+type override struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var overrides = []override{
+	{env: "RAGLIB_LISTEN_ADDRESS", flag: "listen", usage: "The address to listen on, ie :5080", set: func(c *Config, v string) error {
+		c.Server.Address = v
+		return nil
+	}},
+	{env: "RAGLIB_TLS_CERT_FILE", flag: "tls-cert", usage: "TLS certificate file, enables HTTPS along with -tls-key", set: func(c *Config, v string) error {
+		c.Server.TLS.CertFile = v
+		return nil
+	}},
+	{env: "RAGLIB_TLS_KEY_FILE", flag: "tls-key", usage: "TLS private key file", set: func(c *Config, v string) error {
+		c.Server.TLS.KeyFile = v
+		return nil
+	}},
+	{env: "RAGLIB_CORS_ORIGINS", flag: "cors-origins", usage: "Comma separated origins allowed to make cross origin requests", set: func(c *Config, v string) error {
+		c.Server.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{env: "QDRANT_ADDRESS", flag: "addr", usage: "The address of the Qdrant instance to connect to", set: func(c *Config, v string) error {
+		c.Qdrant.Address = v
+		return nil
+	}},
+	{env: "RAGLIB_CORPORA", flag: "corpora", usage: "Path to the corpus config file, built in corpora are used if it doesn't exist", set: func(c *Config, v string) error {
+		c.Qdrant.CorporaPath = v
+		return nil
+	}},
+	{env: "RAGLIB_RETRIEVER_TIMEOUT", flag: "retriever-timeout", usage: "How long each retriever gets, ie 8s", set: func(c *Config, v string) error {
+		return setDuration(&c.Retrieval.RetrieverTimeout, v)
+	}},
+	{env: "RAGLIB_GENERATION_TIMEOUT", flag: "generation-timeout", usage: "How long generating an answer may take, ie 2m", set: func(c *Config, v string) error {
+		return setDuration(&c.Retrieval.GenerationTimeout, v)
+	}},
+	{env: "RAGLIB_RERANK", flag: "rerank", usage: "How documents are reranked by default: off, lexical or llm", set: func(c *Config, v string) error {
+		c.Retrieval.Rerank = v
+		return nil
+	}},
+	{env: "RAGLIB_RERANK_CUTOFF", flag: "rerank-cutoff", usage: "Relevance score, from 0 to 1, reranked documents need to be kept", set: func(c *Config, v string) error {
+		cutoff, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", v)
+		}
+		c.Retrieval.RerankCutoff = cutoff
+		return nil
+	}},
+	{env: "RAGLIB_QUERY_PLANNING", flag: "query-planning", usage: "How queries are rewritten and split before retrieval by default: off, rules or llm", set: func(c *Config, v string) error {
+		c.Retrieval.QueryPlanning = v
+		return nil
+	}},
+	{env: "RAGLIB_AUXILIARY_MODEL", flag: "auxiliary-model", usage: "OpenAI model for query rewriting and judging citations", set: func(c *Config, v string) error {
+		c.Models.Auxiliary = v
+		return nil
+	}},
+	{env: "RAGLIB_ANSWER_MODEL", flag: "answer-model", usage: "The default model answers are generated with, as provider/name", set: func(c *Config, v string) error {
+		m, err := models.ParseModel(v)
+		if err != nil {
+			return err
+		}
+		c.Models.Answer = m
+		return nil
+	}},
+	{env: "RAGLIB_ALLOWED_MODELS", flag: "allowed-models", usage: "Comma separated models, as provider/name, searches can pick", set: func(c *Config, v string) error {
+		var allowed []models.Model
+		for _, raw := range splitList(v) {
+			m, err := models.ParseModel(raw)
+			if err != nil {
+				return err
+			}
+			allowed = append(allowed, m)
+		}
+		c.Models.Allowed = allowed
+		return nil
+	}},
+	{env: "RAGLIB_MAX_TOKENS", flag: "max-tokens", usage: "The most tokens an answer can be", set: func(c *Config, v string) error {
+		tokens, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q isn't a whole number", v)
+		}
+		c.Models.MaxTokens = tokens
+		return nil
+	}},
+	{env: "RAGLIB_CONTEXT_TOKENS", flag: "context-tokens", usage: "How many tokens of passages answers are generated from", set: func(c *Config, v string) error {
+		tokens, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q isn't a whole number", v)
+		}
+		c.Models.ContextTokens = tokens
+		return nil
+	}},
+	{env: "RAGLIB_TRACING_EXPORTER", flag: "tracing-exporter", usage: "Where to send trace spans: none, stdout or otlp", set: func(c *Config, v string) error {
+		c.Tracing.Exporter = v
+		return nil
+	}},
+	{env: "RAGLIB_TRACING_ENDPOINT", flag: "tracing-endpoint", usage: "OTLP gRPC endpoint for trace spans, ie localhost:4317", set: func(c *Config, v string) error {
+		c.Tracing.Endpoint = v
+		return nil
+	}},
+	{env: "RAGLIB_KEY_FILE", flag: "key-file", usage: "Path to the API key file, the API is open without one", set: func(c *Config, v string) error {
+		c.Auth.KeyFile = v
+		return nil
+	}},
+	{env: "RAGLIB_FIXTURES_MODE", flag: "fixtures-mode", usage: "Record external calls to, or replay them from, the fixture directory: off, record or replay", set: func(c *Config, v string) error {
+		c.Fixtures.Mode = v
+		return nil
+	}},
+	{env: "RAGLIB_FIXTURES_DIR", flag: "fixtures-dir", usage: "Directory fixtures are recorded to and replayed from", set: func(c *Config, v string) error {
+		c.Fixtures.Dir = v
+		return nil
+	}},
+	{env: "RAGLIB_HISTORY_STORE", flag: "history-store", usage: "Where searches are recorded: off, jsonl or sqlite", set: func(c *Config, v string) error {
+		c.History.Store = v
+		return nil
+	}},
+	{env: "RAGLIB_HISTORY_PATH", flag: "history-path", usage: "History JSONL file or SQLite database", set: func(c *Config, v string) error {
+		c.History.Path = v
+		return nil
+	}},
+	{env: "OPENAI_API_KEY", set: func(c *Config, v string) error {
+		c.APIKeys.OpenAI = v
+		return nil
+	}},
+	{env: "ANTHROPIC_API_KEY", set: func(c *Config, v string) error {
+		c.APIKeys.Anthropic = v
+		return nil
+	}},
+	{env: "GROQ_API_KEY", set: func(c *Config, v string) error {
+		c.APIKeys.Groq = v
+		return nil
+	}},
+	{env: "SERPAPI_API_KEY", set: func(c *Config, v string) error {
+		c.APIKeys.SERP = v
+		return nil
+	}},
+	{env: "EXA_API_KEY", set: func(c *Config, v string) error {
+		c.APIKeys.Exa = v
+		return nil
+	}},
+}
+
+func applyEnv(c *Config, lookupEnv func(string) (string, bool)) error {
+	if lookupEnv == nil {
+		return nil
+	}
+
+	for _, o := range overrides {
+		v, ok := lookupEnv(o.env)
+		if !ok || v == "" {
+			continue
+		}
+		if err := o.set(c, v); err != nil {
+			return fmt.Errorf("environment variable, %v, is invalid: %w", o.env, err)
+		}
+	}
+	return nil
+}
+
+// BindFlags registers a flag for each setting that can be overridden from the command line. The returned func, meant
+// to be passed to Load, applies only the flags that were explicitly set so they don't clobber the file or environment
+// with defaults.
+func BindFlags(fs *flag.FlagSet) func(*Config) error {
+	values := make(map[string]*string)
+	for _, o := range overrides {
+		if o.flag == "" {
+			continue
+		}
+		values[o.flag] = fs.String(o.flag, "", fmt.Sprintf("%v (env %v)", o.usage, o.env))
+	}
+
+	return func(c *Config) error {
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			if err != nil {
+				return
+			}
+			for _, o := range overrides {
+				if o.flag != f.Name {
+					continue
+				}
+				if setErr := o.set(c, *values[o.flag]); setErr != nil {
+					err = fmt.Errorf("flag, -%v, is invalid: %w", o.flag, setErr)
+				}
+			}
+		})
+		return err
+	}
+}
+
+func setDuration(d *Duration, v string) error {
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
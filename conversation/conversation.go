@@ -0,0 +1,135 @@
+package conversation
+
+import (
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("conversation not found")
+
+type Conversation struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Principal is the name of the API key the conversation was started with, only it can continue the conversation
+	Principal string `json:"principal,omitempty"`
+	// Corpora are searched for messages that don't name their own
+	Corpora []string `json:"corpora"`
+	Turns   []Turn   `json:"turns"`
+}
+
+// Turn is a question and the answer it got
+type Turn struct {
+	Question string `json:"question"`
+	// StandaloneQuery is the question rewritten to make sense without the rest of the conversation, it's what was
+	// actually retrieved with
+	StandaloneQuery string              `json:"standaloneQuery"`
+	Corpora         []string            `json:"corpora"`
+	Documents       []document.Document `json:"documents"`
+	Answer          string              `json:"answer"`
+	AnsweredAt      time.Time           `json:"answeredAt"`
+}
+
+type Store interface {
+	Create(principal string, corpora []string) (Conversation, error)
+	Get(id string) (Conversation, error)
+	AppendTurn(id string, turn Turn) error
+}
+
+// MemoryStore keeps conversations in memory, they're lost on restart. Conversations are dropped once they've been
+// idle for longer than idleTimeout, and the least recently active are dropped to stay within maxConversations.
+type MemoryStore struct {
+	mu               sync.RWMutex
+	conversations    map[string]*memoryConversation
+	idleTimeout      time.Duration
+	maxConversations int
+	now              func() time.Time
+}
+
+type memoryConversation struct {
+	Conversation
+	lastActive time.Time
+}
+
+func NewMemoryStore(idleTimeout time.Duration, maxConversations int) *MemoryStore {
+	return &MemoryStore{
+		conversations:    make(map[string]*memoryConversation),
+		idleTimeout:      idleTimeout,
+		maxConversations: maxConversations,
+		now:              time.Now,
+	}
+}
+
+func (s *MemoryStore) Create(principal string, corpora []string) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+
+	now := s.now()
+	c := &memoryConversation{
+		Conversation: Conversation{
+			ID:        uuid.NewString(),
+			CreatedAt: now.UTC(),
+			Principal: principal,
+			Corpora:   corpora,
+			Turns:     []Turn{},
+		},
+		lastActive: now,
+	}
+	s.conversations[c.ID] = c
+	return c.Conversation, nil
+}
+
+func (s *MemoryStore) Get(id string) (Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.conversations[id]
+	if !ok || s.expired(c) {
+		return Conversation{}, ErrNotFound
+	}
+
+	copied := c.Conversation
+	copied.Turns = append([]Turn(nil), c.Turns...)
+	return copied, nil
+}
+
+func (s *MemoryStore) AppendTurn(id string, turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[id]
+	if !ok || s.expired(c) {
+		return ErrNotFound
+	}
+	c.Turns = append(c.Turns, turn)
+	c.lastActive = s.now()
+	return nil
+}
+
+func (s *MemoryStore) expired(c *memoryConversation) bool {
+	return s.now().Sub(c.lastActive) > s.idleTimeout
+}
+
+// evict drops idle conversations, then the least recently active until there's room for one more. It must be called
+// with mu held.
+func (s *MemoryStore) evict() {
+	for id, c := range s.conversations {
+		if s.expired(c) {
+			delete(s.conversations, id)
+		}
+	}
+
+	for len(s.conversations) >= s.maxConversations && len(s.conversations) > 0 {
+		var oldest *memoryConversation
+		for _, c := range s.conversations {
+			if oldest == nil || c.lastActive.Before(oldest.lastActive) {
+				oldest = c
+			}
+		}
+		delete(s.conversations, oldest.ID)
+	}
+}
@@ -0,0 +1,107 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type scriptedCompleter struct {
+	reply      string
+	lastPrompt string
+}
+
+func (c *scriptedCompleter) Complete(ctx context.Context, system string, prompt string) (string, error) {
+	c.lastPrompt = prompt
+	return c.reply, nil
+}
+
+func TestLLMRewriter(t *testing.T) {
+	history := []Turn{{Question: "How do I handle errors in Go?", Answer: "Return them as values."}}
+
+	completer := &scriptedCompleter{reply: ` "error handling in Python" `}
+	query, err := NewLLMRewriter(completer).Rewrite(context.Background(), history, "what about in Python?")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if query != "error handling in Python" {
+		t.Errorf("Unexpected query: %q", query)
+	}
+	if !strings.Contains(completer.lastPrompt, "User: How do I handle errors in Go?") || !strings.Contains(completer.lastPrompt, "what about in Python?") {
+		t.Errorf("Expected prompt to include the conversation and follow-up, got: %q", completer.lastPrompt)
+	}
+
+	completer = &scriptedCompleter{reply: "should not be used"}
+	query, _ = NewLLMRewriter(completer).Rewrite(context.Background(), nil, "first question")
+	if query != "first question" || completer.lastPrompt != "" {
+		t.Errorf("Expected first questions to be used as is, got: %q", query)
+	}
+}
+
+func TestPromptKeepsRecentTurns(t *testing.T) {
+	var history []Turn
+	for _, q := range []string{"q1", "q2", "q3", "q4", "q5", "q6"} {
+		history = append(history, Turn{Question: q, Answer: "a"})
+	}
+
+	prompt := Prompt(history, "q7")
+	if strings.Contains(prompt, "User: q1\n") {
+		t.Errorf("Expected oldest turn to be dropped, got: %q", prompt)
+	}
+	if !strings.Contains(prompt, "User: q6\n") || !strings.HasSuffix(prompt, "Question: q7") {
+		t.Errorf("Expected recent turns and the question, got: %q", prompt)
+	}
+
+	if Prompt(nil, "q1") != "q1" {
+		t.Errorf("Expected prompt without history to be the question")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(time.Hour, 10)
+	c, _ := store.Create("", []string{"web"})
+
+	if err := store.AppendTurn(c.ID, Turn{Question: "q1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got, err := store.Get(c.ID)
+	if err != nil || len(got.Turns) != 1 {
+		t.Fatalf("Unexpected conversation: %+v, err: %v", got, err)
+	}
+
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore(time.Hour, 2)
+	store.now = func() time.Time { return now }
+
+	idle, _ := store.Create("", []string{"web"})
+	now = now.Add(30 * time.Minute)
+	active, _ := store.Create("", []string{"web"})
+	now = now.Add(31 * time.Minute)
+
+	if _, err := store.Get(idle.ID); err != ErrNotFound {
+		t.Errorf("Expected a conversation idle past the timeout to be gone, got: %v", err)
+	}
+	if err := store.AppendTurn(active.ID, Turn{Question: "q1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	// Idle conversations are evicted first, then the least recently active
+	second, _ := store.Create("", []string{"web"})
+	now = now.Add(time.Minute)
+	store.Create("", []string{"web"})
+	if _, err := store.Get(active.ID); err != ErrNotFound {
+		t.Errorf("Expected the least recently active conversation to be evicted, got: %v", err)
+	}
+	if _, err := store.Get(second.ID); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"raglib-demo/llm"
+	"strings"
+)
+
+const (
+	// historyTurns is how many previous turns are included in prompts, older turns rarely matter for a follow-up
+	historyTurns = 5
+	// historyAnswerLength truncates previous answers in prompts, enough to carry what was discussed
+	historyAnswerLength = 1500
+)
+
+const rewriteSystemPrompt = `You rewrite follow-up questions from a conversation into standalone search queries.
+The query must make sense without the conversation, so resolve pronouns and carry over the topic, language or
+technology being discussed. Reply with only the query, no quotes or explanation. If the question already stands on its
+own, reply with it unchanged.`
+
+// Rewriter turns a follow-up question into a query that can be retrieved with on its own, ie "what about in
+// Python?" after a question about Go error handling becomes "error handling in Python"
+type Rewriter interface {
+	Rewrite(ctx context.Context, history []Turn, question string) (string, error)
+}
+
+type LLMRewriter struct {
+	completer llm.Completer
+}
+
+func NewLLMRewriter(completer llm.Completer) LLMRewriter {
+	return LLMRewriter{completer: completer}
+}
+
+func (r LLMRewriter) Rewrite(ctx context.Context, history []Turn, question string) (string, error) {
+	if len(history) == 0 {
+		return question, nil
+	}
+
+	prompt := fmt.Sprintf("%s\nFollow-up question: %s\nStandalone query:", formatHistory(history), question)
+	query, err := r.completer.Complete(ctx, rewriteSystemPrompt, prompt)
+	if err != nil {
+		return "", fmt.Errorf("error rewriting question: %w", err)
+	}
+
+	query = strings.Trim(strings.TrimSpace(query), `"`)
+	if query == "" {
+		return question, nil
+	}
+	return query, nil
+}
+
+// Prompt is what the answerer is asked, the question along with the recent conversation so the answer can build on
+// earlier ones
+func Prompt(history []Turn, question string) string {
+	if len(history) == 0 {
+		return question
+	}
+
+	return fmt.Sprintf("%s\nAnswer the follow-up question below, taking the conversation above into account.\n\nQuestion: %s", formatHistory(history), question)
+}
+
+func formatHistory(history []Turn) string {
+	if len(history) > historyTurns {
+		history = history[len(history)-historyTurns:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Conversation so far:\n")
+	for _, turn := range history {
+		answer := turn.Answer
+		if len(answer) > historyAnswerLength {
+			answer = strings.ToValidUTF8(answer[:historyAnswerLength], "") + "…"
+		}
+		sb.WriteString(fmt.Sprintf("User: %s\nAssistant: %s\n", turn.Question, answer))
+	}
+	return sb.String()
+}
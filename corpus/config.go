@@ -0,0 +1,130 @@
+package corpus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Retriever types with built in support, any other type must have a factory registered with the Registry
+const (
+	TypeQdrant = "qdrant"
+	TypeExa    = "exa"
+	TypeSERP   = "serp"
+)
+
+// DefaultTopK is how many documents each retriever is asked for when a corpus doesn't say otherwise
+const DefaultTopK = 20
+
+type Config struct {
+	Corpora []Definition `json:"corpora"`
+}
+
+type Definition struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Retrievers  []RetrieverConfig `json:"retrievers"`
+	Fusion      FusionPolicy      `json:"fusion"`
+}
+
+type RetrieverConfig struct {
+	Type string `json:"type"`
+	// Source is the name results are fused under, defaults to Type
+	Source     string `json:"source,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	TopK       uint64 `json:"topK,omitempty"`
+	// Filters are payload fields that must match exactly, only supported by qdrant retrievers
+	Filters map[string]string `json:"filters,omitempty"`
+	// Hybrid turns on keyword (BM25) search alongside dense search for qdrant retrievers, with the weights each
+	// contributes when they're fused
+	Hybrid *HybridWeights `json:"hybrid,omitempty"`
+}
+
+type HybridWeights struct {
+	Dense  float64 `json:"dense"`
+	Sparse float64 `json:"sparse"`
+}
+
+func (w HybridWeights) Validate() error {
+	if w.Dense < 0 || w.Sparse < 0 {
+		return fmt.Errorf("hybrid weights can't be negative")
+	}
+	if w.Dense == 0 && w.Sparse == 0 {
+		return fmt.Errorf("at least one hybrid weight must be positive")
+	}
+	return nil
+}
+
+type FusionPolicy struct {
+	Strategy string             `json:"strategy,omitempty"`
+	Weights  map[string]float64 `json:"weights,omitempty"`
+}
+
+func (rc RetrieverConfig) SourceName() string {
+	if rc.Source != "" {
+		return rc.Source
+	}
+	return rc.Type
+}
+
+func (rc RetrieverConfig) TopKOrDefault() uint64 {
+	if rc.TopK > 0 {
+		return rc.TopK
+	}
+	return DefaultTopK
+}
+
+// Identity distinguishes retrievers that would return different results for the same query, it's stable across
+// restarts so it can be used in cache keys
+func (rc RetrieverConfig) Identity() string {
+	fields := make([]string, 0, len(rc.Filters))
+	for field, value := range rc.Filters {
+		fields = append(fields, field+"="+value)
+	}
+	sort.Strings(fields)
+
+	parts := []string{rc.Type, rc.SourceName(), rc.Collection, strings.Join(fields, "&")}
+	if rc.Hybrid != nil {
+		parts = append(parts, fmt.Sprintf("hybrid=%g:%g", rc.Hybrid.Dense, rc.Hybrid.Sparse))
+	}
+	return strings.Join(parts, "|")
+}
+
+// DefaultConfig is used when no corpus config file exists, it matches the corpora that used to be hard-coded
+func DefaultConfig(personalCollectionName string) Config {
+	return Config{Corpora: []Definition{
+		{
+			Name:        "personal",
+			Description: "Documents ingested via POST /documents",
+			Retrievers:  []RetrieverConfig{{Type: TypeQdrant, Collection: personalCollectionName, Hybrid: &HybridWeights{Dense: 1, Sparse: 1}}},
+			Fusion:      FusionPolicy{Strategy: "rrf"},
+		},
+		{
+			Name:        "web",
+			Description: "Web search results from SERP API and Exa",
+			Retrievers:  []RetrieverConfig{{Type: TypeExa}, {Type: TypeSERP}},
+			Fusion:      FusionPolicy{Strategy: "serpexa"},
+		},
+	}}
+}
+
+// LoadConfig reads a corpus config from a JSON file, falling back to DefaultConfig when the file doesn't exist
+func LoadConfig(path string, personalCollectionName string) (Config, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DefaultConfig(personalCollectionName), nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading corpus config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return Config{}, fmt.Errorf("error parsing corpus config %v: %w", path, err)
+	}
+	return config, nil
+}
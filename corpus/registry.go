@@ -0,0 +1,119 @@
+package corpus
+
+import (
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"raglib-demo/fusion"
+)
+
+// RetrieverFactory builds a retriever from its config, it's called once per configured retriever at startup
+type RetrieverFactory func(config RetrieverConfig) (retrieval.Retriever, error)
+
+// SourceRetriever is a configured retriever ready to be queried
+type SourceRetriever struct {
+	Source    string
+	TopK      uint64
+	Retriever retrieval.Retriever
+}
+
+type Corpus struct {
+	Definition
+	retrievers []SourceRetriever
+}
+
+func (c Corpus) Retrievers() []SourceRetriever {
+	return c.retrievers
+}
+
+type Registry struct {
+	corpora map[string]Corpus
+	// order keeps corpora listed in the order they were configured
+	order []string
+}
+
+func NewRegistry(config Config, factories map[string]RetrieverFactory) (*Registry, error) {
+	registry := &Registry{corpora: make(map[string]Corpus)}
+
+	for _, definition := range config.Corpora {
+		if definition.Name == "" {
+			return nil, fmt.Errorf("corpus is missing a name")
+		}
+		if _, exists := registry.corpora[definition.Name]; exists {
+			return nil, fmt.Errorf("corpus, %v, is defined more than once", definition.Name)
+		}
+		if len(definition.Retrievers) == 0 {
+			return nil, fmt.Errorf("corpus, %v, has no retrievers", definition.Name)
+		}
+		if _, err := fusion.New(definition.Fusion.Strategy, definition.Fusion.Weights); err != nil {
+			return nil, fmt.Errorf("corpus, %v: %w", definition.Name, err)
+		}
+		if err := fusion.ValidateWeights(definition.Fusion.Weights); err != nil {
+			return nil, fmt.Errorf("corpus, %v: %w", definition.Name, err)
+		}
+
+		c := Corpus{Definition: definition}
+		for _, rc := range definition.Retrievers {
+			factory, ok := factories[rc.Type]
+			if !ok {
+				return nil, fmt.Errorf("corpus, %v, has retriever of unknown type, %v", definition.Name, rc.Type)
+			}
+			if len(rc.Filters) > 0 && rc.Type != TypeQdrant {
+				return nil, fmt.Errorf("corpus, %v, sets filters on a %v retriever, only qdrant retrievers support filters", definition.Name, rc.Type)
+			}
+			if rc.Hybrid != nil {
+				if rc.Type != TypeQdrant {
+					return nil, fmt.Errorf("corpus, %v, sets hybrid weights on a %v retriever, only qdrant retrievers support hybrid search", definition.Name, rc.Type)
+				}
+				if err := rc.Hybrid.Validate(); err != nil {
+					return nil, fmt.Errorf("corpus, %v: %w", definition.Name, err)
+				}
+			}
+
+			r, err := factory(rc)
+			if err != nil {
+				return nil, fmt.Errorf("corpus, %v, error building %v retriever: %w", definition.Name, rc.Type, err)
+			}
+			c.retrievers = append(c.retrievers, SourceRetriever{Source: rc.SourceName(), TopK: rc.TopKOrDefault(), Retriever: r})
+		}
+
+		registry.corpora[definition.Name] = c
+		registry.order = append(registry.order, definition.Name)
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) Get(name string) (Corpus, bool) {
+	c, ok := r.corpora[name]
+	return c, ok
+}
+
+func (r *Registry) Definitions() []Definition {
+	definitions := make([]Definition, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.corpora[name].Definition)
+	}
+	return definitions
+}
+
+// ChooseFusion picks the fusion strategy for a search over the given corpora. A strategy named in the request wins,
+// otherwise the first corpus that sets one decides. Weights from every corpus are merged, with request weights taking
+// precedence.
+func ChooseFusion(corpora []Corpus, requestedStrategy string, requestedWeights map[string]float64) (fusion.Strategy, error) {
+	strategy := requestedStrategy
+	weights := make(map[string]float64)
+
+	for _, c := range corpora {
+		if strategy == "" {
+			strategy = c.Fusion.Strategy
+		}
+		for source, w := range c.Fusion.Weights {
+			weights[source] = w
+		}
+	}
+	for source, w := range requestedWeights {
+		weights[source] = w
+	}
+
+	return fusion.New(strategy, weights)
+}
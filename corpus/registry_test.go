@@ -0,0 +1,164 @@
+package corpus
+
+import (
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"raglib-demo/fusion"
+	"reflect"
+	"testing"
+)
+
+type nopRetriever struct{}
+
+func (nopRetriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	return nil, nil
+}
+
+func nopFactory(config RetrieverConfig) (retrieval.Retriever, error) {
+	return nopRetriever{}, nil
+}
+
+var testFactories = map[string]RetrieverFactory{TypeQdrant: nopFactory, TypeExa: nopFactory, TypeSERP: nopFactory}
+
+func TestNewRegistry(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    Config
+		expectErr bool
+	}{
+		{
+			name:   "Default config is valid",
+			config: DefaultConfig("text_collection"),
+		},
+		{
+			name: "Unknown retriever type",
+			config: Config{Corpora: []Definition{
+				{Name: "wiki", Retrievers: []RetrieverConfig{{Type: "elasticsearch"}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "Duplicate corpus",
+			config: Config{Corpora: []Definition{
+				{Name: "web", Retrievers: []RetrieverConfig{{Type: TypeExa}}},
+				{Name: "web", Retrievers: []RetrieverConfig{{Type: TypeSERP}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "Filters on a web retriever",
+			config: Config{Corpora: []Definition{
+				{Name: "web", Retrievers: []RetrieverConfig{{Type: TypeExa, Filters: map[string]string{"team": "platform"}}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "Hybrid search on a web retriever",
+			config: Config{Corpora: []Definition{
+				{Name: "web", Retrievers: []RetrieverConfig{{Type: TypeSERP, Hybrid: &HybridWeights{Dense: 1, Sparse: 1}}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "Hybrid search with no positive weight",
+			config: Config{Corpora: []Definition{
+				{Name: "notes", Retrievers: []RetrieverConfig{{Type: TypeQdrant, Collection: "notes", Hybrid: &HybridWeights{}}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "Unknown fusion strategy",
+			config: Config{Corpora: []Definition{
+				{Name: "web", Retrievers: []RetrieverConfig{{Type: TypeExa}}, Fusion: FusionPolicy{Strategy: "magic"}},
+			}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRegistry(tc.config, testFactories)
+			if tc.expectErr && err == nil {
+				t.Fatalf("Expected an error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// The checked in corpora.json is loaded by default in place of DefaultConfig, so it needs to keep up with it
+func TestCheckedInConfig(t *testing.T) {
+	config, err := LoadConfig("../corpora.json", "text_collection")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := NewRegistry(config, testFactories); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, definition := range config.Corpora {
+		for _, r := range definition.Retrievers {
+			if r.Type != TypeQdrant {
+				continue
+			}
+			expected := HybridWeights{Dense: 1, Sparse: 1}
+			if r.Hybrid == nil || *r.Hybrid != expected {
+				t.Errorf("Unexpected hybrid weights for %v. Got: %+v, Expected: %+v", definition.Name, r.Hybrid, expected)
+			}
+		}
+	}
+}
+
+func TestRegistryRetrievers(t *testing.T) {
+	registry, err := NewRegistry(Config{Corpora: []Definition{
+		{Name: "team", Retrievers: []RetrieverConfig{{Type: TypeQdrant, Source: "team-docs", Collection: "team", TopK: 5}}},
+		{Name: "web", Retrievers: []RetrieverConfig{{Type: TypeExa}}},
+	}}, testFactories)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if names := []string{registry.Definitions()[0].Name, registry.Definitions()[1].Name}; !reflect.DeepEqual(names, []string{"team", "web"}) {
+		t.Errorf("Definitions not in configured order: %v", names)
+	}
+
+	team, ok := registry.Get("team")
+	if !ok {
+		t.Fatalf("Expected corpus, team, to be registered")
+	}
+	if r := team.Retrievers()[0]; r.Source != "team-docs" || r.TopK != 5 {
+		t.Errorf("Unexpected retriever: %+v", r)
+	}
+
+	web, _ := registry.Get("web")
+	if r := web.Retrievers()[0]; r.Source != TypeExa || r.TopK != DefaultTopK {
+		t.Errorf("Unexpected retriever: %+v", r)
+	}
+}
+
+func TestChooseFusion(t *testing.T) {
+	corpora := []Corpus{
+		{Definition: Definition{Name: "personal", Fusion: FusionPolicy{Strategy: fusion.NameReciprocalRank, Weights: map[string]float64{"qdrant": 2, "exa": 1}}}},
+		{Definition: Definition{Name: "web", Fusion: FusionPolicy{Strategy: fusion.NameSERPCoveredByExa}}},
+	}
+
+	strategy, err := ChooseFusion(corpora, "", map[string]float64{"exa": 3})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := fusion.ReciprocalRank{K: fusion.DefaultRRFK, Weights: map[string]float64{"qdrant": 2, "exa": 3}}
+	if !reflect.DeepEqual(strategy, expected) {
+		t.Errorf("Unexpected strategy. Got: %+v, Expected: %+v", strategy, expected)
+	}
+
+	strategy, err = ChooseFusion(corpora, fusion.NameRoundRobin, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := strategy.(fusion.WeightedRoundRobin); !ok {
+		t.Errorf("Expected requested strategy to win, got: %T", strategy)
+	}
+}
@@ -0,0 +1,65 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Case is one query of an eval dataset, along with what a good search for it finds and answers
+type Case struct {
+	ID    string `json:"id"`
+	Query string `json:"query"`
+	// Corpora are searched for the query, the run's default corpora are used when it's empty
+	Corpora []string `json:"corpus,omitempty"`
+	// ExpectedURLs are the documents retrieval should find, recall, MRR and citation precision are measured against
+	// them
+	ExpectedURLs []string `json:"expectedUrls,omitempty"`
+	// ReferenceAnswer is a good answer to compare the generated one to
+	ReferenceAnswer string `json:"referenceAnswer,omitempty"`
+	// IrrelevantURLs are documents that shouldn't be cited, ie ones a user flagged on the answer they gave feedback on
+	IrrelevantURLs []string `json:"irrelevantUrls,omitempty"`
+	// Comment is a note for whoever curates the dataset, it isn't used when running the case
+	Comment string `json:"comment,omitempty"`
+}
+
+// LoadDataset reads a JSONL dataset, one Case per line. Blank lines are skipped and cases without an ID are numbered
+// by their line.
+func LoadDataset(r io.Reader) ([]Case, error) {
+	var cases []Case
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+
+		var c Case
+		if err := json.Unmarshal([]byte(raw), &c); err != nil {
+			return nil, fmt.Errorf("line %d isn't a valid case: %w", line, err)
+		}
+		if strings.TrimSpace(c.Query) == "" {
+			return nil, fmt.Errorf("line %d has no 'query'", line)
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("line-%d", line)
+		}
+		if previous, ok := seen[c.ID]; ok {
+			return nil, fmt.Errorf("line %d has the same id, %q, as line %d", line, c.ID, previous)
+		}
+		seen[c.ID] = line
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading dataset: %w", err)
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("dataset has no cases")
+	}
+	return cases, nil
+}
@@ -0,0 +1,192 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"math"
+	"net/url"
+	"raglib-demo/answer"
+	"raglib-demo/api"
+	"raglib-demo/config"
+	"raglib-demo/corpus"
+	"raglib-demo/fakes"
+	"raglib-demo/history"
+	"raglib-demo/models"
+	"strings"
+	"testing"
+)
+
+func TestLoadDataset(t *testing.T) {
+	raw := `{"id": "deploys", "query": "when do deploys run?", "expectedUrls": ["https://wiki.example.com/deploys"]}
+
+{"query": "what is the on-call rotation?", "corpus": ["personal"]}
+`
+	cases, err := LoadDataset(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cases) != 2 || cases[0].ID != "deploys" || cases[1].ID != "line-3" || cases[1].Corpora[0] != "personal" {
+		t.Errorf("Unexpected cases. Got: %+v", cases)
+	}
+
+	for _, invalid := range []string{
+		`{"id": "a"}`,
+		`{"id": "a", "query": "q"}` + "\n" + `{"id": "a", "query": "q"}`,
+		`not json`,
+		"",
+	} {
+		if _, err := LoadDataset(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	retrieved := []string{"https://a.example.com/", "https://www.b.example.com/page#section", "https://c.example.com"}
+	expected := []string{"http://b.example.com/page", "https://d.example.com"}
+
+	annotated := answer.Annotated{
+		Text:      "Deploys run on Tuesdays. They take an hour.\n\n```sh\nmake deploy\n```",
+		Citations: []answer.Citation{{DocumentIndex: 1, SpanStart: 0, SpanEnd: 23}},
+		CodeBlocks: []answer.CodeBlock{
+			{Start: 45, End: 66},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		got      *float64
+		expected *float64
+	}{
+		{"recall@2", RecallAtK(retrieved, expected, 2), score(0.5)},
+		{"recall@1", RecallAtK(retrieved, expected, 1), score(0)},
+		{"recall without expected URLs", RecallAtK(retrieved, nil, 2), nil},
+		{"mrr", ReciprocalRank(retrieved, expected), score(0.5)},
+		{"mrr with nothing found", ReciprocalRank(retrieved[:1], expected), score(0)},
+		{"citation precision", CitationPrecision([]string{retrieved[1], retrieved[0]}, expected), score(0.5)},
+		{"citation precision without citations", CitationPrecision(nil, expected), nil},
+		{"citation coverage skips code blocks", CitationCoverage(annotated), score(0.5)},
+		{"identical answers", AnswerSimilarity("Deploys run on Tuesdays.", "deploys run on tuesdays"), score(1)},
+		{"partly similar answers", AnswerSimilarity("Deploys run on Tuesdays", "Deploys run weekly"), score(4.0 / 7)},
+		{"similarity without a reference", AnswerSimilarity("Deploys run on Tuesdays", ""), nil},
+		{"irrelevant citations", IrrelevantCitationRate([]string{retrieved[1], retrieved[0]}, []string{"https://a.example.com"}), score(0.5)},
+		{"irrelevant citations without flagged URLs", IrrelevantCitationRate([]string{retrieved[0]}, nil), nil},
+	}
+
+	for _, tt := range tests {
+		if (tt.got == nil) != (tt.expected == nil) || (tt.got != nil && math.Abs(*tt.got-*tt.expected) > 1e-9) {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.name, formatScore(tt.got), formatScore(tt.expected))
+		}
+	}
+}
+
+func TestRunner(t *testing.T) {
+	retriever := &fakes.Retriever{Documents: []document.Document{
+		{Passages: []document.Passage{{Text: "Deploys run on Tuesdays."}}, WebReference: &document.WebReference{Link: "https://wiki.example.com/deploys"}},
+		{Passages: []document.Passage{{Text: "The cafeteria opens at noon."}}, WebReference: &document.WebReference{Link: "https://wiki.example.com/cafeteria"}},
+	}}
+	generator := &fakes.Generator{Chunks: []string{"Deploys run on Tuesdays <cited>0</cited>."}}
+
+	definition := corpus.Definition{
+		Name:       "test",
+		Fusion:     corpus.FusionPolicy{Strategy: "rrf"},
+		Retrievers: []corpus.RetrieverConfig{{Type: "scripted", Source: "wiki"}},
+	}
+	cfg := config.Default()
+	cfg.History.Store = history.StoreOff
+	cfg.Models.Allowed = []models.Model{{Provider: models.ProviderOpenAI, Name: "gpt-4o-mini"}}
+	server, err := api.NewServer(cfg, corpus.Config{Corpora: []corpus.Definition{definition}},
+		api.WithRetrieverFactory("scripted", func(corpus.RetrieverConfig) (retrieval.Retriever, error) { return retriever, nil }),
+		api.WithGenerator(generator),
+		api.WithCompleter(fakes.Completer{Reply: "1"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []Case{
+		{ID: "deploys", Query: "when do deploys run?", ExpectedURLs: []string{"https://wiki.example.com/deploys"}, ReferenceAnswer: "Deploys run on Tuesdays."},
+		{ID: "missing-corpus", Query: "anything", Corpora: []string{"nonexistent"}},
+	}
+	runner := NewRunner(server.Handler(), []string{"test"}, url.Values{"model": {"gpt-4o-mini"}, "style": {"concise"}}, DefaultK)
+	results := runner.Run(context.Background(), cases, nil)
+
+	if len(results) != 2 {
+		t.Fatalf("Got: %v results, Expected: 2", len(results))
+	}
+	deploys := results[0]
+	if deploys.Error != "" {
+		t.Fatalf("Unexpected error: %v", deploys.Error)
+	}
+	if len(deploys.Cited) != 1 || deploys.Cited[0] != "https://wiki.example.com/deploys" {
+		t.Errorf("Got: %v, Expected: the deploys page cited", deploys.Cited)
+	}
+	for name, v := range map[string]*float64{
+		"recall":     deploys.Metrics.RecallAtK,
+		"mrr":        deploys.Metrics.MRR,
+		"precision":  deploys.Metrics.CitationPrecision,
+		"coverage":   deploys.Metrics.CitationCoverage,
+		"similarity": deploys.Metrics.AnswerSimilarity,
+	} {
+		if v == nil || *v != 1 {
+			t.Errorf("%v, Got: %v, Expected: 1", name, formatScore(v))
+		}
+	}
+	if settings := generator.Settings(); len(settings) != 1 || settings[0].Style != "concise" {
+		t.Errorf("Got: %+v, Expected: the run's params passed to the search", settings)
+	}
+	if results[1].Error == "" {
+		t.Errorf("Expected searching a nonexistent corpus to fail the case")
+	}
+
+	report := NewReport("eval.jsonl", "model=gpt-4o-mini&style=concise", DefaultK, results)
+	if report.Summary.Errors != 1 || report.Summary.RecallAtK == nil || *report.Summary.RecallAtK != 1 {
+		t.Errorf("Unexpected summary. Got: %+v", report.Summary)
+	}
+
+	var markdown bytes.Buffer
+	if err := report.WriteMarkdown(&markdown, &report); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"| Recall@5 | 1.000 | 1.000 | +0.000 |", "| deploys | when do deploys run? | 1.000 |", "| missing-corpus | anything | error: "} {
+		if !strings.Contains(markdown.String(), expected) {
+			t.Errorf("Expected the Markdown to contain %q, got:\n%v", expected, markdown.String())
+		}
+	}
+}
+
+func TestFeedbackCases(t *testing.T) {
+	documents := []document.Document{
+		{WebReference: &document.WebReference{Link: "https://wiki.example.com/deploys"}},
+		{WebReference: &document.WebReference{Link: "https://wiki.example.com/cafeteria"}},
+	}
+	citations := []answer.Citation{{DocumentIndex: 0}, {DocumentIndex: 1}, {DocumentIndex: 1}}
+	records := []history.Record{
+		{ID: "a", Query: "when do deploys run?", Corpora: []string{"wiki"}, Documents: documents, Citations: citations,
+			Feedback: &history.Feedback{Rating: history.RatingDown, Comment: "They moved to Wednesdays", IrrelevantCitations: []int{1, 2}}},
+		{ID: "b", Query: "who is on call?", Feedback: &history.Feedback{Rating: history.RatingUp}},
+		{ID: "c", Query: "where is the cafeteria?"},
+	}
+
+	var out bytes.Buffer
+	if err := WriteDataset(&out, FeedbackCases(records)); err != nil {
+		t.Fatal(err)
+	}
+	cases, err := LoadDataset(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cases) != 1 {
+		t.Fatalf("Got: %+v, Expected: a case for the one search rated down", cases)
+	}
+	got := cases[0]
+	if got.ID != "feedback-a" || got.Query != "when do deploys run?" || got.Corpora[0] != "wiki" || got.Comment != "They moved to Wednesdays" {
+		t.Errorf("Unexpected case. Got: %+v", got)
+	}
+	if len(got.IrrelevantURLs) != 1 || got.IrrelevantURLs[0] != "https://wiki.example.com/cafeteria" {
+		t.Errorf("Got: %v, Expected: the flagged source once", got.IrrelevantURLs)
+	}
+}
@@ -0,0 +1,45 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"raglib-demo/fusion"
+	"raglib-demo/history"
+	"strings"
+)
+
+// FeedbackCases turns searches users rated down into cases. They have no expected URLs or reference answer, those
+// have to be filled in by hand, but the sources users flagged as irrelevant are carried over as IrrelevantURLs and
+// their comment as Comment.
+func FeedbackCases(records []history.Record) []Case {
+	cases := make([]Case, 0, len(records))
+	for _, r := range records {
+		if r.Feedback == nil || r.Feedback.Rating != history.RatingDown || strings.TrimSpace(r.Query) == "" {
+			continue
+		}
+
+		c := Case{
+			ID:      "feedback-" + r.ID,
+			Query:   r.Query,
+			Corpora: r.Corpora,
+			Comment: r.Feedback.Comment,
+		}
+		for _, d := range r.IrrelevantDocuments() {
+			c.IrrelevantURLs = append(c.IrrelevantURLs, fusion.Key(d))
+		}
+		cases = append(cases, c)
+	}
+	return cases
+}
+
+// WriteDataset writes cases as JSONL, so they can be read back by LoadDataset
+func WriteDataset(w io.Writer, cases []Case) error {
+	encoder := json.NewEncoder(w)
+	for _, c := range cases {
+		if err := encoder.Encode(c); err != nil {
+			return fmt.Errorf("error writing case %v: %w", c.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package eval
+
+import (
+	"net/url"
+	"raglib-demo/answer"
+	"raglib-demo/ingestion"
+	"strings"
+)
+
+// Metrics are a case's scores, each from 0 to 1. A metric is nil when the case can't be scored on it, ie recall
+// without expected URLs, and it's left out of the run's averages.
+type Metrics struct {
+	// RecallAtK is the fraction of expected URLs in the top k retrieved documents
+	RecallAtK *float64 `json:"recallAtK,omitempty"`
+	// MRR is the reciprocal rank of the first expected URL retrieved, 0 if none were
+	MRR *float64 `json:"mrr,omitempty"`
+	// CitationPrecision is the fraction of citations that cite an expected URL
+	CitationPrecision *float64 `json:"citationPrecision,omitempty"`
+	// CitationCoverage is the fraction of the answer's sentences, outside code blocks, with a citation
+	CitationCoverage *float64 `json:"citationCoverage,omitempty"`
+	// AnswerSimilarity is the term F1 between the answer and the reference answer
+	AnswerSimilarity *float64 `json:"answerSimilarity,omitempty"`
+	// IrrelevantCitations is the fraction of citations that cite an irrelevant URL, unlike the others lower is better
+	IrrelevantCitations *float64 `json:"irrelevantCitations,omitempty"`
+}
+
+// NormalizeURL makes URLs that point at the same page compare equal, ignoring the scheme, "www.", fragments, case in
+// the host and trailing slashes
+func NormalizeURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(raw, "/")
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	normalized := host + strings.TrimRight(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		normalized += "?" + u.RawQuery
+	}
+	return normalized
+}
+
+func urlSet(urls []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(urls))
+	for _, u := range urls {
+		set[NormalizeURL(u)] = struct{}{}
+	}
+	return set
+}
+
+// RecallAtK and ReciprocalRank score retrieved, in rank order, against expected. Both are nil without expected URLs.
+func RecallAtK(retrieved, expected []string, k int) *float64 {
+	if len(expected) == 0 {
+		return nil
+	}
+	want := urlSet(expected)
+	found := make(map[string]struct{})
+	for i, u := range retrieved {
+		if i >= k {
+			break
+		}
+		if _, ok := want[NormalizeURL(u)]; ok {
+			found[NormalizeURL(u)] = struct{}{}
+		}
+	}
+	return score(float64(len(found)) / float64(len(want)))
+}
+
+func ReciprocalRank(retrieved, expected []string) *float64 {
+	if len(expected) == 0 {
+		return nil
+	}
+	want := urlSet(expected)
+	for i, u := range retrieved {
+		if _, ok := want[NormalizeURL(u)]; ok {
+			return score(1 / float64(i+1))
+		}
+	}
+	return score(0)
+}
+
+// CitationPrecision is nil without expected URLs or without citations
+func CitationPrecision(cited, expected []string) *float64 {
+	if len(expected) == 0 || len(cited) == 0 {
+		return nil
+	}
+	want := urlSet(expected)
+	relevant := 0
+	for _, u := range cited {
+		if _, ok := want[NormalizeURL(u)]; ok {
+			relevant++
+		}
+	}
+	return score(float64(relevant) / float64(len(cited)))
+}
+
+// IrrelevantCitationRate is nil without irrelevant URLs or without citations
+func IrrelevantCitationRate(cited, irrelevant []string) *float64 {
+	if len(irrelevant) == 0 || len(cited) == 0 {
+		return nil
+	}
+	flagged := urlSet(irrelevant)
+	count := 0
+	for _, u := range cited {
+		if _, ok := flagged[NormalizeURL(u)]; ok {
+			count++
+		}
+	}
+	return score(float64(count) / float64(len(cited)))
+}
+
+// CitationCoverage is nil for an answer without any sentences
+func CitationCoverage(annotated answer.Annotated) *float64 {
+	spans := sentenceSpans(annotated)
+	if len(spans) == 0 {
+		return nil
+	}
+	covered := 0
+	for _, s := range spans {
+		for _, c := range annotated.Citations {
+			if c.SpanEnd > s[0] && c.SpanEnd <= s[1] {
+				covered++
+				break
+			}
+		}
+	}
+	return score(float64(covered) / float64(len(spans)))
+}
+
+// sentenceSpans splits the answer's text, outside code blocks, into sentences, returning their byte offsets
+func sentenceSpans(annotated answer.Annotated) [][2]int {
+	var spans [][2]int
+	text := annotated.Text
+	addSentences := func(start, end int) {
+		sentenceStart := start
+		for i := start; i < end; i++ {
+			boundary := text[i] == '\n' || ((text[i] == '.' || text[i] == '?' || text[i] == '!') && (i+1 == end || text[i+1] == ' ' || text[i+1] == '\n'))
+			if !boundary && i+1 < end {
+				continue
+			}
+			if strings.TrimSpace(text[sentenceStart:i+1]) != "" {
+				spans = append(spans, [2]int{sentenceStart, i + 1})
+			}
+			sentenceStart = i + 1
+		}
+	}
+
+	position := 0
+	for _, block := range annotated.CodeBlocks {
+		addSentences(position, block.Start)
+		position = block.End
+	}
+	addSentences(position, len(text))
+	return spans
+}
+
+// AnswerSimilarity is nil without a reference answer
+func AnswerSimilarity(generated, reference string) *float64 {
+	referenceTerms := ingestion.Terms(reference)
+	if len(referenceTerms) == 0 {
+		return nil
+	}
+	generatedTerms := ingestion.Terms(generated)
+	if len(generatedTerms) == 0 {
+		return score(0)
+	}
+
+	counts := make(map[string]int)
+	for _, term := range referenceTerms {
+		counts[term]++
+	}
+	overlap := 0
+	for _, term := range generatedTerms {
+		if counts[term] > 0 {
+			counts[term]--
+			overlap++
+		}
+	}
+	if overlap == 0 {
+		return score(0)
+	}
+
+	precision := float64(overlap) / float64(len(generatedTerms))
+	recall := float64(overlap) / float64(len(referenceTerms))
+	return score(2 * precision * recall / (precision + recall))
+}
+
+func score(v float64) *float64 {
+	return &v
+}
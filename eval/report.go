@@ -0,0 +1,175 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Report is a whole run. It has no timestamps or durations so two runs over the same dataset diff cleanly.
+type Report struct {
+	Dataset string `json:"dataset"`
+	// Params are the search parameters every case ran with
+	Params  string       `json:"params,omitempty"`
+	K       int          `json:"k"`
+	Summary Summary      `json:"summary"`
+	Cases   []CaseResult `json:"cases"`
+}
+
+// Summary averages each metric over the cases that could be scored on it
+type Summary struct {
+	Metrics
+	Cases  int `json:"cases"`
+	Errors int `json:"errors"`
+	// TotalTokens is the tokens generation used across every case, cached answers count as 0
+	TotalTokens int `json:"totalTokens"`
+}
+
+func NewReport(dataset string, params string, k int, results []CaseResult) Report {
+	summary := Summary{Cases: len(results)}
+	var recall, mrr, precision, coverage, similarity, irrelevant []float64
+	collect := func(values *[]float64, v *float64) {
+		if v != nil {
+			*values = append(*values, *v)
+		}
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			summary.Errors++
+			continue
+		}
+		if r.Metadata != nil {
+			summary.TotalTokens += r.Metadata.Usage.TotalTokens
+		}
+		collect(&recall, r.Metrics.RecallAtK)
+		collect(&mrr, r.Metrics.MRR)
+		collect(&precision, r.Metrics.CitationPrecision)
+		collect(&coverage, r.Metrics.CitationCoverage)
+		collect(&similarity, r.Metrics.AnswerSimilarity)
+		collect(&irrelevant, r.Metrics.IrrelevantCitations)
+	}
+	summary.Metrics = Metrics{
+		RecallAtK:           mean(recall),
+		MRR:                 mean(mrr),
+		CitationPrecision:   mean(precision),
+		CitationCoverage:    mean(coverage),
+		AnswerSimilarity:    mean(similarity),
+		IrrelevantCitations: mean(irrelevant),
+	}
+
+	return Report{Dataset: dataset, Params: params, K: k, Summary: summary, Cases: results}
+}
+
+func mean(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return score(total / float64(len(values)))
+}
+
+func (r Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// LoadReport reads a report written by WriteJSON, ie to compare against as a baseline
+func LoadReport(path string) (Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Report{}, fmt.Errorf("error opening report: %w", err)
+	}
+	defer f.Close()
+
+	var r Report
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return Report{}, fmt.Errorf("error decoding report %v: %w", path, err)
+	}
+	return r, nil
+}
+
+type metricColumn struct {
+	name  string
+	value func(Metrics) *float64
+}
+
+func (r Report) metricColumns() []metricColumn {
+	return []metricColumn{
+		{fmt.Sprintf("Recall@%d", r.K), func(m Metrics) *float64 { return m.RecallAtK }},
+		{"MRR", func(m Metrics) *float64 { return m.MRR }},
+		{"Citation precision", func(m Metrics) *float64 { return m.CitationPrecision }},
+		{"Citation coverage", func(m Metrics) *float64 { return m.CitationCoverage }},
+		{"Answer similarity", func(m Metrics) *float64 { return m.AnswerSimilarity }},
+		{"Irrelevant citations", func(m Metrics) *float64 { return m.IrrelevantCitations }},
+	}
+}
+
+// WriteMarkdown writes a summary table and a row per case. With a baseline, the summary also shows how each metric
+// moved since it.
+func (r Report) WriteMarkdown(w io.Writer, baseline *Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Eval of %v\n\n", r.Dataset)
+	if r.Params != "" {
+		fmt.Fprintf(&b, "Searched with `%v`. ", r.Params)
+	}
+	fmt.Fprintf(&b, "%d cases, %d errors, %d tokens.\n\n", r.Summary.Cases, r.Summary.Errors, r.Summary.TotalTokens)
+
+	columns := r.metricColumns()
+	if baseline != nil {
+		b.WriteString("| Metric | Value | Baseline | Change |\n|---|---|---|---|\n")
+		for _, c := range columns {
+			current, previous := c.value(r.Summary.Metrics), c.value(baseline.Summary.Metrics)
+			fmt.Fprintf(&b, "| %v | %v | %v | %v |\n", c.name, formatScore(current), formatScore(previous), formatChange(current, previous))
+		}
+	} else {
+		b.WriteString("| Metric | Value |\n|---|---|\n")
+		for _, c := range columns {
+			fmt.Fprintf(&b, "| %v | %v |\n", c.name, formatScore(c.value(r.Summary.Metrics)))
+		}
+	}
+
+	b.WriteString("\n## Cases\n\n| ID | Query |")
+	for _, c := range columns {
+		fmt.Fprintf(&b, " %v |", c.name)
+	}
+	b.WriteString("\n|---|---|" + strings.Repeat("---|", len(columns)) + "\n")
+	for _, result := range r.Cases {
+		fmt.Fprintf(&b, "| %v | %v |", escapeCell(result.ID), escapeCell(result.Query))
+		if result.Error != "" {
+			fmt.Fprintf(&b, " error: %v |%v\n", escapeCell(result.Error), strings.Repeat(" |", len(columns)-1))
+			continue
+		}
+		for _, c := range columns {
+			fmt.Fprintf(&b, " %v |", formatScore(c.value(*result.Metrics)))
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatScore(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f", *v)
+}
+
+func formatChange(current, previous *float64) string {
+	if current == nil || previous == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.3f", *current-*previous)
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "|", `\|`), "\n", " ")
+}
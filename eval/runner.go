@@ -0,0 +1,125 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"raglib-demo/api"
+	"raglib-demo/fusion"
+	"raglib-demo/models"
+	"strings"
+)
+
+// DefaultK is how many retrieved documents recall is measured over
+const DefaultK = 5
+
+// Runner runs cases through a server's /search, so they go through the same retrieval, fusion, generation and chunk
+// processing as the API's searches
+type Runner struct {
+	handler http.Handler
+	corpora []string
+	params  url.Values
+	k       int
+}
+
+// NewRunner runs cases against handler. Cases that don't name their own corpora search corpora, and params are added
+// to every search, ie "fusion=rrf" or "model=groq/llama-3.3-70b-versatile", to compare runs.
+func NewRunner(handler http.Handler, corpora []string, params url.Values, k int) Runner {
+	return Runner{handler: handler, corpora: corpora, params: params, k: k}
+}
+
+// CaseResult is how one case went, Error is set instead of Metrics when its search failed
+type CaseResult struct {
+	ID      string   `json:"id"`
+	Query   string   `json:"query"`
+	Metrics *Metrics `json:"metrics,omitempty"`
+	// Retrieved are the links of the documents handed to the model, in rank order
+	Retrieved []string `json:"retrieved,omitempty"`
+	// Cited are the links of the cited documents, once per citation
+	Cited    []string         `json:"cited,omitempty"`
+	Answer   string           `json:"answer,omitempty"`
+	Metadata *models.Metadata `json:"metadata,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// Run searches for each case in turn. Cases run one at a time so they don't compete for provider rate limits, and a
+// failed case is recorded rather than stopping the run.
+func (r Runner) Run(ctx context.Context, cases []Case, progress func(done int, result CaseResult)) []CaseResult {
+	results := make([]CaseResult, 0, len(cases))
+	for i, c := range cases {
+		result := r.runCase(ctx, c)
+		results = append(results, result)
+		if progress != nil {
+			progress(i+1, result)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return results
+}
+
+func (r Runner) runCase(ctx context.Context, c Case) CaseResult {
+	result := CaseResult{ID: c.ID, Query: c.Query}
+
+	response, err := r.search(ctx, c)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, d := range response.Documents {
+		result.Retrieved = append(result.Retrieved, fusion.Key(d.Document))
+	}
+	for _, citation := range response.Citations {
+		if citation.DocumentIndex >= 0 && citation.DocumentIndex < len(result.Retrieved) {
+			result.Cited = append(result.Cited, result.Retrieved[citation.DocumentIndex])
+		}
+	}
+	result.Answer = response.Answer
+	result.Metadata = response.Metadata
+
+	annotated := response.Structured.Annotate()
+	result.Metrics = &Metrics{
+		RecallAtK:           RecallAtK(result.Retrieved, c.ExpectedURLs, r.k),
+		MRR:                 ReciprocalRank(result.Retrieved, c.ExpectedURLs),
+		CitationPrecision:   CitationPrecision(result.Cited, c.ExpectedURLs),
+		CitationCoverage:    CitationCoverage(annotated),
+		AnswerSimilarity:    AnswerSimilarity(response.Answer, c.ReferenceAnswer),
+		IrrelevantCitations: IrrelevantCitationRate(result.Cited, c.IrrelevantURLs),
+	}
+	return result
+}
+
+func (r Runner) search(ctx context.Context, c Case) (api.SearchResponse, error) {
+	query := url.Values{}
+	for key, values := range r.params {
+		query[key] = append([]string(nil), values...)
+	}
+	query.Set("q", c.Query)
+	query.Set("stream", "false")
+	corpora := c.Corpora
+	if len(corpora) == 0 {
+		corpora = r.corpora
+	}
+	query["corpus"] = corpora
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/search?"+query.Encode(), nil)
+	if err != nil {
+		return api.SearchResponse{}, fmt.Errorf("error building search request: %w", err)
+	}
+	recorder := httptest.NewRecorder()
+	r.handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		return api.SearchResponse{}, fmt.Errorf("search failed with status %v: %v", recorder.Code, strings.TrimSpace(recorder.Body.String()))
+	}
+	var response api.SearchResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		return api.SearchResponse{}, fmt.Errorf("error decoding search response: %w", err)
+	}
+	return response, nil
+}
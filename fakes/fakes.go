@@ -0,0 +1,127 @@
+package fakes
+
+import (
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	"raglib-demo/filter"
+	"raglib-demo/models"
+	"sync"
+	"time"
+)
+
+// Retriever returns Documents, or Err, for every query after waiting Delay. It records the queries it was sent, and
+// the filter each came with.
+type Retriever struct {
+	Documents []document.Document
+	Err       error
+	Delay     time.Duration
+
+	mu      sync.Mutex
+	queries []string
+	filters []filter.Filter
+}
+
+func (r *Retriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	r.mu.Lock()
+	r.queries = append(r.queries, query)
+	r.filters = append(r.filters, filter.FromContext(ctx))
+	r.mu.Unlock()
+
+	if err := wait(ctx, r.Delay); err != nil {
+		return nil, err
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	if uint64(len(r.Documents)) > topK {
+		return r.Documents[:topK], nil
+	}
+	return r.Documents, nil
+}
+
+func (r *Retriever) Queries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.queries...)
+}
+
+func (r *Retriever) Filters() []filter.Filter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]filter.Filter(nil), r.filters...)
+}
+
+// Generator streams Chunks, waiting Delay before each, then returns Usage and Err. Chunks can include citation
+// markup, ie "<cited>0</cited>", just like a model's output. It records the queries and settings it was asked with.
+type Generator struct {
+	Chunks []string
+	Usage  models.Usage
+	Err    error
+	Delay  time.Duration
+
+	mu       sync.Mutex
+	queries  []string
+	settings []models.Settings
+}
+
+func (g *Generator) Generate(ctx context.Context, query string, documents []document.Document, settings models.Settings, chunks chan<- string) (models.Usage, error) {
+	defer close(chunks)
+
+	g.mu.Lock()
+	g.queries = append(g.queries, query)
+	g.settings = append(g.settings, settings)
+	g.mu.Unlock()
+
+	for _, chunk := range g.Chunks {
+		if err := wait(ctx, g.Delay); err != nil {
+			return models.Usage{}, err
+		}
+		select {
+		case chunks <- chunk:
+		case <-ctx.Done():
+			return models.Usage{}, ctx.Err()
+		}
+	}
+	return g.Usage, g.Err
+}
+
+func (g *Generator) Queries() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.queries...)
+}
+
+func (g *Generator) Settings() []models.Settings {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]models.Settings(nil), g.settings...)
+}
+
+// Completer replies with Reply, or Err, to every prompt
+type Completer struct {
+	Reply string
+	Err   error
+}
+
+func (c Completer) Complete(ctx context.Context, system string, prompt string) (string, error) {
+	if c.Err != nil {
+		return "", c.Err
+	}
+	return c.Reply, nil
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter narrows retrieval to documents whose metadata matches, it's the query time counterpart to the metadata
+// recorded at ingestion. Only retrievers over ingested documents apply it, web search retrievers ignore it.
+type Filter struct {
+	// Tags must all be present on a document, they're matched case insensitively
+	Tags []string `json:"tags,omitempty"`
+	// SourcePrefix matches documents whose source starts with it at a path segment boundary, ie "notes/platform"
+	// matches "notes/platform/deploys.md" but not "notes/platform-old/deploys.md"
+	SourcePrefix string `json:"sourcePrefix,omitempty"`
+	Author       string `json:"author,omitempty"`
+	// After and Before bound the document's date, both are inclusive
+	After  *time.Time `json:"after,omitempty"`
+	Before *time.Time `json:"before,omitempty"`
+}
+
+// New normalizes and validates a filter built from client input
+func New(tags []string, sourcePrefix, author string, after, before *time.Time) (Filter, error) {
+	f := Filter{
+		Tags:         NormalizeTags(tags),
+		SourcePrefix: NormalizeSource(sourcePrefix),
+		Author:       strings.TrimSpace(author),
+		After:        after,
+		Before:       before,
+	}
+	if f.After != nil && f.Before != nil && f.After.After(*f.Before) {
+		return Filter{}, fmt.Errorf("'after', %v, is later than 'before', %v", f.After.Format(time.RFC3339), f.Before.Format(time.RFC3339))
+	}
+	return f, nil
+}
+
+func (f Filter) IsZero() bool {
+	return len(f.Tags) == 0 && f.SourcePrefix == "" && f.Author == "" && f.After == nil && f.Before == nil
+}
+
+// Key is a stable string identifying the filter, for use in cache keys
+func (f Filter) Key() string {
+	if f.IsZero() {
+		return ""
+	}
+
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return strings.Join([]string{
+		strings.Join(f.Tags, ","),
+		f.SourcePrefix,
+		f.Author,
+		formatTime(f.After),
+		formatTime(f.Before),
+	}, "|")
+}
+
+// NormalizeTags lowercases, trims, sorts and de-duplicates tags, dropping empty ones
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// NormalizeSource trims whitespace and trailing slashes so "notes/platform/" and "notes/platform" are the same prefix
+func NormalizeSource(source string) string {
+	return strings.TrimRight(strings.TrimSpace(source), "/")
+}
+
+// SourcePrefixes lists every path segment prefix of source, including source itself. Qdrant can only match keywords
+// exactly, so these are stored at ingestion for SourcePrefix to match against.
+func SourcePrefixes(source string) []string {
+	source = NormalizeSource(source)
+	if source == "" {
+		return nil
+	}
+
+	var prefixes []string
+	for i, r := range source {
+		// Skip empty prefixes, ie for absolute paths, and the middle of "//" in URLs
+		if r == '/' && i > 0 && source[i-1] != '/' {
+			prefixes = append(prefixes, source[:i])
+		}
+	}
+	return append(prefixes, source)
+}
+
+// ParseDate parses an absolute date, in RFC 3339 or YYYY-MM-DD form, or a relative one counting back from the start
+// of today, ie "30d", "2w", "6mo" or "1y". Relative dates are whole days so repeated queries share cache entries.
+func ParseDate(raw string, now time.Time) (*time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return &t, nil
+	}
+
+	for _, unit := range []struct {
+		suffix string
+		back   func(n int) time.Time
+	}{
+		{"mo", func(n int) time.Time { return today.AddDate(0, -n, 0) }},
+		{"d", func(n int) time.Time { return today.AddDate(0, 0, -n) }},
+		{"w", func(n int) time.Time { return today.AddDate(0, 0, -7*n) }},
+		{"y", func(n int) time.Time { return today.AddDate(-n, 0, 0) }},
+	} {
+		number, ok := strings.CutSuffix(raw, unit.suffix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(number); err == nil && n >= 0 {
+			t := unit.back(n)
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("date, %q, is invalid, expected RFC 3339, YYYY-MM-DD or a relative date like 30d, 2w, 6mo or 1y", raw)
+}
+
+type contextKey struct{}
+
+// WithContext attaches f to ctx, retrievers are shared between requests so this is how a query's filter reaches them
+func WithContext(ctx context.Context, f Filter) context.Context {
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// FromContext returns the filter attached to ctx, the zero Filter if there isn't one
+func FromContext(ctx context.Context) Filter {
+	f, _ := ctx.Value(contextKey{}).(Filter)
+	return f
+}
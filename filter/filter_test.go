@@ -0,0 +1,122 @@
+package filter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSourcePrefixes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected []string
+	}{
+		{
+			name:     "Relative path",
+			source:   "notes/platform/deploys.md",
+			expected: []string{"notes", "notes/platform", "notes/platform/deploys.md"},
+		},
+		{
+			name:     "Absolute path with trailing slash",
+			source:   "/home/notes/",
+			expected: []string{"/home", "/home/notes"},
+		},
+		{
+			name:     "URL",
+			source:   "https://wiki.example.com/platform/runbook",
+			expected: []string{"https:", "https://wiki.example.com", "https://wiki.example.com/platform", "https://wiki.example.com/platform/runbook"},
+		},
+		{
+			name:     "No separators",
+			source:   "scratch.txt",
+			expected: []string{"scratch.txt"},
+		},
+		{
+			name:     "Empty",
+			source:   "  ",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if prefixes := SourcePrefixes(tc.source); !reflect.DeepEqual(prefixes, tc.expected) {
+				t.Errorf("Unexpected prefixes. Got: %q, Expected: %q", prefixes, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	now := time.Date(2024, time.August, 15, 13, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		raw      string
+		expected time.Time
+	}{
+		{raw: "2024-06-01", expected: time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{raw: "2024-06-01T12:00:00Z", expected: time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)},
+		{raw: "30d", expected: time.Date(2024, time.July, 16, 0, 0, 0, 0, time.UTC)},
+		{raw: "2w", expected: time.Date(2024, time.August, 1, 0, 0, 0, 0, time.UTC)},
+		{raw: "6mo", expected: time.Date(2024, time.February, 15, 0, 0, 0, 0, time.UTC)},
+		{raw: "1y", expected: time.Date(2023, time.August, 15, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.raw, func(t *testing.T) {
+			date, err := ParseDate(tc.raw, now)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !date.Equal(tc.expected) {
+				t.Errorf("Unexpected date. Got: %v, Expected: %v", date, tc.expected)
+			}
+		})
+	}
+
+	if date, err := ParseDate("", now); date != nil || err != nil {
+		t.Errorf("Expected no date for empty input. Got: %v, %v", date, err)
+	}
+	for _, raw := range []string{"yesterday", "6m", "-3d", "d"} {
+		if _, err := ParseDate(raw, now); err == nil {
+			t.Errorf("Expected %q to be invalid", raw)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	f, err := New([]string{" Platform", "deploys", "platform", ""}, "notes/platform/", " Ana ", nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Filter{Tags: []string{"deploys", "platform"}, SourcePrefix: "notes/platform", Author: "Ana"}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("Unexpected filter. Got: %+v, Expected: %+v", f, expected)
+	}
+
+	after := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	before := after.AddDate(0, 0, -1)
+	if _, err := New(nil, "", "", &after, &before); err == nil {
+		t.Errorf("Expected 'after' later than 'before' to be invalid")
+	}
+}
+
+func TestKeyAndContext(t *testing.T) {
+	if key := FromContext(context.Background()).Key(); key != "" {
+		t.Errorf("Expected no filter to have an empty key. Got: %q", key)
+	}
+
+	after := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	tagged := Filter{Tags: []string{"platform"}}
+	dated := Filter{Tags: []string{"platform"}, After: &after}
+
+	ctx := WithContext(context.Background(), dated)
+	if key := FromContext(ctx).Key(); key != dated.Key() {
+		t.Errorf("Unexpected key from context. Got: %q, Expected: %q", key, dated.Key())
+	}
+	if tagged.Key() == dated.Key() {
+		t.Errorf("Expected filters with different dates to have different keys")
+	}
+}
@@ -0,0 +1,163 @@
+package fixtures
+
+import (
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"log/slog"
+	"raglib-demo/filter"
+	"raglib-demo/llm"
+	"raglib-demo/models"
+)
+
+type retrievalRequest struct {
+	// Identity tells apart retrievers with the same source, ie two qdrant retrievers over different collections
+	Identity string        `json:"identity"`
+	Query    string        `json:"query"`
+	TopK     uint64        `json:"topK"`
+	Filter   filter.Filter `json:"filter"`
+}
+
+// Retriever records or replays, depending on its mode, another retriever's results. When replaying it never calls the wrapped retriever,
+// which can be nil.
+type Retriever struct {
+	retriever retrieval.Retriever
+	store     *Store
+	mode      string
+	source    string
+	identity  string
+}
+
+func NewRetriever(retriever retrieval.Retriever, store *Store, mode, source, identity string) Retriever {
+	return Retriever{retriever: retriever, store: store, mode: mode, source: source, identity: identity}
+}
+
+func (r Retriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	request := retrievalRequest{Identity: r.identity, Query: query, TopK: topK, Filter: filter.FromContext(ctx)}
+
+	if r.mode == ModeReplay {
+		var docs []document.Document
+		recorded, err := r.store.Load(KindRetrieval, r.source, request, &docs)
+		if err != nil {
+			return nil, err
+		}
+		return docs, recorded
+	}
+
+	docs, err := r.retriever.Query(ctx, query, topK)
+	if saveErr := r.store.Save(KindRetrieval, r.source, request, docs, err); saveErr != nil {
+		slog.Error("failed to record retrieval fixture", "source", r.source, "err", saveErr)
+	}
+	return docs, err
+}
+
+// generator is the api.Generator interface, it's repeated here because api builds these wrappers
+type generator interface {
+	Generate(ctx context.Context, query string, documents []document.Document, settings models.Settings, chunks chan<- string) (models.Usage, error)
+}
+
+type generationRequest struct {
+	Query     string              `json:"query"`
+	Documents []document.Document `json:"documents"`
+	Settings  models.Settings     `json:"settings"`
+}
+
+type generationResponse struct {
+	// Chunks are kept as they were streamed, so replaying splits citations and code fences the same way
+	Chunks []string     `json:"chunks"`
+	Usage  models.Usage `json:"usage"`
+}
+
+// Generator records or replays another generator's answers
+type Generator struct {
+	generator generator
+	store     *Store
+	mode      string
+}
+
+func NewGenerator(g generator, store *Store, mode string) Generator {
+	return Generator{generator: g, store: store, mode: mode}
+}
+
+func (g Generator) Generate(ctx context.Context, query string, documents []document.Document, settings models.Settings, chunks chan<- string) (models.Usage, error) {
+	request := generationRequest{Query: query, Documents: documents, Settings: settings}
+	source := settings.Model.String()
+
+	if g.mode == ModeReplay {
+		defer close(chunks)
+		var response generationResponse
+		recorded, err := g.store.Load(KindGeneration, source, request, &response)
+		if err != nil {
+			return models.Usage{}, err
+		}
+		for _, chunk := range response.Chunks {
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return models.Usage{}, ctx.Err()
+			}
+		}
+		return response.Usage, recorded
+	}
+
+	// Chunks are passed through as they arrive so recording doesn't hold up the stream
+	defer close(chunks)
+	generated := make(chan string, 1)
+	var response generationResponse
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for chunk := range generated {
+			response.Chunks = append(response.Chunks, chunk)
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	usage, err := g.generator.Generate(ctx, query, documents, settings, generated)
+	<-done
+	response.Usage = usage
+	if saveErr := g.store.Save(KindGeneration, source, request, response, err); saveErr != nil {
+		slog.Error("failed to record generation fixture", "model", source, "err", saveErr)
+	}
+	return usage, err
+}
+
+type completionRequest struct {
+	System string `json:"system"`
+	Prompt string `json:"prompt"`
+}
+
+// Completer records or replays another completer's replies
+type Completer struct {
+	completer llm.Completer
+	store     *Store
+	mode      string
+	// source names the model in fixture file names
+	source string
+}
+
+func NewCompleter(completer llm.Completer, store *Store, mode, source string) Completer {
+	return Completer{completer: completer, store: store, mode: mode, source: source}
+}
+
+func (c Completer) Complete(ctx context.Context, system string, prompt string) (string, error) {
+	request := completionRequest{System: system, Prompt: prompt}
+
+	if c.mode == ModeReplay {
+		var reply string
+		recorded, err := c.store.Load(KindCompletion, c.source, request, &reply)
+		if err != nil {
+			return "", err
+		}
+		return reply, recorded
+	}
+
+	reply, err := c.completer.Complete(ctx, system, prompt)
+	if saveErr := c.store.Save(KindCompletion, c.source, request, reply, err); saveErr != nil {
+		slog.Error("failed to record completion fixture", "model", c.source, "err", saveErr)
+	}
+	return reply, err
+}
@@ -0,0 +1,143 @@
+package fixtures
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Modes a server can run in. Recording saves every external call, to web search, Qdrant and models, and replaying
+// serves the saved responses back instead of making the calls.
+const (
+	ModeOff    = "off"
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// ParseMode validates a fixture mode, an empty mode is ModeOff
+func ParseMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return ModeOff, nil
+	case ModeOff, ModeRecord, ModeReplay:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("fixture mode, %q, must be one of %v, %v or %v", mode, ModeOff, ModeRecord, ModeReplay)
+	}
+}
+
+// Kinds of calls fixtures are saved for
+const (
+	KindRetrieval  = "retrieval"
+	KindGeneration = "generation"
+	KindCompletion = "completion"
+)
+
+// ErrNotRecorded is returned when replaying a call that has no fixture
+var ErrNotRecorded = errors.New("no fixture recorded for this call")
+
+// Fixture is a single recorded call, saved as its own JSON file so fixtures can be read, edited and attached to bug
+// reports
+type Fixture struct {
+	Kind string `json:"kind"`
+	// Source is the retriever or model the call went to
+	Source   string          `json:"source"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	// Error is set when the call failed, replaying it fails the same way
+	Error string `json:"error,omitempty"`
+}
+
+// Store reads and writes fixtures in a directory. Each call's file is named after its kind, source and a hash of its
+// request, so replaying the same request finds it.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("a fixture directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating fixture directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save records a call. response is ignored when callErr is set.
+func (s *Store) Save(kind, source string, request any, response any, callErr error) error {
+	path, rawRequest, err := s.path(kind, source, request)
+	if err != nil {
+		return err
+	}
+
+	f := Fixture{Kind: kind, Source: source, Request: rawRequest}
+	if callErr != nil {
+		f.Error = callErr.Error()
+	} else if f.Response, err = json.Marshal(response); err != nil {
+		return fmt.Errorf("error encoding %v response: %w", kind, err)
+	}
+
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding fixture: %w", err)
+	}
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		return fmt.Errorf("error writing fixture: %w", err)
+	}
+	return nil
+}
+
+// Load finds the recorded call for request and decodes its response into response. The recorded error, if the call
+// failed, is returned as the second value, ErrNotRecorded as the third if there's no fixture for it.
+func (s *Store) Load(kind, source string, request any, response any) (recorded error, err error) {
+	path, _, err := s.path(kind, source, request)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w, %v from %v, expected %v", ErrNotRecorded, kind, source, filepath.Base(path))
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading fixture: %w", err)
+	}
+
+	var f Fixture
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("error decoding fixture %v: %w", filepath.Base(path), err)
+	}
+	if f.Error != "" {
+		return errors.New(f.Error), nil
+	}
+	if err := json.Unmarshal(f.Response, response); err != nil {
+		return nil, fmt.Errorf("error decoding %v response in %v: %w", kind, filepath.Base(path), err)
+	}
+	return nil, nil
+}
+
+func (s *Store) path(kind, source string, request any) (string, json.RawMessage, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return "", nil, fmt.Errorf("error encoding %v request: %w", kind, err)
+	}
+	hash := sha256.Sum256(append([]byte(kind+"\x00"+source+"\x00"), raw...))
+	name := fmt.Sprintf("%v-%v-%v.json", kind, fileSafe(source), hex.EncodeToString(hash[:8]))
+	return filepath.Join(s.dir, name), raw, nil
+}
+
+// fileSafe replaces anything but letters, digits, "-" and "." so model names like "meta-llama/llama-4" can be used in
+// file names
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,123 @@
+package fixtures
+
+import (
+	"context"
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
+	"os"
+	"raglib-demo/fakes"
+	"raglib-demo/filter"
+	"raglib-demo/models"
+	"reflect"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	for mode, expected := range map[string]string{"": ModeOff, "off": ModeOff, "record": ModeRecord, "replay": ModeReplay} {
+		if got, err := ParseMode(mode); err != nil || got != expected {
+			t.Errorf("Got: %v, %v, Expected: %v", got, err, expected)
+		}
+	}
+	if _, err := ParseMode("rewind"); err == nil {
+		t.Errorf("Expected an unknown mode to be rejected")
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save(KindCompletion, "openai/gpt-4o-mini", "ok", "reply", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(KindCompletion, "openai/gpt-4o-mini", "failing", nil, errors.New("rate limited")); err != nil {
+		t.Fatal(err)
+	}
+
+	var reply string
+	if recorded, err := store.Load(KindCompletion, "openai/gpt-4o-mini", "ok", &reply); err != nil || recorded != nil || reply != "reply" {
+		t.Errorf("Got: %q, %v, %v, Expected: the recorded reply", reply, recorded, err)
+	}
+	if recorded, err := store.Load(KindCompletion, "openai/gpt-4o-mini", "failing", &reply); err != nil || recorded == nil || recorded.Error() != "rate limited" {
+		t.Errorf("Got: %v, %v, Expected: the recorded error", recorded, err)
+	}
+	if _, err := store.Load(KindCompletion, "openai/gpt-4o", "ok", &reply); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Got: %v, Expected: %v for another model", err, ErrNotRecorded)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name()[:len("completion-openai_gpt-4o-mini-")] != "completion-openai_gpt-4o-mini-" {
+		t.Errorf("Got: %v, Expected: 2 fixtures named after their kind and source", entries)
+	}
+}
+
+func TestRetrieverRecordAndReplay(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Deploys go out on Tuesdays."}}}}
+	inner := &fakes.Retriever{Documents: documents}
+	ctx := filter.WithContext(context.Background(), filter.Filter{Tags: []string{"platform"}})
+
+	recorder := NewRetriever(inner, store, ModeRecord, "wiki", "scripted:wiki")
+	if _, err := recorder.Query(ctx, "deploys", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer := NewRetriever(nil, store, ModeReplay, "wiki", "scripted:wiki")
+	got, err := replayer.Query(ctx, "deploys", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, documents) {
+		t.Errorf("Got: %+v, Expected: %+v", got, documents)
+	}
+
+	// The filter is part of the request, so an unfiltered query wasn't recorded
+	if _, err := replayer.Query(context.Background(), "deploys", 5); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Got: %v, Expected: %v", err, ErrNotRecorded)
+	}
+}
+
+func TestGeneratorRecordAndReplay(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := models.Settings{Model: models.Model{Provider: models.ProviderOpenAI, Name: "gpt-4o"}, Style: models.StyleConcise}
+	inner := &fakes.Generator{Chunks: []string{"On Tues", "days <cited>0</cited>."}, Usage: models.Usage{TotalTokens: 42}}
+
+	generate := func(g Generator) ([]string, models.Usage, error) {
+		chunks := make(chan string)
+		var got []string
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for chunk := range chunks {
+				got = append(got, chunk)
+			}
+		}()
+		usage, err := g.Generate(context.Background(), "deploys", nil, settings, chunks)
+		<-done
+		return got, usage, err
+	}
+
+	recorded, _, err := generate(NewGenerator(inner, store, ModeRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, usage, err := generate(NewGenerator(nil, store, ModeReplay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) || !reflect.DeepEqual(replayed, inner.Chunks) || usage.TotalTokens != 42 {
+		t.Errorf("Got: %v, %+v, Expected: %v, %v tokens", replayed, usage, inner.Chunks, 42)
+	}
+}
@@ -0,0 +1,107 @@
+package fusion
+
+import (
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RankedList is the ordered output of a single retriever
+type RankedList struct {
+	// Source names the retriever that produced the list, ie "serp", "exa" or "qdrant"
+	Source    string
+	Documents []document.Document
+}
+
+// Strategy merges the ranked lists from several retrievers into a single list of at most limit documents
+type Strategy interface {
+	Fuse(lists []RankedList, limit int) []document.Document
+}
+
+const (
+	NameReciprocalRank   = "rrf"
+	NameRoundRobin       = "roundrobin"
+	NameSERPCoveredByExa = "serpexa"
+)
+
+// DefaultName is the strategy used when a request doesn't ask for one, it was the only merge before strategies
+// were configurable
+const DefaultName = NameSERPCoveredByExa
+
+// New builds the strategy with the given name. Weights are keyed by RankedList.Source, sources without a weight
+// get a weight of 1 and sources weighted 0 are left out.
+func New(name string, weights map[string]float64) (Strategy, error) {
+	switch name {
+	case NameReciprocalRank:
+		return ReciprocalRank{K: DefaultRRFK, Weights: weights}, nil
+	case NameRoundRobin:
+		return WeightedRoundRobin{Weights: weights}, nil
+	case NameSERPCoveredByExa, "":
+		return SERPCoveredByExa{}, nil
+	default:
+		return nil, fmt.Errorf("fusion strategy, %v, is invalid", name)
+	}
+}
+
+// ParseWeights parses weights of the form "exa:2,serp:1"
+func ParseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if strings.TrimSpace(s) == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		source, rawWeight, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("weight, %v, must be of the form source:weight", pair)
+		}
+		weight, err := strconv.ParseFloat(rawWeight, 64)
+		if err != nil {
+			return nil, fmt.Errorf("weight for %v must be a number", source)
+		}
+		weights[source] = weight
+	}
+
+	if err := ValidateWeights(weights); err != nil {
+		return nil, err
+	}
+	return weights, nil
+}
+
+// MinWeight is the smallest non-zero weight, smaller weights would take a round-robin thousands of rounds to take a
+// single document from their source
+const MinWeight = 0.01
+
+// ValidateWeights checks every weight is a finite number that's either 0 or at least MinWeight
+func ValidateWeights(weights map[string]float64) error {
+	for source, weight := range weights {
+		if math.IsNaN(weight) || math.IsInf(weight, 0) || weight < 0 || (weight > 0 && weight < MinWeight) {
+			return fmt.Errorf("weight for %v must be 0 or a number of at least %v", source, MinWeight)
+		}
+	}
+	return nil
+}
+
+// Key identifies a document across retrievers so duplicates can be merged. Web documents are keyed by link, other
+// documents by their text.
+func Key(d document.Document) string {
+	if d.WebReference != nil && d.WebReference.Link != "" {
+		return d.WebReference.Link
+	}
+
+	var sb strings.Builder
+	sb.WriteString("text:")
+	for _, p := range d.Passages {
+		sb.WriteString(p.Text)
+	}
+	return sb.String()
+}
+
+func weightFor(weights map[string]float64, source string) float64 {
+	if w, ok := weights[source]; ok {
+		return w
+	}
+	return 1
+}
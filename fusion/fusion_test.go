@@ -0,0 +1,154 @@
+package fusion
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"reflect"
+	"testing"
+)
+
+func webDoc(link, apiSource string) document.Document {
+	return document.Document{WebReference: &document.WebReference{Link: link, APISource: apiSource}}
+}
+
+func textDoc(text string) document.Document {
+	return document.Document{Passages: []document.Passage{{Text: text}}}
+}
+
+func TestFuse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy Strategy
+		lists    []RankedList
+		limit    int
+		expected []string
+	}{
+		{
+			name:     "SERP covered by Exa prefers SERP order then back-fills with Exa",
+			strategy: SERPCoveredByExa{},
+			lists: []RankedList{
+				{Source: SourceSERP, Documents: []document.Document{webDoc("c", "serp"), webDoc("x", "serp"), webDoc("a", "serp")}},
+				{Source: SourceExa, Documents: []document.Document{webDoc("a", "exa"), webDoc("b", "exa"), webDoc("c", "exa")}},
+			},
+			limit:    3,
+			expected: []string{"c", "a", "b"},
+		},
+		{
+			name:     "SERP covered by Exa falls back when SERP is empty",
+			strategy: SERPCoveredByExa{},
+			lists: []RankedList{
+				{Source: SourceSERP},
+				{Source: SourceExa, Documents: []document.Document{webDoc("a", "exa"), webDoc("b", "exa")}},
+			},
+			limit:    6,
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "Reciprocal rank boosts documents found by several sources",
+			strategy: ReciprocalRank{K: DefaultRRFK},
+			lists: []RankedList{
+				{Source: "one", Documents: []document.Document{webDoc("a", ""), webDoc("b", "")}},
+				{Source: "two", Documents: []document.Document{webDoc("c", ""), webDoc("b", "")}},
+			},
+			limit:    3,
+			expected: []string{"b", "a", "c"},
+		},
+		{
+			name:     "Reciprocal rank respects weights",
+			strategy: ReciprocalRank{K: DefaultRRFK, Weights: map[string]float64{"two": 3}},
+			lists: []RankedList{
+				{Source: "one", Documents: []document.Document{webDoc("a", "")}},
+				{Source: "two", Documents: []document.Document{webDoc("b", "")}},
+			},
+			limit:    2,
+			expected: []string{"b", "a"},
+		},
+		{
+			name:     "Reciprocal rank leaves out sources weighted 0",
+			strategy: ReciprocalRank{K: DefaultRRFK, Weights: map[string]float64{"two": 0}},
+			lists: []RankedList{
+				{Source: "one", Documents: []document.Document{webDoc("a", ""), webDoc("b", "")}},
+				{Source: "two", Documents: []document.Document{webDoc("b", ""), webDoc("c", "")}},
+			},
+			limit:    3,
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "Round robin interleaves by weight and skips duplicates",
+			strategy: WeightedRoundRobin{Weights: map[string]float64{"personal": 2}},
+			lists: []RankedList{
+				{Source: "personal", Documents: []document.Document{textDoc("p1"), textDoc("p2"), textDoc("p3")}},
+				{Source: "web", Documents: []document.Document{webDoc("a", ""), webDoc("b", "")}},
+			},
+			limit:    5,
+			expected: []string{"text:p1", "text:p2", "a", "text:p3", "b"},
+		},
+		{
+			name:     "Round robin with a tiny weight finishes",
+			strategy: WeightedRoundRobin{Weights: map[string]float64{"serp": 1e-300}},
+			lists: []RankedList{
+				{Source: "serp", Documents: []document.Document{webDoc("s1", ""), webDoc("s2", "")}},
+				{Source: "exa", Documents: []document.Document{webDoc("e1", "")}},
+			},
+			limit:    3,
+			expected: []string{"e1", "s1", "s2"},
+		},
+		{
+			name:     "Round robin with fractional weights",
+			strategy: WeightedRoundRobin{Weights: map[string]float64{"serp": 0.25}},
+			lists: []RankedList{
+				{Source: "serp", Documents: []document.Document{webDoc("s1", ""), webDoc("s2", "")}},
+				{Source: "exa", Documents: []document.Document{webDoc("e1", ""), webDoc("e2", ""), webDoc("e3", ""), webDoc("e4", ""), webDoc("e5", "")}},
+			},
+			limit:    6,
+			expected: []string{"e1", "e2", "e3", "s1", "e4", "e5"},
+		},
+		{
+			name:     "Round robin with no documents",
+			strategy: WeightedRoundRobin{},
+			lists:    []RankedList{{Source: "web"}},
+			limit:    6,
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fused := tc.strategy.Fuse(tc.lists, tc.limit)
+			if len(fused) != len(tc.expected) {
+				t.Fatalf("Unexpected number of documents. Got: %d, Expected: %d", len(fused), len(tc.expected))
+			}
+			for i, d := range fused {
+				if Key(d) != tc.expected[i] {
+					t.Errorf("Document [%d]; Got: %v, Expected: %v", i, Key(d), tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	weights, err := ParseWeights("exa:2, serp:0,qdrant:0.5")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := map[string]float64{"exa": 2, "serp": 0, "qdrant": 0.5}; !reflect.DeepEqual(weights, expected) {
+		t.Errorf("Got: %v, Expected: %v", weights, expected)
+	}
+
+	for _, invalid := range []string{"exa", "exa:two", "exa:-1", "exa:NaN", "exa:Inf", "exa:1e-300"} {
+		if _, err := ParseWeights(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestSERPCoveredByExaReturnsExaDocuments(t *testing.T) {
+	fused := SERPCoveredByExa{}.Fuse([]RankedList{
+		{Source: SourceSERP, Documents: []document.Document{webDoc("a", "serp")}},
+		{Source: SourceExa, Documents: []document.Document{webDoc("a", "exa")}},
+	}, 6)
+
+	if len(fused) != 1 || fused[0].WebReference.APISource != "exa" {
+		t.Fatalf("Expected the Exa copy of the document, got: %+v", fused)
+	}
+}
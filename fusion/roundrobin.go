@@ -0,0 +1,90 @@
+package fusion
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"math"
+)
+
+// WeightedRoundRobin takes documents from each list in turn, a list with weight 2 contributes two documents per
+// round to every one from a list with weight 1. Fractional weights accumulate across rounds.
+type WeightedRoundRobin struct {
+	Weights map[string]float64
+}
+
+func (wrr WeightedRoundRobin) Fuse(lists []RankedList, limit int) []document.Document {
+	var (
+		ret     = make([]document.Document, 0, limit)
+		seen    = make(map[string]struct{})
+		next    = make([]int, len(lists))
+		credits = make([]float64, len(lists))
+	)
+
+	for len(ret) < limit {
+		progressed := false
+
+		for i, list := range lists {
+			credits[i] += weightFor(wrr.Weights, list.Source)
+
+			for credits[i] >= 1 && next[i] < len(list.Documents) && len(ret) < limit {
+				d := list.Documents[next[i]]
+				next[i]++
+
+				key := Key(d)
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				ret = append(ret, d)
+				credits[i]--
+				progressed = true
+			}
+		}
+
+		if !progressed {
+			if exhausted(lists, next, wrr.Weights) {
+				break
+			}
+			// No list had a whole document's worth of credit, skip to the round where one does rather than looping
+			// through every round in between, which for tiny weights is practically forever
+			skipRounds(lists, next, credits, wrr.Weights)
+		}
+	}
+
+	return ret
+}
+
+// skipRounds adds the credit of however many rounds it takes for a list with documents left to reach a whole
+// document's worth
+func skipRounds(lists []RankedList, next []int, credits []float64, weights map[string]float64) {
+	rounds, first := math.Inf(1), -1
+	for i, list := range lists {
+		w := weightFor(weights, list.Source)
+		if next[i] >= len(list.Documents) || w <= 0 {
+			continue
+		}
+		if r := (1 - credits[i]) / w; r < rounds {
+			rounds, first = r, i
+		}
+	}
+	if first < 0 {
+		return
+	}
+
+	for i, list := range lists {
+		if w := weightFor(weights, list.Source); w > 0 {
+			credits[i] += rounds * w
+		}
+	}
+	// Rounding can leave it just short, which would skip forever
+	credits[first] = max(credits[first], 1)
+}
+
+// exhausted reports whether no list with a positive weight has documents left
+func exhausted(lists []RankedList, next []int, weights map[string]float64) bool {
+	for i, list := range lists {
+		if next[i] < len(list.Documents) && weightFor(weights, list.Source) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package fusion
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"sort"
+)
+
+// DefaultRRFK is the constant from the original reciprocal rank fusion paper, it dampens the advantage of the very
+// top ranks
+const DefaultRRFK = 60
+
+// ReciprocalRank scores each document as the weighted sum of 1 / (K + rank) over every list it appears in. Lists
+// weighted 0 are left out, as they are by WeightedRoundRobin.
+type ReciprocalRank struct {
+	K       float64
+	Weights map[string]float64
+}
+
+func (rrf ReciprocalRank) Fuse(lists []RankedList, limit int) []document.Document {
+	type scored struct {
+		doc       document.Document
+		score     float64
+		firstSeen int
+	}
+
+	byKey := make(map[string]*scored)
+	seenCount := 0
+
+	for _, list := range lists {
+		weight := weightFor(rrf.Weights, list.Source)
+		if weight <= 0 {
+			continue
+		}
+		for rank, d := range list.Documents {
+			key := Key(d)
+			s, ok := byKey[key]
+			if !ok {
+				s = &scored{doc: d, firstSeen: seenCount}
+				byKey[key] = s
+				seenCount++
+			}
+			s.score += weight / (rrf.K + float64(rank+1))
+		}
+	}
+
+	all := make([]*scored, 0, len(byKey))
+	for _, s := range byKey {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].firstSeen < all[j].firstSeen
+	})
+
+	ret := make([]document.Document, 0, min(limit, len(all)))
+	for _, s := range all {
+		if len(ret) >= limit {
+			break
+		}
+		ret = append(ret, s.doc)
+	}
+	return ret
+}
@@ -0,0 +1,117 @@
+package fusion
+
+import (
+	"github.com/coopslarhette/raglib/lib/document"
+	"log/slog"
+)
+
+const (
+	SourceSERP = "serp"
+	SourceExa  = "exa"
+)
+
+// SERPCoveredByExa prefers SERP's ranking, which tends to be better, but Exa's documents, which have the full page
+// text. It takes SERP results that Exa also returned first and back-fills with the highest ranked Exa results.
+//
+// When either SERP or Exa has no results, or there are other sources in play, the lists are merged with reciprocal
+// rank fusion instead, treating the SERP/Exa merge as a single list.
+type SERPCoveredByExa struct{}
+
+func (SERPCoveredByExa) Fuse(lists []RankedList, limit int) []document.Document {
+	var (
+		serpDocs, exaDocs []document.Document
+		others            []RankedList
+	)
+	for _, list := range lists {
+		switch list.Source {
+		case SourceSERP:
+			serpDocs = append(serpDocs, list.Documents...)
+		case SourceExa:
+			exaDocs = append(exaDocs, list.Documents...)
+		default:
+			others = append(others, list)
+		}
+	}
+
+	if len(serpDocs) == 0 || len(exaDocs) == 0 {
+		return ReciprocalRank{K: DefaultRRFK}.Fuse(lists, limit)
+	}
+
+	web := mergeSERPAndExa(serpDocs, exaDocs, limit)
+	if len(others) == 0 {
+		return web
+	}
+
+	return ReciprocalRank{K: DefaultRRFK}.Fuse(append([]RankedList{{Source: "web", Documents: web}}, others...), limit)
+}
+
+func mergeSERPAndExa(serpDocs, exaDocs []document.Document, limit int) []document.Document {
+	exaDocsByKey := make(map[string]document.Document, len(exaDocs))
+	for _, d := range exaDocs {
+		exaDocsByKey[Key(d)] = d
+	}
+
+	seen := make(map[string]struct{})
+	ret := make([]document.Document, 0, limit)
+
+	// Take any docs ranked highly via SERP that we have full text coverage for first
+	for _, fromSerp := range serpDocs {
+		if len(ret) >= limit {
+			break
+		}
+		key := Key(fromSerp)
+		fromExa, exists := exaDocsByKey[key]
+		if !exists {
+			continue
+		}
+		if _, exists := seen[key]; exists {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		ret = append(ret, fromExa)
+	}
+
+	slog.Info("SERP / Exa response stats", "Number SERP results Exa has coverage for", len(ret), "Num SERP retrieved", len(serpDocs), "Num Exa retrieved", len(exaDocs))
+
+	// To back-fill the result, use highest ranked Exa results
+	for i := 0; len(ret) < limit && i < len(exaDocs); i++ {
+		fromExa := exaDocs[i]
+		key := Key(fromExa)
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+		ret = append(ret, fromExa)
+	}
+
+	return ret
+}
+
+// SERPExaOverlap is the fraction of SERP results that Exa also returned, ok is false unless both returned something
+func SERPExaOverlap(lists []RankedList) (ratio float64, ok bool) {
+	var serpDocs []document.Document
+	exaKeys := make(map[string]struct{})
+	for _, list := range lists {
+		switch list.Source {
+		case SourceSERP:
+			serpDocs = append(serpDocs, list.Documents...)
+		case SourceExa:
+			for _, d := range list.Documents {
+				exaKeys[Key(d)] = struct{}{}
+			}
+		}
+	}
+
+	if len(serpDocs) == 0 || len(exaKeys) == 0 {
+		return 0, false
+	}
+
+	covered := 0
+	for _, d := range serpDocs {
+		if _, exists := exaKeys[Key(d)]; exists {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(serpDocs)), true
+}
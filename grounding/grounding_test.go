@@ -0,0 +1,147 @@
+package grounding
+
+import (
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	"raglib-demo/api/sse"
+	"raglib-demo/fakes"
+	"testing"
+)
+
+func verify(v *Verifier, events ...sse.Event) []sse.Event {
+	in := make(chan sse.Event, len(events))
+	for _, e := range events {
+		in <- e
+	}
+	close(in)
+
+	out := make(chan sse.Event, len(events)+10)
+	v.Process(context.Background(), in, out)
+	v.Finish(context.Background(), out)
+	close(out)
+
+	var processed []sse.Event
+	for e := range out {
+		processed = append(processed, e)
+	}
+	return processed
+}
+
+func passageDocument(text string) document.Document {
+	return document.Document{Passages: []document.Passage{{Text: text}}}
+}
+
+func TestVerifierDropsOutOfRangeCitations(t *testing.T) {
+	documents := []document.Document{passageDocument("one"), passageDocument("two"), passageDocument("three")}
+	v := NewVerifier(documents, nil, MethodOff)
+
+	// Numbered from 1, only the citation past the last document can be told apart, the rest point where they point
+	processed := verify(v,
+		sse.NewTextEvent("a"),
+		sse.NewCitationEvent(1),
+		sse.NewCitationEvent(2),
+		sse.NewCitationEvent(3),
+		sse.NewCitationEvent(-1),
+	)
+
+	expectedTypes := []string{"text", "citation", "citation", WarningEventType, WarningEventType, GroundednessEventType}
+	if len(processed) != len(expectedTypes) {
+		t.Fatalf("Got: %v events, Expected: %v", len(processed), len(expectedTypes))
+	}
+	for i, e := range processed {
+		if e.EventType != expectedTypes[i] {
+			t.Errorf("Got: %v, Expected: %v", e.EventType, expectedTypes[i])
+		}
+	}
+
+	for i, expected := range []int{1, 2} {
+		if citation := processed[i+1].Data.(int); citation != expected {
+			t.Errorf("Got: %v, Expected: %v", citation, expected)
+		}
+	}
+	for i, expected := range []int{3, -1} {
+		warning := processed[i+3].Data.(Warning)
+		if warning.Citation != expected || warning.Reason != WarningReasonOutOfRange {
+			t.Errorf("Got: %+v, Expected: citation %v to be out of range", warning, expected)
+		}
+	}
+
+	report := processed[5].Data.(Report)
+	expected := Report{Method: MethodOff, Score: 1, Citations: 4, Dropped: 2}
+	if report != expected {
+		t.Errorf("Got: %+v, Expected: %+v", report, expected)
+	}
+}
+
+func TestVerifierFlagsUnsupportedCitations(t *testing.T) {
+	documents := []document.Document{
+		passageDocument("Goroutines are lightweight threads managed by the Go runtime."),
+		passageDocument("Rust uses ownership and borrowing to guarantee memory safety."),
+	}
+	v := NewVerifier(documents, LexicalJudge{}, MethodLexical)
+
+	processed := verify(v,
+		sse.NewTextEvent("Goroutines are lightweight threads managed by the runtime "),
+		sse.NewCitationEvent(0),
+		sse.NewTextEvent(". Goroutines are scheduled by the runtime "),
+		sse.NewCitationEvent(1),
+		sse.NewTextEvent("."),
+	)
+
+	var warnings []Warning
+	var report Report
+	for _, e := range processed {
+		switch data := e.Data.(type) {
+		case Warning:
+			warnings = append(warnings, data)
+		case Report:
+			report = data
+		}
+	}
+
+	if len(warnings) != 1 || warnings[0].Citation != 1 || warnings[0].Reason != WarningReasonUnsupported {
+		t.Fatalf("Got: %+v, Expected: a single unsupported warning for citation 1", warnings)
+	}
+	if warnings[0].Claim != "Goroutines are scheduled by the runtime" {
+		t.Errorf("Got: %q, Expected: %q", warnings[0].Claim, "Goroutines are scheduled by the runtime")
+	}
+	if report.Unsupported != 1 || report.Score >= 1 || report.Score <= 0 {
+		t.Errorf("Got: %+v, Expected: one unsupported citation and a partial score", report)
+	}
+}
+
+func TestLexicalJudge(t *testing.T) {
+	tests := []struct {
+		claim    string
+		passage  string
+		expected float64
+	}{
+		{"Goroutines are cheap", "goroutines are very cheap to start", 1},
+		{"Goroutines are cheap", "Threads are expensive", 0},
+		{"Channels and goroutines", "goroutines", 0.5},
+		{"It is", "anything", 1},
+	}
+
+	for _, tt := range tests {
+		got, _ := LexicalJudge{}.Support(context.Background(), tt.claim, tt.passage)
+		if got != tt.expected {
+			t.Errorf("Got: %v, Expected: %v, for claim %q", got, tt.expected, tt.claim)
+		}
+	}
+}
+
+func TestLLMJudge(t *testing.T) {
+	got, err := NewLLMJudge(fakes.Completer{Reply: " 1.4 "}).Support(context.Background(), "claim", "passage")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != 1 {
+		t.Errorf("Got: %v, Expected: %v", got, 1)
+	}
+
+	for _, reply := range []string{"supported", "NaN", "-Inf"} {
+		if _, err := NewLLMJudge(fakes.Completer{Reply: reply}).Support(context.Background(), "claim", "passage"); err == nil {
+			t.Errorf("Expected a reply of %q to be an error", reply)
+		}
+	}
+}
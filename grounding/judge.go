@@ -0,0 +1,103 @@
+package grounding
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"raglib-demo/llm"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Judge scores how well passage supports claim, from 0 (not at all) to 1 (fully)
+type Judge interface {
+	Support(ctx context.Context, claim string, passage string) (float64, error)
+}
+
+// LexicalJudge scores support as the fraction of the claim's content words that appear in the passage. It's crude,
+// paraphrases score low, but it's free and catches citations to the wrong document.
+type LexicalJudge struct{}
+
+func (LexicalJudge) Support(ctx context.Context, claim string, passage string) (float64, error) {
+	claimWords := contentWords(claim)
+	if len(claimWords) == 0 {
+		return 1, nil
+	}
+
+	passageWords := make(map[string]struct{})
+	for _, w := range contentWords(passage) {
+		passageWords[w] = struct{}{}
+	}
+
+	found := 0
+	for _, w := range claimWords {
+		if _, ok := passageWords[w]; ok {
+			found++
+		}
+	}
+	return float64(found) / float64(len(claimWords)), nil
+}
+
+var stopWords = map[string]struct{}{
+	"the": {}, "and": {}, "for": {}, "are": {}, "but": {}, "not": {}, "you": {}, "all": {}, "can": {}, "was": {},
+	"one": {}, "our": {}, "has": {}, "have": {}, "had": {}, "its": {}, "this": {}, "that": {}, "with": {}, "from": {},
+	"they": {}, "their": {}, "them": {}, "then": {}, "than": {}, "there": {}, "these": {}, "those": {}, "which": {},
+	"when": {}, "what": {}, "where": {}, "who": {}, "will": {}, "would": {}, "should": {}, "could": {}, "also": {},
+	"into": {}, "more": {}, "most": {}, "such": {}, "some": {}, "any": {}, "each": {}, "other": {}, "been": {},
+	"being": {}, "were": {}, "does": {}, "did": {}, "how": {}, "use": {}, "used": {}, "using": {}, "your": {},
+}
+
+// contentWords lowercases text and drops punctuation, stop words and very short words
+func contentWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	words := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if len(f) < 3 {
+			continue
+		}
+		if _, ok := stopWords[f]; ok {
+			continue
+		}
+		words = append(words, f)
+	}
+	return words
+}
+
+const judgeSystemPrompt = `You check whether a source passage supports a claim. Reply with a single number between 0
+and 1, where 1 means the passage fully supports the claim, 0.5 means it partially supports it and 0 means it doesn't
+support it or is unrelated. Reply with only the number.`
+
+// judgePassageLength caps how much of a document is sent to the judge
+const judgePassageLength = 6000
+
+// LLMJudge asks a model whether the passage supports the claim, it's slower and costs a call per citation but handles
+// paraphrasing
+type LLMJudge struct {
+	completer llm.Completer
+}
+
+func NewLLMJudge(completer llm.Completer) LLMJudge {
+	return LLMJudge{completer: completer}
+}
+
+func (j LLMJudge) Support(ctx context.Context, claim string, passage string) (float64, error) {
+	if len(passage) > judgePassageLength {
+		passage = strings.ToValidUTF8(passage[:judgePassageLength], "")
+	}
+
+	reply, err := j.completer.Complete(ctx, judgeSystemPrompt, fmt.Sprintf("Claim: %s\n\nPassage:\n%s", claim, passage))
+	if err != nil {
+		return 0, fmt.Errorf("error judging citation: %w", err)
+	}
+
+	// ParseFloat accepts NaN and Inf, which can't be encoded as JSON
+	score, err := strconv.ParseFloat(strings.TrimSpace(reply), 64)
+	if err != nil || math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, fmt.Errorf("judge replied with a non-numeric score, %q", reply)
+	}
+	return min(max(score, 0), 1), nil
+}
@@ -0,0 +1,208 @@
+package grounding
+
+import (
+	"context"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"log/slog"
+	"raglib-demo/api/sse"
+	"strings"
+)
+
+type WarningReason string
+
+const (
+	// WarningReasonOutOfRange citations referenced a document that doesn't exist and were dropped. They're not moved to
+	// another document, even one past the last that suggests the model counted from 1, as the answer's other
+	// citations have already been sent and can't be shifted to match.
+	WarningReasonOutOfRange WarningReason = "outofrange"
+	// WarningReasonUnsupported citations point at a document that doesn't appear to back up the sentence they follow
+	WarningReasonUnsupported WarningReason = "unsupported"
+)
+
+type Warning struct {
+	// Citation is the document index as the model wrote it
+	Citation int           `json:"citation"`
+	Reason   WarningReason `json:"reason"`
+	Score    *float64      `json:"score,omitempty"`
+	Claim    string        `json:"claim,omitempty"`
+}
+
+// Report summarises how well an answer's citations hold up
+type Report struct {
+	Method string `json:"method"`
+	// Score is the mean support score of the citations that were kept, 1 when there were none
+	Score       float64 `json:"score"`
+	Citations   int     `json:"citations"`
+	Dropped     int     `json:"dropped"`
+	Unsupported int     `json:"unsupported"`
+}
+
+const (
+	MethodOff     = "off"
+	MethodLexical = "lexical"
+	MethodLLM     = "llm"
+)
+
+// ParseMethod validates a support scoring method, defaulting to lexical scoring
+func ParseMethod(method string) (string, error) {
+	switch method {
+	case "":
+		return MethodLexical, nil
+	case MethodOff, MethodLexical, MethodLLM:
+		return method, nil
+	default:
+		return "", fmt.Errorf("grounding method, %v, is invalid, expected one of %v, %v or %v", method, MethodOff, MethodLexical, MethodLLM)
+	}
+}
+
+const (
+	WarningEventType      = "citationwarning"
+	GroundednessEventType = "groundedness"
+)
+
+// DefaultSupportThreshold is the support score below which a citation is flagged as unsupported
+const DefaultSupportThreshold = 0.3
+
+// Verifier checks the citations in a processed event stream against the documents the answer was generated from.
+// Out of range citations are dropped as they pass through, support scoring happens once the answer is complete. A
+// Verifier checks a single answer.
+type Verifier struct {
+	documents []document.Document
+	// judge is nil when support scoring is off
+	judge     Judge
+	method    string
+	threshold float64
+
+	report Report
+	kept   []keptCitation
+}
+
+func NewVerifier(documents []document.Document, judge Judge, method string) *Verifier {
+	return &Verifier{documents: documents, judge: judge, method: method, threshold: DefaultSupportThreshold}
+}
+
+type keptCitation struct {
+	written int
+	claim   string
+}
+
+// Process forwards events from in to out until in is closed, dropping out of range citations and sending warnings
+// for them as they're found. It returns false if ctx was cancelled first.
+func (v *Verifier) Process(ctx context.Context, in <-chan sse.Event, out chan<- sse.Event) bool {
+	// Keep draining in if we stop early so the producer isn't left blocked
+	defer func() {
+		for range in {
+		}
+	}()
+
+	v.report = Report{Method: v.method}
+	var sentence strings.Builder
+
+	for event := range in {
+		switch event.EventType {
+		case "text":
+			text, _ := event.Data.(string)
+			trackSentence(&sentence, text)
+		case "codeblock":
+			sentence.Reset()
+		case "citation":
+			written, _ := event.Data.(int)
+			v.report.Citations++
+
+			if written < 0 || written >= len(v.documents) {
+				v.report.Dropped++
+				if !send(ctx, out, sse.Event{EventType: WarningEventType, Data: Warning{Citation: written, Reason: WarningReasonOutOfRange}}) {
+					return false
+				}
+				continue
+			}
+			v.kept = append(v.kept, keptCitation{written: written, claim: strings.TrimSpace(sentence.String())})
+		}
+
+		if !send(ctx, out, event) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finish scores the support of every citation Process kept, sending warnings for unsupported ones and then the
+// groundedness report. It's only meaningful once the answer is complete.
+func (v *Verifier) Finish(ctx context.Context, out chan<- sse.Event) bool {
+	report := v.report
+	report.Score = 1
+
+	if v.judge != nil && len(v.kept) > 0 {
+		total := 0.0
+		for _, c := range v.kept {
+			score, err := v.judge.Support(ctx, c.claim, DocumentText(v.documents[c.written]))
+			if err != nil {
+				slog.Warn("failed to score citation support", "citation", c.written, "err", err)
+				// Unknown support shouldn't drag the answer's score down or up
+				score = 1
+			}
+			total += score
+
+			if score < v.threshold {
+				report.Unsupported++
+				score := score
+				if !send(ctx, out, sse.Event{EventType: WarningEventType, Data: Warning{Citation: c.written, Reason: WarningReasonUnsupported, Score: &score, Claim: c.claim}}) {
+					return false
+				}
+			}
+		}
+		report.Score = total / float64(len(v.kept))
+	}
+
+	return send(ctx, out, sse.Event{EventType: GroundednessEventType, Data: report})
+}
+
+func send(ctx context.Context, out chan<- sse.Event, e sse.Event) bool {
+	select {
+	case out <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// trackSentence keeps the text since the last sentence boundary, which is the claim the next citation supports.
+// Citations usually come before the full stop, ie "Go is fast <cited>1</cited>.", so a boundary only starts a new
+// sentence once text follows it.
+func trackSentence(sentence *strings.Builder, text string) {
+	current := sentence.String() + text
+
+	cut := -1
+	for _, boundary := range []string{". ", "? ", "! ", "\n"} {
+		if i := strings.LastIndex(current, boundary); i >= 0 && i+len(boundary) > cut {
+			cut = i + len(boundary)
+		}
+	}
+
+	sentence.Reset()
+	if cut >= 0 && strings.TrimSpace(current[cut:]) != "" {
+		sentence.WriteString(current[cut:])
+	} else if cut >= 0 {
+		sentence.WriteString(current[:cut])
+	} else {
+		sentence.WriteString(current)
+	}
+}
+
+// DocumentText is everything a judge can check a document against, its title and snippet for web results and its
+// passages
+func DocumentText(d document.Document) string {
+	var sb strings.Builder
+	if d.WebReference != nil {
+		sb.WriteString(d.WebReference.Title)
+		sb.WriteString("\n")
+		sb.WriteString(d.WebReference.Snippet)
+		sb.WriteString("\n")
+	}
+	for _, p := range d.Passages {
+		sb.WriteString(p.Text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
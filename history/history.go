@@ -0,0 +1,136 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"raglib-demo/answer"
+	"time"
+)
+
+// Stores searches can be recorded to
+const (
+	StoreOff    = "off"
+	StoreJSONL  = "jsonl"
+	StoreSQLite = "sqlite"
+)
+
+// ParseStore validates a history store, an empty store is StoreOff
+func ParseStore(store string) (string, error) {
+	switch store {
+	case "":
+		return StoreOff, nil
+	case StoreOff, StoreJSONL, StoreSQLite:
+		return store, nil
+	default:
+		return "", fmt.Errorf("history store, %q, must be one of %v, %v or %v", store, StoreOff, StoreJSONL, StoreSQLite)
+	}
+}
+
+var ErrNotFound = errors.New("search not found")
+
+// Ratings feedback can give a search
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+)
+
+func ParseRating(rating string) (string, error) {
+	switch rating {
+	case RatingUp, RatingDown:
+		return rating, nil
+	default:
+		return "", fmt.Errorf("rating, %q, must be %v or %v", rating, RatingUp, RatingDown)
+	}
+}
+
+// Feedback is what a user thought of a search's answer, newer feedback on a search replaces older
+type Feedback struct {
+	Rating  string `json:"rating"`
+	Comment string `json:"comment,omitempty"`
+	// IrrelevantCitations are indexes into the record's Citations whose source the user flagged as irrelevant
+	IrrelevantCitations []int     `json:"irrelevantCitations,omitempty"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+// Record is a single search, whether it was answered or failed
+type Record struct {
+	// ID is the search's ID, for streamed searches it's the session ID in the stream's event IDs
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Principal is the name of the API key the search was made with, it's empty when authentication is disabled
+	Principal string   `json:"principal,omitempty"`
+	Query     string   `json:"query"`
+	Corpora   []string `json:"corpora"`
+	// Documents are the documents the answer could cite, in rank order
+	Documents []document.Document `json:"documents"`
+	// Answer is the rendered answer text, Citations' offsets are byte offsets into it
+	Answer    string            `json:"answer,omitempty"`
+	Citations []answer.Citation `json:"citations,omitempty"`
+	Model     string            `json:"model"`
+	Cached    bool              `json:"cached,omitempty"`
+	// LatencyMs is how long the search took from the request to its last answer event
+	LatencyMs int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	Feedback  *Feedback `json:"feedback,omitempty"`
+}
+
+// IrrelevantDocuments are the documents cited by the citations its feedback flags as irrelevant, each once
+func (r Record) IrrelevantDocuments() []document.Document {
+	if r.Feedback == nil {
+		return nil
+	}
+	var documents []document.Document
+	seen := make(map[int]bool)
+	for _, i := range r.Feedback.IrrelevantCitations {
+		if i < 0 || i >= len(r.Citations) {
+			continue
+		}
+		index := r.Citations[i].DocumentIndex
+		if index < 0 || index >= len(r.Documents) || seen[index] {
+			continue
+		}
+		seen[index] = true
+		documents = append(documents, r.Documents[index])
+	}
+	return documents
+}
+
+// Query selects records to list, most recent first
+type Query struct {
+	// Principal only lists the searches made with this API key, all searches are listed when it's empty
+	Principal string
+	// Rating only lists searches whose feedback has this rating
+	Rating string
+	// Limit of 0 lists every matching record
+	Limit  int
+	Offset int
+}
+
+// Store records searches and the feedback they get. Implementations are safe for concurrent use.
+type Store interface {
+	Save(ctx context.Context, r Record) error
+	// SetFeedback attaches f to the search with id, ErrNotFound is returned if there isn't one
+	SetFeedback(ctx context.Context, id string, f Feedback) error
+	List(ctx context.Context, q Query) ([]Record, error)
+	Get(ctx context.Context, id string) (Record, error)
+	Close() error
+}
+
+// Open opens the store configured by store and path, the store is nil when history is off
+func Open(store, path string) (Store, error) {
+	store, err := ParseStore(store)
+	if err != nil {
+		return nil, err
+	}
+
+	switch store {
+	case StoreJSONL:
+		return NewJSONLStore(path)
+	case StoreSQLite:
+		return NewSQLiteStore(path)
+	default:
+		return nil, nil
+	}
+}
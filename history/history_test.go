@@ -0,0 +1,135 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"raglib-demo/answer"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(dir string) (Store, error){
+		StoreJSONL:  func(dir string) (Store, error) { return NewJSONLStore(filepath.Join(dir, "history.jsonl")) },
+		StoreSQLite: func(dir string) (Store, error) { return NewSQLiteStore(filepath.Join(dir, "history.db")) },
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			start := time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC)
+			records := []Record{
+				{ID: "a", CreatedAt: start, Principal: "alice", Query: "deploys", Corpora: []string{"personal"}, Answer: "On Tuesdays.", Citations: []answer.Citation{{DocumentIndex: 0, SpanEnd: 12}}},
+				{ID: "b", CreatedAt: start.Add(time.Minute), Principal: "bob", Query: "on-call", Error: "retrieval failed"},
+				{ID: "c", CreatedAt: start.Add(2 * time.Minute), Principal: "alice", Query: "rollbacks"},
+			}
+			for _, r := range records {
+				if err := store.Save(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, f := range []Feedback{{Rating: RatingUp}, {Rating: RatingDown, Comment: "Deploys moved to Wednesdays", IrrelevantCitations: []int{0}}} {
+				if err := store.SetFeedback(ctx, "a", f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.SetFeedback(ctx, "c", Feedback{Rating: RatingUp}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetFeedback(ctx, "missing", Feedback{Rating: RatingUp}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Got: %v, Expected: %v for feedback on a missing search", err, ErrNotFound)
+			}
+
+			tests := []struct {
+				name     string
+				query    Query
+				expected []string
+			}{
+				{"all", Query{Limit: 10}, []string{"c", "b", "a"}},
+				{"limited", Query{Limit: 2}, []string{"c", "b"}},
+				{"offset", Query{Limit: 10, Offset: 1}, []string{"b", "a"}},
+				{"principal", Query{Principal: "alice", Limit: 10}, []string{"c", "a"}},
+				{"principal with offset", Query{Principal: "alice", Limit: 10, Offset: 1}, []string{"a"}},
+				{"rated down", Query{Rating: RatingDown}, []string{"a"}},
+				{"rated up", Query{Rating: RatingUp, Limit: 10}, []string{"c"}},
+				{"unlimited", Query{}, []string{"c", "b", "a"}},
+			}
+			for _, tt := range tests {
+				listed, err := store.List(ctx, tt.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for _, r := range listed {
+					ids = append(ids, r.ID)
+				}
+				if strings.Join(ids, ",") != strings.Join(tt.expected, ",") {
+					t.Errorf("%v, Got: %v, Expected: %v", tt.name, ids, tt.expected)
+				}
+			}
+
+			got, err := store.Get(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Query != "deploys" || got.Answer != "On Tuesdays." || len(got.Citations) != 1 || !got.CreatedAt.Equal(start) {
+				t.Errorf("Got: %+v, Expected: %+v", got, records[0])
+			}
+			if got.Feedback == nil || got.Feedback.Rating != RatingDown || got.Feedback.Comment != "Deploys moved to Wednesdays" || len(got.Feedback.IrrelevantCitations) != 1 {
+				t.Errorf("Got: %+v, Expected: the latest feedback", got.Feedback)
+			}
+			if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Got: %v, Expected: %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestJSONLStoreSkipsUnreadableLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := NewJSONLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(context.Background(), Record{ID: "a", Query: "deploys"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A search cut short by a crash can leave half a line behind
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id": "b", "query": "on-c` + "\n")
+	f.Close()
+
+	listed, err := store.List(context.Background(), Query{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != "a" {
+		t.Errorf("Got: %+v, Expected: only the readable record", listed)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	store, err := Open("", "")
+	if err != nil || store != nil {
+		t.Errorf("Got: %v, %v, Expected: no store when history is off", store, err)
+	}
+	if _, err := Open("postgres", "history"); err == nil {
+		t.Errorf("Expected an unknown store to be rejected")
+	}
+	if _, err := Open(StoreJSONL, ""); err == nil {
+		t.Errorf("Expected a store without a path to be rejected")
+	}
+}
@@ -0,0 +1,186 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JSONLStore appends each record as a line of a JSON Lines file. Feedback is appended as its own line, naming the
+// search it's for, so the file is never rewritten. Listing and getting records reads the whole file, which is fine for
+// a personal log, use SQLiteStore for anything bigger.
+type JSONLStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// feedbackLine is how feedback is written to the file, lines without FeedbackFor are records
+type feedbackLine struct {
+	FeedbackFor string    `json:"feedbackFor"`
+	Feedback    *Feedback `json:"feedback"`
+}
+
+func NewJSONLStore(path string) (*JSONLStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("a history file path is required")
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("error creating history directory: %w", err)
+		}
+	}
+
+	// Fail now, rather than on the first search, if the file can't be written
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening history file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("error opening history file: %w", err)
+	}
+	return &JSONLStore{path: path}, nil
+}
+
+func (s *JSONLStore) Save(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendLine(r)
+}
+
+func (s *JSONLStore) SetFeedback(ctx context.Context, id string, f Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	if _, ok := find(records, id); !ok {
+		return ErrNotFound
+	}
+	return s.appendLine(feedbackLine{FeedbackFor: id, Feedback: &f})
+}
+
+func (s *JSONLStore) List(ctx context.Context, q Query) ([]Record, error) {
+	s.mu.Lock()
+	records, err := s.readAll()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Records are appended as searches finish, so the newest are at the end
+	var listed []Record
+	skipped := 0
+	for i := len(records) - 1; i >= 0 && (q.Limit <= 0 || len(listed) < q.Limit); i-- {
+		if q.Principal != "" && records[i].Principal != q.Principal {
+			continue
+		}
+		if q.Rating != "" && (records[i].Feedback == nil || records[i].Feedback.Rating != q.Rating) {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		listed = append(listed, records[i])
+	}
+	return listed, nil
+}
+
+func (s *JSONLStore) Get(ctx context.Context, id string) (Record, error) {
+	s.mu.Lock()
+	records, err := s.readAll()
+	s.mu.Unlock()
+	if err != nil {
+		return Record{}, err
+	}
+
+	if r, ok := find(records, id); ok {
+		return r, nil
+	}
+	return Record{}, ErrNotFound
+}
+
+func (s *JSONLStore) Close() error {
+	return nil
+}
+
+func find(records []Record, id string) (Record, bool) {
+	for _, r := range records {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// appendLine writes v as a line at the end of the file, s.mu must be held
+func (s *JSONLStore) appendLine(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding history line: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err := errors.Join(err, f.Close()); err != nil {
+		return fmt.Errorf("error writing history line: %w", err)
+	}
+	return nil
+}
+
+// readAll decodes every record in the file, with their latest feedback, skipping lines that can't be decoded, ie one
+// cut short by a crash. s.mu must be held.
+func (s *JSONLStore) readAll() ([]Record, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening history file: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	indexes := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	// Records carry their documents, so lines can be much longer than the default limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+
+		var feedback feedbackLine
+		if err := json.Unmarshal(raw, &feedback); err != nil {
+			slog.Warn("skipping unreadable history line", "path", s.path, "line", line, "err", err)
+			continue
+		}
+		if feedback.FeedbackFor != "" {
+			if i, ok := indexes[feedback.FeedbackFor]; ok {
+				records[i].Feedback = feedback.Feedback
+			}
+			continue
+		}
+
+		var r Record
+		if err := json.Unmarshal(raw, &r); err != nil {
+			slog.Warn("skipping unreadable history line", "path", s.path, "line", line, "err", err)
+			continue
+		}
+		indexes[r.ID] = len(records)
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history file: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,156 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"net/url"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS searches (
+	id         TEXT PRIMARY KEY,
+	created_at INTEGER NOT NULL,
+	principal  TEXT NOT NULL,
+	record     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS searches_by_principal ON searches (principal, created_at);
+CREATE INDEX IF NOT EXISTS searches_by_time ON searches (created_at);
+CREATE TABLE IF NOT EXISTS feedback (
+	search_id TEXT PRIMARY KEY REFERENCES searches (id),
+	rating    TEXT NOT NULL,
+	feedback  TEXT NOT NULL
+);
+`
+
+// SQLiteStore keeps records in a SQLite database. Each record, and its feedback, is stored as JSON alongside the
+// columns it's listed by.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("a history database path is required")
+	}
+
+	// WAL lets the history be read while a search is being recorded, and the busy timeout waits out the writes that
+	// do overlap
+	dsn := "file:" + path + "?" + url.Values{"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)"}}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating history tables: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Save(ctx context.Context, r Record) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error encoding search record: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO searches (id, created_at, principal, record) VALUES (?, ?, ?, ?)`,
+		r.ID, r.CreatedAt.UnixNano(), r.Principal, string(raw),
+	)
+	if err != nil {
+		return fmt.Errorf("error writing search record: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetFeedback(ctx context.Context, id string, f Feedback) error {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("error encoding feedback: %w", err)
+	}
+
+	// Only searches that were recorded can get feedback
+	result, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO feedback (search_id, rating, feedback) SELECT id, ?, ? FROM searches WHERE id = ?`,
+		f.Rating, string(raw), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error writing feedback: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error writing feedback: %w", err)
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const selectRecords = `SELECT searches.record, feedback.feedback FROM searches LEFT JOIN feedback ON feedback.search_id = searches.id`
+
+func (s *SQLiteStore) List(ctx context.Context, q Query) ([]Record, error) {
+	// SQLite treats a negative limit as no limit
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.QueryContext(ctx,
+		selectRecords+` WHERE (? = '' OR searches.principal = ?) AND (? = '' OR feedback.rating = ?) ORDER BY searches.created_at DESC LIMIT ? OFFSET ?`,
+		q.Principal, q.Principal, q.Rating, q.Rating, limit, q.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing search records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing search records: %w", err)
+	}
+	return records, nil
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id string) (Record, error) {
+	r, err := scanRecord(s.db.QueryRowContext(ctx, selectRecords+` WHERE searches.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, ErrNotFound
+	}
+	return r, err
+}
+
+// scanRecord decodes a row of selectRecords
+func scanRecord(row interface{ Scan(dest ...any) error }) (Record, error) {
+	var (
+		raw      string
+		feedback sql.NullString
+	)
+	if err := row.Scan(&raw, &feedback); err != nil {
+		return Record{}, fmt.Errorf("error reading search record: %w", err)
+	}
+
+	var r Record
+	if err := json.Unmarshal([]byte(raw), &r); err != nil {
+		return Record{}, fmt.Errorf("error decoding search record: %w", err)
+	}
+	if feedback.Valid {
+		r.Feedback = &Feedback{}
+		if err := json.Unmarshal([]byte(feedback.String), r.Feedback); err != nil {
+			return Record{}, fmt.Errorf("error decoding feedback for %v: %w", r.ID, err)
+		}
+	}
+	return r, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,49 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+)
+
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// openAIEmbeddingBatchSize keeps each request comfortably under the embeddings endpoint input limits
+const openAIEmbeddingBatchSize = 64
+
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  openai.EmbeddingModel
+}
+
+// NewOpenAIEmbedder uses Ada v2 to match the vectors the personal corpus retriever queries with
+func NewOpenAIEmbedder(client *openai.Client) OpenAIEmbedder {
+	return OpenAIEmbedder{client: client, model: openai.AdaEmbeddingV2}
+}
+
+func (e OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+
+	for start := 0; start < len(texts); start += openAIEmbeddingBatchSize {
+		end := min(start+openAIEmbeddingBatchSize, len(texts))
+
+		response, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input: texts[start:end],
+			Model: e.model,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating embeddings: %w", err)
+		}
+
+		for _, embedding := range response.Data {
+			if embedding.Index < 0 || start+embedding.Index >= end {
+				return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+			}
+			embeddings[start+embedding.Index] = embedding.Embedding
+		}
+	}
+
+	return embeddings, nil
+}
@@ -0,0 +1,230 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"raglib-demo/filter"
+	"strconv"
+	"time"
+)
+
+// Payload fields written for every passage, retrievers reading the collection rely on these names
+const (
+	PayloadText         = "text"
+	PayloadDocumentID   = "document_id"
+	PayloadSource       = "source"
+	PayloadTitle        = "title"
+	PayloadFormat       = "format"
+	PayloadPassageIndex = "passage_index"
+	PayloadIngestedAt   = "ingested_at"
+	PayloadTags         = "tags"
+	PayloadAuthor       = "author"
+	// PayloadDate is when the document was written, falling back to when it was ingested, as RFC 3339
+	PayloadDate = "date"
+	// PayloadSourcePrefixes are the path segment prefixes of the source, see filter.SourcePrefixes
+	PayloadSourcePrefixes = "source_prefixes"
+)
+
+// sourceNamespace derives document IDs from sources, and passage IDs from those, so ingesting a source again
+// overwrites its passages instead of adding a copy
+var sourceNamespace = uuid.MustParse("aaa4e56c-00c6-48c4-ab73-cc80a6ec37d3")
+
+// filterableFields are the payload fields query time filters match on, and the type of index each needs
+var filterableFields = map[string]qdrant.FieldType{
+	PayloadTags:           qdrant.FieldType_FieldTypeKeyword,
+	PayloadAuthor:         qdrant.FieldType_FieldTypeKeyword,
+	PayloadDate:           qdrant.FieldType_FieldTypeDatetime,
+	PayloadSourcePrefixes: qdrant.FieldType_FieldTypeKeyword,
+}
+
+// Source is a single document to be ingested, as submitted by a client
+type Source struct {
+	Content string
+	Format  Format
+	// Source identifies where the document came from, ie a file path or URL
+	Source string
+	Title  string
+	Tags   []string
+	Author string
+	// Date is when the document was written, the ingestion time is used when it's zero
+	Date time.Time
+}
+
+// IngestedDocument summarises what was written to Qdrant for a single Source
+type IngestedDocument struct {
+	ID           string `json:"id"`
+	Source       string `json:"source"`
+	Title        string `json:"title"`
+	PassageCount int    `json:"passageCount"`
+}
+
+type Pipeline struct {
+	pointsClient   qdrant.PointsClient
+	embedder       Embedder
+	collectionName string
+	splitter       Splitter
+	// sparse is whether passages are also indexed for keyword search, the collection needs a SparseVectorName vector
+	sparse bool
+}
+
+func NewPipeline(pointsClient qdrant.PointsClient, embedder Embedder, collectionName string, sparse bool) *Pipeline {
+	return &Pipeline{
+		pointsClient:   pointsClient,
+		embedder:       embedder,
+		collectionName: collectionName,
+		splitter:       DefaultSplitter(),
+		sparse:         sparse,
+	}
+}
+
+// Ingest parses, splits, embeds and upserts each source. Sources are processed one at a time so a failure part way
+// through leaves earlier sources searchable.
+func (p *Pipeline) Ingest(ctx context.Context, sources []Source) ([]IngestedDocument, error) {
+	ingested := make([]IngestedDocument, 0, len(sources))
+
+	for _, source := range sources {
+		doc, err := p.ingestOne(ctx, source)
+		if err != nil {
+			return ingested, fmt.Errorf("failed to ingest %q: %w", source.Source, err)
+		}
+		ingested = append(ingested, doc)
+	}
+
+	return ingested, nil
+}
+
+func (p *Pipeline) ingestOne(ctx context.Context, source Source) (IngestedDocument, error) {
+	text, err := ToPlainText(source.Content, source.Format)
+	if err != nil {
+		return IngestedDocument{}, fmt.Errorf("error parsing document: %w", err)
+	}
+
+	passages := p.splitter.Split(text)
+	if len(passages) == 0 {
+		return IngestedDocument{}, fmt.Errorf("document has no text content")
+	}
+
+	embeddings, err := p.embedder.Embed(ctx, passages)
+	if err != nil {
+		return IngestedDocument{}, fmt.Errorf("error embedding passages: %w", err)
+	}
+	if len(embeddings) != len(passages) {
+		return IngestedDocument{}, fmt.Errorf("expected %d embeddings, got %d", len(passages), len(embeddings))
+	}
+
+	documentUUID := uuid.NewSHA1(sourceNamespace, []byte(source.Source))
+	documentID := documentUUID.String()
+	now := time.Now().UTC()
+	ingestedAt := now.Format(time.RFC3339)
+	date := now
+	if !source.Date.IsZero() {
+		date = source.Date.UTC()
+	}
+
+	tags := filter.NormalizeTags(source.Tags)
+	if tags == nil {
+		tags = []string{}
+	}
+
+	points := make([]*qdrant.PointStruct, 0, len(passages))
+	for i, passage := range passages {
+		payload, err := qdrant.TryValueMap(map[string]any{
+			PayloadText:         passage,
+			PayloadDocumentID:   documentID,
+			PayloadSource:       source.Source,
+			PayloadTitle:        source.Title,
+			PayloadFormat:       string(source.Format),
+			PayloadPassageIndex: int64(i),
+			PayloadIngestedAt:   ingestedAt,
+			PayloadTags:         anySlice(tags),
+			PayloadAuthor:       source.Author,
+			PayloadDate:         date.Format(time.RFC3339),
+			// Never empty, ingestion requires a source
+			PayloadSourcePrefixes: anySlice(filter.SourcePrefixes(source.Source)),
+		})
+		if err != nil {
+			return IngestedDocument{}, fmt.Errorf("error building payload for passage %d: %w", i, err)
+		}
+
+		vectors := qdrant.NewVectorsDense(embeddings[i])
+		if p.sparse {
+			sparse := SparseDocument(passage)
+			vectors = qdrant.NewVectorsMap(map[string]*qdrant.Vector{
+				// The collection's unnamed dense vector
+				"":               qdrant.NewVectorDense(embeddings[i]),
+				SparseVectorName: qdrant.NewVectorSparse(sparse.Indices, sparse.Values),
+			})
+		}
+
+		points = append(points, &qdrant.PointStruct{
+			Id:      qdrant.NewIDUUID(uuid.NewSHA1(documentUUID, []byte(strconv.Itoa(i))).String()),
+			Vectors: vectors,
+			Payload: payload,
+		})
+	}
+
+	wait := true
+	_, err = p.pointsClient.Upsert(ctx, &qdrant.UpsertPoints{
+		CollectionName: p.collectionName,
+		Wait:           &wait,
+		Points:         points,
+	})
+	if err != nil {
+		return IngestedDocument{}, fmt.Errorf("error upserting points: %w", err)
+	}
+
+	// Drop whatever the source had that wasn't just overwritten, passages past the end of a document that got
+	// shorter and passages ingested under random IDs before they were derived from the source
+	_, err = p.pointsClient.Delete(ctx, &qdrant.DeletePoints{
+		CollectionName: p.collectionName,
+		Wait:           &wait,
+		Points: qdrant.NewPointsSelectorFilter(&qdrant.Filter{
+			Must: []*qdrant.Condition{qdrant.NewMatch(PayloadSource, source.Source)},
+			MustNot: []*qdrant.Condition{qdrant.NewFilterAsCondition(&qdrant.Filter{
+				Must: []*qdrant.Condition{
+					qdrant.NewMatch(PayloadDocumentID, documentID),
+					qdrant.NewRange(PayloadPassageIndex, &qdrant.Range{Lt: qdrant.PtrOf(float64(len(passages)))}),
+				},
+			})},
+		}),
+	})
+	if err != nil {
+		return IngestedDocument{}, fmt.Errorf("error deleting stale points: %w", err)
+	}
+
+	return IngestedDocument{
+		ID:           documentID,
+		Source:       source.Source,
+		Title:        source.Title,
+		PassageCount: len(passages),
+	}, nil
+}
+
+// EnsurePayloadIndexes indexes the payload fields query time filters match on. Filters work without the indexes,
+// but have to scan every point. Creating an index that already exists is a no-op.
+func EnsurePayloadIndexes(ctx context.Context, pointsClient qdrant.PointsClient, collectionName string) error {
+	wait := true
+	for field, fieldType := range filterableFields {
+		_, err := pointsClient.CreateFieldIndex(ctx, &qdrant.CreateFieldIndexCollection{
+			CollectionName: collectionName,
+			Wait:           &wait,
+			FieldName:      field,
+			FieldType:      fieldType.Enum(),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating index on %v: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// anySlice converts values for qdrant.TryValueMap, which only accepts []any for lists
+func anySlice(values []string) []any {
+	converted := make([]any, len(values))
+	for i, v := range values {
+		converted[i] = v
+	}
+	return converted
+}
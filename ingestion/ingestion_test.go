@@ -0,0 +1,242 @@
+package ingestion
+
+import (
+	"context"
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"google.golang.org/grpc"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestToPlainText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		format   Format
+		expected string
+	}{
+		{
+			name:     "Plain text is unchanged",
+			content:  "Some text.\r\n\r\nAnother paragraph.",
+			format:   FormatText,
+			expected: "Some text.\n\nAnother paragraph.",
+		},
+		{
+			name:     "Markdown is unchanged",
+			content:  "# Title\n\nSome *text*.",
+			format:   FormatMarkdown,
+			expected: "# Title\n\nSome *text*.",
+		},
+		{
+			name:     "HTML block elements become paragraphs",
+			content:  "<html><head><title>Ignored</title></head><body><h1>Title</h1><p>First   paragraph.</p><p>Second <b>bold</b> one.</p></body></html>",
+			format:   FormatHTML,
+			expected: "Title\n\nFirst paragraph.\n\nSecond bold one.",
+		},
+		{
+			name:     "HTML scripts and styles are dropped",
+			content:  "<div>Kept<script>var dropped = 1;</script><style>.dropped {}</style></div>",
+			format:   FormatHTML,
+			expected: "Kept",
+		},
+		{
+			name:     "HTML preformatted text keeps its whitespace",
+			content:  "<p>Run   it:</p><pre><code>func main() {\n\tif err != nil {\n\t\treturn\n\t}\n}\n</code></pre><p>Done.</p>",
+			format:   FormatHTML,
+			expected: "Run it:\n\nfunc main() {\n\tif err != nil {\n\t\treturn\n\t}\n}\n\nDone.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, err := ToPlainText(tc.content, tc.format)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if text != tc.expected {
+				t.Errorf("Unexpected text. Got: %q, Expected: %q", text, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		splitter Splitter
+		text     string
+		expected []string
+	}{
+		{
+			name:     "Short paragraphs are grouped",
+			splitter: Splitter{MaxWords: 10, OverlapWords: 2},
+			text:     "one two three\n\nfour five six\n\nseven eight nine ten eleven",
+			expected: []string{"one two three\n\nfour five six", "seven eight nine ten eleven"},
+		},
+		{
+			name:     "Headings start a new passage",
+			splitter: Splitter{MaxWords: 50, OverlapWords: 2},
+			text:     "# Intro\n\nSome words\n\n## Usage\n\nMore words",
+			expected: []string{"# Intro\n\nSome words", "## Usage\n\nMore words"},
+		},
+		{
+			name:     "Long paragraphs are windowed with overlap",
+			splitter: Splitter{MaxWords: 4, OverlapWords: 1},
+			text:     "a b c d e f g",
+			expected: []string{"a b c d", "d e f g"},
+		},
+		{
+			name:     "Blank text has no passages",
+			splitter: DefaultSplitter(),
+			text:     " \n\n \n",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			passages := tc.splitter.Split(tc.text)
+			if len(passages) != len(tc.expected) {
+				t.Fatalf("Unexpected number of passages. Got: %d (%q), Expected: %d", len(passages), strings.Join(passages, "|"), len(tc.expected))
+			}
+			for i, passage := range passages {
+				if passage != tc.expected[i] {
+					t.Errorf("Passage [%d]; Got: %q, Expected: %q", i, passage, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTerms(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "Words are lowercased and punctuation dropped",
+			text:     "Deploys fail, sometimes!",
+			expected: []string{"deploys", "fail", "sometimes"},
+		},
+		{
+			name:     "Identifiers are kept whole and split",
+			text:     "ERR_CONN_RESET from http.Client",
+			expected: []string{"err_conn_reset", "err", "conn", "reset", "from", "http.client", "http", "client"},
+		},
+		{
+			name:     "Trailing punctuation isn't part of an identifier",
+			text:     "Upgrade to v1.2.3.",
+			expected: []string{"upgrade", "to", "v1.2.3", "v1"},
+		},
+		{
+			name:     "Single characters are dropped",
+			text:     "a b E42",
+			expected: []string{"e42"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if terms := Terms(tc.text); !reflect.DeepEqual(terms, tc.expected) {
+				t.Errorf("Unexpected terms. Got: %q, Expected: %q", terms, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSparseVectors(t *testing.T) {
+	query := SparseQuery("parseConfig parseConfig error")
+	if len(query.Indices) != 2 {
+		t.Fatalf("Expected repeated query terms to be counted once. Got: %d terms", len(query.Indices))
+	}
+	for _, v := range query.Values {
+		if v != 1 {
+			t.Errorf("Unexpected query term weight. Got: %v, Expected: %v", v, 1)
+		}
+	}
+
+	doc := SparseDocument("parseConfig returns an error. parseConfig is called on startup.")
+	if !slices.IsSorted(doc.Indices) {
+		t.Errorf("Expected sorted indices. Got: %v", doc.Indices)
+	}
+
+	weights := make(map[uint32]float32)
+	for i, index := range doc.Indices {
+		weights[index] = doc.Values[i]
+	}
+	repeated, once := weights[termIndex("parseconfig")], weights[termIndex("error")]
+	if repeated <= once {
+		t.Errorf("Expected a repeated term to weigh more. Got: %v, Expected more than %v", repeated, once)
+	}
+	if repeated >= 2*once {
+		t.Errorf("Expected term frequency to saturate. Got: %v, Expected less than %v", repeated, 2*once)
+	}
+}
+
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = []float32{1, 0}
+	}
+	return embeddings, nil
+}
+
+// fakePointsClient records the upserts and deletes it was sent
+type fakePointsClient struct {
+	qdrant.PointsClient
+
+	upserts []*qdrant.UpsertPoints
+	deletes []*qdrant.DeletePoints
+}
+
+func (c *fakePointsClient) Upsert(ctx context.Context, in *qdrant.UpsertPoints, opts ...grpc.CallOption) (*qdrant.PointsOperationResponse, error) {
+	c.upserts = append(c.upserts, in)
+	return &qdrant.PointsOperationResponse{}, nil
+}
+
+func (c *fakePointsClient) Delete(ctx context.Context, in *qdrant.DeletePoints, opts ...grpc.CallOption) (*qdrant.PointsOperationResponse, error) {
+	c.deletes = append(c.deletes, in)
+	return &qdrant.PointsOperationResponse{}, nil
+}
+
+func TestIngestReplacesSource(t *testing.T) {
+	points := &fakePointsClient{}
+	p := NewPipeline(points, fakeEmbedder{}, "notes", false)
+	p.splitter = Splitter{MaxWords: 2}
+
+	first, err := p.Ingest(context.Background(), []Source{{Source: "notes/deploys.md", Content: "one two\n\nthree four\n\nfive six", Format: FormatText}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := p.Ingest(context.Background(), []Source{{Source: "notes/deploys.md", Content: "one two\n\nthree four", Format: FormatText}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first[0].ID != second[0].ID {
+		t.Errorf("Got: %v, Expected: %v, the same document ID for the same source", second[0].ID, first[0].ID)
+	}
+	for i, point := range points.upserts[1].Points {
+		if id, expected := point.Id.GetUuid(), points.upserts[0].Points[i].Id.GetUuid(); id != expected {
+			t.Errorf("Passage [%d]; Got: %v, Expected: %v, the same point ID as before", i, id, expected)
+		}
+	}
+
+	// Everything from the source except the two passages just written
+	stale := points.deletes[1].Points.GetFilter()
+	if source := stale.Must[0].GetField(); source.Key != PayloadSource || source.Match.GetKeyword() != "notes/deploys.md" {
+		t.Errorf("Unexpected source condition: %v", source)
+	}
+	kept := stale.MustNot[0].GetFilter().Must
+	if documentID := kept[0].GetField(); documentID.Key != PayloadDocumentID || documentID.Match.GetKeyword() != second[0].ID {
+		t.Errorf("Unexpected document condition: %v", documentID)
+	}
+	if index := kept[1].GetField(); index.Key != PayloadPassageIndex || index.Range.GetLt() != 2 {
+		t.Errorf("Unexpected passage condition: %v", index)
+	}
+}
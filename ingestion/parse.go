@@ -0,0 +1,167 @@
+package ingestion
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case FormatText, "":
+		return FormatText, nil
+	case FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	case FormatHTML:
+		return FormatHTML, nil
+	default:
+		return "", fmt.Errorf("unsupported format, %v", s)
+	}
+}
+
+// ToPlainText converts content into text suitable for splitting and embedding. Markdown is left as is since the
+// answering model reads it fine and headings are useful split points.
+func ToPlainText(content string, format Format) (string, error) {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	switch format {
+	case FormatText, FormatMarkdown:
+		return content, nil
+	case FormatHTML:
+		return htmlToText(content)
+	default:
+		return "", fmt.Errorf("unsupported format, %v", format)
+	}
+}
+
+var skippedHTMLElements = map[string]struct{}{
+	"script":   {},
+	"style":    {},
+	"head":     {},
+	"noscript": {},
+	"template": {},
+	"svg":      {},
+}
+
+var blockHTMLElements = map[string]struct{}{
+	"p": {}, "div": {}, "section": {}, "article": {}, "header": {}, "footer": {}, "main": {}, "aside": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+	"li": {}, "ul": {}, "ol": {}, "pre": {}, "blockquote": {}, "table": {}, "tr": {}, "br": {}, "hr": {},
+}
+
+// htmlToText extracts the text of content, one paragraph per block element. Whitespace is collapsed except inside
+// <pre>, which is kept verbatim so code keeps its line breaks and indentation.
+func htmlToText(content string) (string, error) {
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+
+	var (
+		paragraphs []string
+		sb         strings.Builder
+		pre        strings.Builder
+		skipDepth  int
+		preDepth   int
+	)
+
+	flush := func() {
+		if text := collapseBlankLines(sb.String()); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+		sb.Reset()
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return "", fmt.Errorf("error tokenizing HTML: %w", err)
+			}
+			flush()
+			return strings.Join(paragraphs, "\n\n"), nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if _, ok := skippedHTMLElements[tag]; ok && tokenType == html.StartTagToken {
+				skipDepth++
+			}
+			if tag == "pre" && tokenType == html.StartTagToken {
+				if preDepth == 0 {
+					flush()
+				}
+				preDepth++
+				continue
+			}
+			if preDepth > 0 {
+				if tag == "br" {
+					pre.WriteString("\n")
+				}
+				continue
+			}
+			if _, ok := blockHTMLElements[tag]; ok {
+				sb.WriteString("\n\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if _, ok := skippedHTMLElements[tag]; ok && skipDepth > 0 {
+				skipDepth--
+			}
+			if tag == "pre" && preDepth > 0 {
+				preDepth--
+				if preDepth == 0 {
+					if code := strings.Trim(pre.String(), "\n"); strings.TrimSpace(code) != "" {
+						paragraphs = append(paragraphs, code)
+					}
+					pre.Reset()
+				}
+				continue
+			}
+			if preDepth > 0 {
+				continue
+			}
+			if _, ok := blockHTMLElements[tag]; ok {
+				sb.WriteString("\n\n")
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			if preDepth > 0 {
+				pre.Write(tokenizer.Text())
+				continue
+			}
+			sb.WriteString(strings.Join(strings.Fields(string(tokenizer.Text())), " "))
+			sb.WriteString(" ")
+		}
+	}
+}
+
+func collapseBlankLines(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := make([]string, 0, len(lines))
+
+	previousBlank := true
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !previousBlank {
+				kept = append(kept, "")
+			}
+			previousBlank = true
+			continue
+		}
+		kept = append(kept, line)
+		previousBlank = false
+	}
+
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
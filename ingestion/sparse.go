@@ -0,0 +1,102 @@
+package ingestion
+
+import (
+	"hash/fnv"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// SparseVectorName is the named sparse vector passages are indexed under for keyword search. The collection applies
+// IDF to it, so together with the term frequency weights from SparseDocument queries are scored with BM25.
+const SparseVectorName = "bm25"
+
+// BM25 parameters. Qdrant only knows the IDF half of BM25, the length normalization happens here at ingestion, so it
+// uses a fixed average passage length rather than the collection's actual average.
+const (
+	bm25K1            = 1.2
+	bm25B             = 0.75
+	bm25AverageLength = 150
+)
+
+// SparseVector is a bag of hashed terms, Indices are sorted and unique
+type SparseVector struct {
+	Indices []uint32
+	Values  []float32
+}
+
+// SparseDocument weights each term of a passage by BM25's saturated term frequency
+func SparseDocument(text string) SparseVector {
+	terms := Terms(text)
+	counts := make(map[uint32]int)
+	for _, term := range terms {
+		counts[termIndex(term)]++
+	}
+
+	lengthNorm := 1 - bm25B + bm25B*float64(len(terms))/bm25AverageLength
+	return sparseVector(counts, func(tf int) float32 {
+		return float32(float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*lengthNorm))
+	})
+}
+
+// SparseQuery gives each distinct query term a weight of 1, repeating a term in a query shouldn't boost it
+func SparseQuery(text string) SparseVector {
+	counts := make(map[uint32]int)
+	for _, term := range Terms(text) {
+		counts[termIndex(term)]++
+	}
+	return sparseVector(counts, func(int) float32 { return 1 })
+}
+
+func sparseVector(counts map[uint32]int, weight func(tf int) float32) SparseVector {
+	v := SparseVector{Indices: make([]uint32, 0, len(counts))}
+	for index := range counts {
+		v.Indices = append(v.Indices, index)
+	}
+	slices.Sort(v.Indices)
+
+	v.Values = make([]float32, len(v.Indices))
+	for i, index := range v.Indices {
+		v.Values[i] = weight(counts[index])
+	}
+	return v
+}
+
+// Terms lowercases text and splits it into the terms keyword search matches on. Identifiers like "http.Client",
+// "ERR_CONN_RESET" or "v1.2.3" are kept whole as well as split into their parts, so searching for either finds them.
+func Terms(text string) []string {
+	var terms []string
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isTermRune(r) }) {
+		token = strings.Trim(token, "._-")
+		if len(token) < 2 {
+			continue
+		}
+		terms = append(terms, token)
+
+		parts := strings.FieldsFunc(token, isJoiner)
+		if len(parts) < 2 {
+			continue
+		}
+		for _, part := range parts {
+			if len(part) >= 2 {
+				terms = append(terms, part)
+			}
+		}
+	}
+	return terms
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || isJoiner(r)
+}
+
+// isJoiner reports whether r joins the parts of an identifier
+func isJoiner(r rune) bool {
+	return r == '_' || r == '.' || r == '-'
+}
+
+func termIndex(term string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(term))
+	return h.Sum32()
+}
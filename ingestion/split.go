@@ -0,0 +1,78 @@
+package ingestion
+
+import (
+	"strings"
+)
+
+// Splitter groups paragraphs into passages of roughly MaxWords words. Paragraphs that are too long on their own are
+// cut into windows that overlap by OverlapWords so sentences on a boundary are still retrievable.
+type Splitter struct {
+	MaxWords     int
+	OverlapWords int
+}
+
+func DefaultSplitter() Splitter {
+	return Splitter{MaxWords: 200, OverlapWords: 30}
+}
+
+func (s Splitter) Split(text string) []string {
+	var (
+		passages []string
+		current  []string
+		count    int
+	)
+
+	flush := func() {
+		if len(current) > 0 {
+			passages = append(passages, strings.Join(current, "\n\n"))
+		}
+		current = nil
+		count = 0
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		words := len(strings.Fields(paragraph))
+
+		// Markdown headings start a new passage so a section isn't glued onto the tail of the previous one
+		if strings.HasPrefix(paragraph, "#") {
+			flush()
+		}
+
+		if words > s.MaxWords {
+			flush()
+			passages = append(passages, s.window(strings.Fields(paragraph))...)
+			continue
+		}
+
+		if count+words > s.MaxWords {
+			flush()
+		}
+		current = append(current, paragraph)
+		count += words
+	}
+	flush()
+
+	return passages
+}
+
+func (s Splitter) window(words []string) []string {
+	step := s.MaxWords - s.OverlapWords
+	if step <= 0 {
+		step = s.MaxWords
+	}
+
+	var windows []string
+	for start := 0; start < len(words); start += step {
+		end := min(start+s.MaxWords, len(words))
+		windows = append(windows, strings.Join(words[start:end], " "))
+		if end == len(words) {
+			break
+		}
+	}
+	return windows
+}
@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+// Completer runs a single, non-streaming completion. It's used for the small auxiliary calls around answer
+// generation, like query rewriting, rather than the answer itself.
+type Completer interface {
+	Complete(ctx context.Context, system string, prompt string) (string, error)
+}
+
+// DefaultOpenAIModel is cheap and fast, which matters more than quality for auxiliary calls
+const DefaultOpenAIModel = "gpt-4o-mini"
+
+type OpenAICompleter struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAICompleter(client *openai.Client, model string) OpenAICompleter {
+	return OpenAICompleter{client: client, model: model}
+}
+
+func (c OpenAICompleter) Complete(ctx context.Context, system string, prompt string) (string, error) {
+	response, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: c.model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: system},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		},
+		Temperature: 0,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating chat completion: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("chat completion returned no choices")
+	}
+
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
@@ -2,18 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
 	qdrant "github.com/qdrant/go-client/qdrant"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"io/fs"
 	"log"
+	"net/url"
+	"os"
+	"path/filepath"
 	"raglib-demo/api"
+	"raglib-demo/auth"
+	"raglib-demo/config"
+	"raglib-demo/corpus"
+	"raglib-demo/eval"
+	"raglib-demo/fixtures"
+	"raglib-demo/history"
+	"raglib-demo/ingestion"
+	"raglib-demo/tracing"
+	"strings"
 )
 
 var (
-	qdrantAddress = flag.String("addr", "localhost:6334", "The address of the Qdrant instance to connect to")
+	configPath  = flag.String("config", "config.json", "Path to the server config file, defaults are used if it doesn't exist")
+	printConfig = flag.Bool("print-config", false, "Print the effective config, with secrets redacted, and exit")
+	applyFlags  = config.BindFlags(flag.CommandLine)
 )
 
 const (
@@ -22,23 +41,294 @@ const (
 
 func main() {
 	ctx := context.Background()
+	flag.Parse()
 
-	err := godotenv.Load()
-	if err != nil {
+	// The environment can also come from elsewhere, ie a container, so a missing .env is fine
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("errror loading .env file: %v", err)
 	}
 
-	conn, err := grpc.DialContext(ctx, *qdrantAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	cfg, err := config.Load(*configPath, os.LookupEnv, applyFlags)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	if *printConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(cfg.Redacted()); err != nil {
+			log.Fatalf("failed to print config: %v", err)
+		}
+	}
+
+	corpusConfig, err := corpus.LoadConfig(cfg.Qdrant.CorporaPath, api.PersonalCollectionName)
+	if err != nil {
+		log.Fatalf("failed to load corpus config: %v", err)
+	}
+
+	// Exporting only reads the history, so it doesn't need API keys or the rest of the config to be valid
+	if flag.Arg(0) == "export-feedback" {
+		if err := runExportFeedback(ctx, cfg, flag.Args()[1:]); err != nil {
+			log.Fatalf("export failed: %v", err)
+		}
+		return
+	}
+
+	if err := errors.Join(cfg.Validate(), checkRetrieverKeys(cfg, corpusConfig)); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+	if *printConfig {
+		return
+	}
+
+	if flag.Arg(0) == "eval" {
+		if err := runEval(ctx, cfg, corpusConfig, flag.Args()[1:]); err != nil {
+			log.Fatalf("eval failed: %v", err)
+		}
+		return
+	}
+
+	var opts []api.Option
+	if cfg.Auth.KeyFile != "" {
+		keyring, err := auth.LoadKeyring(cfg.Auth.KeyFile)
+		if err != nil {
+			log.Fatalf("failed to load API keys: %v", err)
+		}
+		opts = append(opts, api.WithKeyring(keyring))
+	} else {
+		log.Println("No API key file configured, the API is open to anyone who can reach it")
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
 	if err != nil {
-		log.Fatalf("failed to connect: %v", err)
+		log.Fatalf("failed to set up tracing: %v", err)
 	}
-	defer conn.Close()
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("failed to flush traces: %v", err)
+		}
+	}()
 
-	server := api.NewServer(conn)
+	conn, qdrantOpts, err := connectQdrant(ctx, cfg, corpusConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+
+	server, err := api.NewServer(cfg, corpusConfig, append(opts, qdrantOpts...)...)
+	if err != nil {
+		log.Fatalf("failed to create server: %v", err)
+	}
 
 	server.Start(ctx)
 }
 
+// connectQdrant connects to Qdrant and makes sure every collection the server uses exists and is indexed, returning
+// the server options for it. The connection is nil when replaying fixtures.
+func connectQdrant(ctx context.Context, cfg config.Config, corpusConfig corpus.Config) (*grpc.ClientConn, []api.Option, error) {
+	// Replayed retrievers never reach Qdrant, so there's nothing to connect to
+	if cfg.Fixtures.Mode == fixtures.ModeReplay {
+		log.Printf("Replaying fixtures from %v, not connecting to Qdrant so ingestion is disabled", cfg.Fixtures.Dir)
+		return nil, nil, nil
+	}
+
+	conn, err := grpc.DialContext(ctx, cfg.Qdrant.Address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect: %v", err)
+	}
+
+	collectionsClient := qdrant.NewCollectionsClient(conn)
+	var denseOnly []string
+	for _, collectionName := range qdrantCollections(corpusConfig, api.PersonalCollectionName) {
+		if err := maybeRecreateCollection(ctx, collectionsClient, collectionName); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("failed to ensure collection %v exists: %v", collectionName, err)
+		}
+
+		sparse, err := hasSparseVectors(ctx, collectionsClient, collectionName)
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("failed to check collection %v: %v", collectionName, err)
+		}
+		if !sparse {
+			log.Printf("Collection %v predates hybrid search, recreate it and re-ingest its documents to search it by keyword", collectionName)
+			denseOnly = append(denseOnly, collectionName)
+		}
+	}
+
+	pointsClient := qdrant.NewPointsClient(conn)
+	if err := ingestion.EnsurePayloadIndexes(ctx, pointsClient, api.PersonalCollectionName); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to index collection %v: %v", api.PersonalCollectionName, err)
+	}
+
+	return conn, []api.Option{api.WithDenseOnlyCollections(denseOnly...), api.WithPointsClient(pointsClient)}, nil
+}
+
+// runEval runs an eval dataset through an in-process server, without authentication, and writes the results as JSON
+// and Markdown. See eval.Runner.
+func runEval(ctx context.Context, cfg config.Config, corpusConfig corpus.Config, args []string) error {
+	evalFlags := flag.NewFlagSet("eval", flag.ExitOnError)
+	datasetPath := evalFlags.String("dataset", "eval.jsonl", "JSONL dataset of queries with expected URLs and reference answers")
+	outPath := evalFlags.String("out", "eval-results", "Where results are written, .json and .md are added")
+	corpora := evalFlags.String("corpus", "web", "Comma separated corpora searched by cases that don't name their own")
+	params := evalFlags.String("params", "", "Search parameters for every case, as a query string, ie fusion=rrf&rerank=lexical")
+	k := evalFlags.Int("k", eval.DefaultK, "How many retrieved documents recall is measured over")
+	baselinePath := evalFlags.String("baseline", "", "A previous run's JSON results to compare against")
+	evalFlags.Parse(args)
+
+	searchParams, err := url.ParseQuery(*params)
+	if err != nil {
+		return fmt.Errorf("-params isn't a valid query string: %w", err)
+	}
+	var baseline *eval.Report
+	if *baselinePath != "" {
+		r, err := eval.LoadReport(*baselinePath)
+		if err != nil {
+			return err
+		}
+		baseline = &r
+	}
+
+	f, err := os.Open(*datasetPath)
+	if err != nil {
+		return fmt.Errorf("failed to open dataset: %w", err)
+	}
+	cases, err := eval.LoadDataset(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to load dataset %v: %w", *datasetPath, err)
+	}
+
+	conn, qdrantOpts, err := connectQdrant(ctx, cfg, corpusConfig)
+	if err != nil {
+		return err
+	}
+	// Eval searches would otherwise end up in the history, mixed in with the real queries datasets are mined from
+	cfg.History.Store = history.StoreOff
+	if conn != nil {
+		defer conn.Close()
+	}
+
+	server, err := api.NewServer(cfg, corpusConfig, qdrantOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+
+	runner := eval.NewRunner(server.Handler(), strings.Split(*corpora, ","), searchParams, *k)
+	results := runner.Run(ctx, cases, func(done int, result eval.CaseResult) {
+		if result.Error != "" {
+			log.Printf("[%d/%d] %v failed: %v", done, len(cases), result.ID, result.Error)
+			return
+		}
+		log.Printf("[%d/%d] %v done", done, len(cases), result.ID)
+	})
+	report := eval.NewReport(filepath.Base(*datasetPath), *params, *k, results)
+
+	for _, output := range []struct {
+		extension string
+		write     func(w io.Writer) error
+	}{
+		{".json", report.WriteJSON},
+		{".md", func(w io.Writer) error { return report.WriteMarkdown(w, baseline) }},
+	} {
+		out, err := os.Create(*outPath + output.extension)
+		if err != nil {
+			return fmt.Errorf("failed to create results file: %w", err)
+		}
+		err = errors.Join(output.write(out), out.Close())
+		if err != nil {
+			return fmt.Errorf("failed to write %v: %w", *outPath+output.extension, err)
+		}
+	}
+
+	log.Printf("Wrote %v.json and %v.md, %d cases, %d errors", *outPath, *outPath, report.Summary.Cases, report.Summary.Errors)
+	return nil
+}
+
+// runExportFeedback writes every search rated down in the history as an eval case, see eval.FeedbackCases
+func runExportFeedback(ctx context.Context, cfg config.Config, args []string) error {
+	exportFlags := flag.NewFlagSet("export-feedback", flag.ExitOnError)
+	outPath := exportFlags.String("out", "feedback.jsonl", "Where the eval cases are written, as JSONL")
+	exportFlags.Parse(args)
+
+	store, err := history.Open(cfg.History.Store, cfg.History.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open search history: %w", err)
+	}
+	if store == nil {
+		return fmt.Errorf("search history is off, there's no feedback to export")
+	}
+	defer store.Close()
+
+	records, err := store.List(ctx, history.Query{Rating: history.RatingDown})
+	if err != nil {
+		return err
+	}
+	cases := eval.FeedbackCases(records)
+
+	out, err := os.Create(*outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %v: %w", *outPath, err)
+	}
+	if err := errors.Join(eval.WriteDataset(out, cases), out.Close()); err != nil {
+		return fmt.Errorf("failed to write %v: %w", *outPath, err)
+	}
+
+	log.Printf("Wrote %d cases to %v, add their expectedUrls or referenceAnswer before running them", len(cases), *outPath)
+	return nil
+}
+
+// checkRetrieverKeys makes sure there's an API key for every web search provider a corpus uses, unless they're being
+// replayed
+func checkRetrieverKeys(cfg config.Config, corpusConfig corpus.Config) error {
+	if cfg.Fixtures.Mode == fixtures.ModeReplay {
+		return nil
+	}
+	keys := map[string]struct {
+		key string
+		env string
+	}{
+		corpus.TypeExa:  {cfg.APIKeys.Exa, "EXA_API_KEY"},
+		corpus.TypeSERP: {cfg.APIKeys.SERP, "SERPAPI_API_KEY"},
+	}
+
+	var errs []error
+	for _, definition := range corpusConfig.Corpora {
+		for _, rc := range definition.Retrievers {
+			if k, ok := keys[rc.Type]; ok && k.key == "" {
+				errs = append(errs, fmt.Errorf("corpus, %v, uses %v but there's no API key for it, set %v", definition.Name, rc.Type, k.env))
+				delete(keys, rc.Type)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// qdrantCollections lists every collection the server reads or writes, the ingestion collection plus any used by
+// configured corpora
+func qdrantCollections(corpusConfig corpus.Config, ingestionCollectionName string) []string {
+	collections := []string{ingestionCollectionName}
+	seen := map[string]struct{}{ingestionCollectionName: {}}
+
+	for _, definition := range corpusConfig.Corpora {
+		for _, rc := range definition.Retrievers {
+			if _, ok := seen[rc.Collection]; rc.Type != corpus.TypeQdrant || ok {
+				continue
+			}
+			seen[rc.Collection] = struct{}{}
+			collections = append(collections, rc.Collection)
+		}
+	}
+
+	return collections
+}
+
 func maybeRecreateCollection(ctx context.Context, collectionsClient qdrant.CollectionsClient, collectionName string) error {
 	containsResponse, err := collectionsClient.CollectionExists(ctx, &qdrant.CollectionExistsRequest{
 		CollectionName: collectionName,
@@ -57,6 +347,10 @@ func maybeRecreateCollection(ctx context.Context, collectionsClient qdrant.Colle
 					Distance: qdrant.Distance_Cosine,
 				},
 			}},
+			// Passages are also indexed for keyword search, Qdrant applies IDF so the ingested term weights score as BM25
+			SparseVectorsConfig: qdrant.NewSparseVectorsConfig(map[string]*qdrant.SparseVectorParams{
+				ingestion.SparseVectorName: {Modifier: qdrant.Modifier_Idf.Enum()},
+			}),
 			OptimizersConfig: &qdrant.OptimizersConfigDiff{
 				DefaultSegmentNumber: &defaultSegmentNumber,
 			},
@@ -70,3 +364,15 @@ func maybeRecreateCollection(ctx context.Context, collectionsClient qdrant.Colle
 
 	return nil
 }
+
+// hasSparseVectors reports whether a collection can be searched by keyword. Sparse vectors can't be added to an
+// existing collection, so collections created before hybrid search stay dense only.
+func hasSparseVectors(ctx context.Context, collectionsClient qdrant.CollectionsClient, collectionName string) (bool, error) {
+	info, err := collectionsClient.Get(ctx, &qdrant.GetCollectionInfoRequest{CollectionName: collectionName})
+	if err != nil {
+		return false, fmt.Errorf("error getting collection info: %v", err)
+	}
+
+	_, ok := info.GetResult().GetConfig().GetParams().GetSparseVectorsConfig().GetMap()[ingestion.SparseVectorName]
+	return ok, nil
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "raglib"
+
+// Outcomes of a retriever query or generation, mostly matching the api package's degradation reasons
+const (
+	OutcomeOK      = "ok"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+	OutcomeEmpty   = "empty"
+	// OutcomeCanceled is when the client went away, it isn't counted as an error
+	OutcomeCanceled = "canceled"
+)
+
+// Metrics holds the server's Prometheus collectors. Each Metrics has its own registry so servers, ie in tests, don't
+// collide.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	retrieverDuration  *prometheus.HistogramVec
+	retrieverErrors    *prometheus.CounterVec
+	timeToFirstToken   prometheus.Histogram
+	generationDuration *prometheus.HistogramVec
+	documentsReturned  prometheus.Histogram
+	serpExaOverlap     prometheus.Histogram
+	activeStreams      prometheus.Gauge
+	eventsWritten      *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		retrieverDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "retriever_request_duration_seconds",
+			Help:      "How long retriever queries take, by source and outcome. Cache hits aren't included.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+		}, []string{"source", "outcome"}),
+		retrieverErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retriever_errors_total",
+			Help:      "Retriever queries that failed or timed out, by source and reason.",
+		}, []string{"source", "reason"}),
+		timeToFirstToken: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "generation_time_to_first_token_seconds",
+			Help:      "Time from starting generation to the first answer event.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8},
+		}),
+		generationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "generation_duration_seconds",
+			Help:      "Total time to generate an answer, by outcome. Cached answers aren't included.",
+			Buckets:   []float64{1, 2, 4, 8, 15, 30, 60, 120},
+		}, []string{"outcome"}),
+		documentsReturned: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "retrieval_documents_returned",
+			Help:      "Documents left after fusion, the ones the answer is generated from.",
+			Buckets:   prometheus.LinearBuckets(0, 1, 11),
+		}),
+		serpExaOverlap: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "serp_exa_overlap_ratio",
+			Help:      "Fraction of SERP results Exa also returned, for searches that queried both.",
+			Buckets:   prometheus.LinearBuckets(0, 0.1, 11),
+		}),
+		activeStreams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sse_active_streams",
+			Help:      "SSE streams currently open, including resumed ones.",
+		}),
+		eventsWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sse_events_written_total",
+			Help:      "Events written to SSE streams, by event type.",
+		}, []string{"type"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.retrieverDuration,
+		m.retrieverErrors,
+		m.timeToFirstToken,
+		m.generationDuration,
+		m.documentsReturned,
+		m.serpExaOverlap,
+		m.activeStreams,
+		m.eventsWritten,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) ObserveRetriever(source string, outcome string, d time.Duration) {
+	m.retrieverDuration.WithLabelValues(source, outcome).Observe(d.Seconds())
+	if outcome == OutcomeError || outcome == OutcomeTimeout {
+		m.retrieverErrors.WithLabelValues(source, outcome).Inc()
+	}
+}
+
+func (m *Metrics) ObserveTimeToFirstToken(d time.Duration) {
+	m.timeToFirstToken.Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveGeneration(outcome string, d time.Duration) {
+	m.generationDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveDocumentsReturned(n int) {
+	m.documentsReturned.Observe(float64(n))
+}
+
+func (m *Metrics) ObserveSERPExaOverlap(ratio float64) {
+	m.serpExaOverlap.Observe(ratio)
+}
+
+// StreamOpened increments the active stream gauge, call the returned func once the stream closes
+func (m *Metrics) StreamOpened() (closed func()) {
+	m.activeStreams.Inc()
+	return m.activeStreams.Dec
+}
+
+func (m *Metrics) EventWritten(eventType string) {
+	m.eventsWritten.WithLabelValues(eventType).Inc()
+}
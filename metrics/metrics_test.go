@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+type stubRetriever struct {
+	docs []document.Document
+	err  error
+}
+
+func (r stubRetriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	return r.docs, r.err
+}
+
+func TestRetriever(t *testing.T) {
+	m := New()
+	docs := []document.Document{{Passages: []document.Passage{{Text: "a"}}}}
+
+	tests := []struct {
+		retriever stubRetriever
+		outcome   string
+	}{
+		{stubRetriever{docs: docs}, OutcomeOK},
+		{stubRetriever{}, OutcomeEmpty},
+		{stubRetriever{err: errors.New("boom")}, OutcomeError},
+		{stubRetriever{err: fmt.Errorf("wrapped: %w", context.DeadlineExceeded)}, OutcomeTimeout},
+		{stubRetriever{err: context.Canceled}, OutcomeCanceled},
+	}
+
+	for _, tt := range tests {
+		NewRetriever(tt.retriever, "exa", m).Query(context.Background(), "q", 10)
+
+		if !m.retrieverDuration.DeleteLabelValues("exa", tt.outcome) {
+			t.Errorf("Expected a latency observation with outcome %v", tt.outcome)
+		}
+	}
+
+	if got := testutil.ToFloat64(m.retrieverErrors.WithLabelValues("exa", OutcomeError)); got != 1 {
+		t.Errorf("Got: %v, Expected: %v", got, 1)
+	}
+	if got := testutil.ToFloat64(m.retrieverErrors.WithLabelValues("exa", OutcomeTimeout)); got != 1 {
+		t.Errorf("Got: %v, Expected: %v", got, 1)
+	}
+	if got := testutil.CollectAndCount(m.retrieverErrors); got != 2 {
+		t.Errorf("Got: %v error series, Expected: %v, empty and canceled queries aren't errors", got, 2)
+	}
+}
+
+func TestStreamOpened(t *testing.T) {
+	m := New()
+
+	closeFirst := m.StreamOpened()
+	closeSecond := m.StreamOpened()
+	closeFirst()
+
+	if got := testutil.ToFloat64(m.activeStreams); got != 1 {
+		t.Errorf("Got: %v, Expected: %v", got, 1)
+	}
+	closeSecond()
+	if got := testutil.ToFloat64(m.activeStreams); got != 0 {
+		t.Errorf("Got: %v, Expected: %v", got, 0)
+	}
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"time"
+)
+
+// Retriever records the latency and outcome of every query to another retriever
+type Retriever struct {
+	retriever retrieval.Retriever
+	source    string
+	metrics   *Metrics
+}
+
+func NewRetriever(retriever retrieval.Retriever, source string, metrics *Metrics) Retriever {
+	return Retriever{retriever: retriever, source: source, metrics: metrics}
+}
+
+func (r Retriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	start := time.Now()
+	docs, err := r.retriever.Query(ctx, query, topK)
+
+	outcome := ErrorOutcome(err)
+	if err == nil && len(docs) == 0 {
+		outcome = OutcomeEmpty
+	}
+	r.metrics.ObserveRetriever(r.source, outcome, time.Since(start))
+
+	return docs, err
+}
+
+// ErrorOutcome classifies err, which may be nil, as an outcome
+func ErrorOutcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.Is(err, context.Canceled):
+		return OutcomeCanceled
+	default:
+		return OutcomeError
+	}
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/modelproviders"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// compatibleBaseURLs are the OpenAI compatible APIs of the providers raglib's model facade has no OpenAI client for
+var compatibleBaseURLs = map[string]string{
+	ProviderAnthropic: "https://api.anthropic.com/v1",
+	ProviderGroq:      "https://api.groq.com/openai/v1",
+}
+
+// Answerer streams an answer to query grounded in documents, closing responseChan once it's done. raglib's
+// generation.Answerer is the real implementation.
+type Answerer interface {
+	Generate(ctx context.Context, query string, documents []document.Document, responseChan chan<- string, shouldStream bool) error
+}
+
+// Generator streams answers from whichever provider a search's settings pick
+type Generator struct {
+	answerer Answerer
+	clients  map[string]*openai.Client
+}
+
+// NewClients returns a client for every provider in keys that has a key. OpenAI's is the facade's, the same client
+// embedding and the auxiliary model use. The facade only exposes that one as an OpenAI client, its Anthropic client
+// is Anthropic's own SDK, so Anthropic and Groq are called through their OpenAI compatible APIs with the same keys
+// the facade was built with. That way every provider streams and reports usage the same way.
+func NewClients(facade *modelproviders.Facade, keys map[string]string, httpClient *http.Client) map[string]*openai.Client {
+	clients := make(map[string]*openai.Client)
+	for provider, key := range keys {
+		if key == "" {
+			continue
+		}
+		if provider == ProviderOpenAI {
+			clients[provider] = facade.OpenAIClient
+			continue
+		}
+		clientConfig := openai.DefaultConfig(key)
+		clientConfig.BaseURL = compatibleBaseURLs[provider]
+		if httpClient != nil {
+			clientConfig.HTTPClient = httpClient
+		}
+		clients[provider] = openai.NewClientWithConfig(clientConfig)
+	}
+	return clients
+}
+
+// NewGenerator generates RaglibAnswerer's answers with answerer and other models' with their provider's client in
+// clients, see NewClients. Generating with a provider that has no client fails.
+func NewGenerator(answerer Answerer, clients map[string]*openai.Client) *Generator {
+	return &Generator{answerer: answerer, clients: clients}
+}
+
+// Generate streams the answer to query, grounded in documents, to chunks with citations inline, ie
+// "<cited>0</cited>". It closes chunks once it's done. raglib's answerer doesn't report usage, so its answers have
+// none.
+func (g *Generator) Generate(ctx context.Context, query string, documents []document.Document, settings Settings, chunks chan<- string) (Usage, error) {
+	if settings.Model.Provider == ProviderRaglib {
+		if err := g.answerer.Generate(ctx, query, documents, chunks, true); err != nil {
+			return Usage{}, fmt.Errorf("error generating answer with %v: %w", settings.Model, err)
+		}
+		return Usage{}, nil
+	}
+	defer close(chunks)
+
+	client, ok := g.clients[settings.Model.Provider]
+	if !ok {
+		return Usage{}, fmt.Errorf("no API key for %v", settings.Model.Provider)
+	}
+
+	temperature := settings.Temperature
+	if temperature == 0 {
+		// go-openai omits a zero temperature, which gets the provider's default instead
+		temperature = math.SmallestNonzeroFloat32
+	}
+	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model: settings.Model.Name,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: systemPrompt(settings.Style)},
+			{Role: openai.ChatMessageRoleUser, Content: Prompt(query, documents)},
+		},
+		Temperature:   temperature,
+		MaxTokens:     settings.MaxTokens,
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		return Usage{}, fmt.Errorf("error creating chat completion stream with %v: %w", settings.Model, err)
+	}
+	defer stream.Close()
+
+	var usage Usage
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return usage, nil
+		}
+		if err != nil {
+			return usage, fmt.Errorf("error receiving answer from %v: %w", settings.Model, err)
+		}
+
+		// Usage comes on the last response, which has no choices
+		if response.Usage != nil {
+			usage = Usage{
+				PromptTokens:     response.Usage.PromptTokens,
+				CompletionTokens: response.Usage.CompletionTokens,
+				TotalTokens:      response.Usage.TotalTokens,
+			}
+		}
+		for _, choice := range response.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			select {
+			case chunks <- choice.Delta.Content:
+			case <-ctx.Done():
+				return usage, ctx.Err()
+			}
+		}
+	}
+}
+
+func systemPrompt(style string) string {
+	return `You answer questions using the numbered documents you're given. Base the answer on the documents, and say so when they don't answer the question.
+
+After each sentence that uses a document, cite it with its number in cited tags, ie "Deploys run on Tuesdays.<cited>2</cited>". Cite more than one document with a comma, ie "<cited>0,3</cited>". Only cite documents that support the sentence.
+
+Put code in fenced code blocks.
+
+` + styleInstructions[style]
+}
+
+// Prompt lists documents, numbered from 0 as citations refer to them, followed by the question
+func Prompt(query string, documents []document.Document) string {
+	var b strings.Builder
+	for i, d := range documents {
+		fmt.Fprintf(&b, "Document %d\n", i)
+		if ref := d.WebReference; ref != nil {
+			fmt.Fprintf(&b, "Title: %v\nLink: %v\n", ref.Title, ref.Link)
+			if ref.Snippet != "" {
+				fmt.Fprintf(&b, "Snippet: %v\n", ref.Snippet)
+			}
+		}
+		for _, p := range d.Passages {
+			b.WriteString(p.Text)
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "Question: %v", query)
+	return b.String()
+}
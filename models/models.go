@@ -0,0 +1,168 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Providers answers can be generated with. ProviderRaglib is raglib's own answerer, through its model facade, with
+// raglib's prompt and parameters. The others are called directly with this package's prompt.
+const (
+	ProviderRaglib    = "raglib"
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderGroq      = "groq"
+)
+
+var providers = []string{ProviderRaglib, ProviderOpenAI, ProviderAnthropic, ProviderGroq}
+
+// RaglibAnswerer is the only raglib model, it's the default so searches that don't pick a model are answered the way
+// raglib answers them
+var RaglibAnswerer = Model{Provider: ProviderRaglib, Name: "answerer"}
+
+// Model is a model answers can be generated with
+type Model struct {
+	Provider string `json:"provider"`
+	Name     string `json:"name"`
+}
+
+func (m Model) String() string {
+	return m.Provider + "/" + m.Name
+}
+
+func (m Model) Validate() error {
+	if !slices.Contains(providers, m.Provider) {
+		return fmt.Errorf("provider, %q, must be one of %v", m.Provider, strings.Join(providers, ", "))
+	}
+	if m.Name == "" {
+		return fmt.Errorf("model name is required")
+	}
+	if m.Provider == ProviderRaglib && m != RaglibAnswerer {
+		return fmt.Errorf("raglib only has one model, %v", RaglibAnswerer)
+	}
+	return nil
+}
+
+// Configurable reports whether answers from m can be given a temperature, max tokens and style, raglib's answerer
+// uses its own
+func (m Model) Configurable() bool {
+	return m.Provider != ProviderRaglib
+}
+
+// ParseModel parses a model written as "provider/name", ie "groq/llama-3.3-70b-versatile". Only the first "/"
+// separates the two, model names can have their own.
+func ParseModel(raw string) (Model, error) {
+	provider, name, ok := strings.Cut(strings.TrimSpace(raw), "/")
+	if !ok {
+		return Model{}, fmt.Errorf("model, %q, must be written as provider/name, ie openai/gpt-4o", raw)
+	}
+	m := Model{Provider: strings.ToLower(provider), Name: name}
+	if err := m.Validate(); err != nil {
+		return Model{}, fmt.Errorf("model, %q, is invalid: %w", raw, err)
+	}
+	return m, nil
+}
+
+// Resolve finds the model a request asked for among allowed. It can be asked for as "provider/name", or by name alone
+// when only one provider is allowed to serve it.
+func Resolve(allowed []Model, raw string) (Model, error) {
+	raw = strings.TrimSpace(raw)
+	var matches []Model
+	for _, m := range allowed {
+		if strings.EqualFold(m.String(), raw) || m.Name == raw {
+			matches = append(matches, m)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		names := make([]string, len(allowed))
+		for i, m := range allowed {
+			names[i] = m.String()
+		}
+		return Model{}, fmt.Errorf("model, %q, isn't allowed, expected one of %v", raw, strings.Join(names, ", "))
+	case 1:
+		return matches[0], nil
+	default:
+		return Model{}, fmt.Errorf("model, %q, is served by more than one provider, ask for it as provider/name", raw)
+	}
+}
+
+// Answer styles, they change how the answer is written but not what it's grounded in
+const (
+	StyleConcise    = "concise"
+	StyleDetailed   = "detailed"
+	StyleStepByStep = "step-by-step"
+)
+
+var styleInstructions = map[string]string{
+	StyleConcise:    "Answer in as few sentences as fully answer the question, skip background the question didn't ask for.",
+	StyleDetailed:   "Answer thoroughly, covering the relevant details and caveats the documents give.",
+	StyleStepByStep: "Answer as a numbered list of steps to follow, in order, with a short explanation of each.",
+}
+
+// ParseStyle validates an answer style, an empty style is StyleDetailed
+func ParseStyle(style string) (string, error) {
+	if style == "" {
+		return StyleDetailed, nil
+	}
+	if _, ok := styleInstructions[style]; !ok {
+		return "", fmt.Errorf("style, %q, must be one of %v, %v or %v", style, StyleConcise, StyleDetailed, StyleStepByStep)
+	}
+	return style, nil
+}
+
+// maxTemperatures are the highest temperature each provider accepts. Anthropic's OpenAI compatible API caps higher
+// temperatures at 1 rather than rejecting them, so they have to be rejected here.
+var maxTemperatures = map[string]float32{
+	ProviderOpenAI:    2,
+	ProviderAnthropic: 1,
+	ProviderGroq:      2,
+}
+
+// ValidateTemperature checks temperature is one m's provider accepts
+func ValidateTemperature(m Model, temperature float32) error {
+	if !m.Configurable() {
+		return fmt.Errorf("temperature can't be set for %v", m)
+	}
+	if highest := maxTemperatures[m.Provider]; math.IsNaN(float64(temperature)) || temperature < 0 || temperature > highest {
+		return fmt.Errorf("temperature must be between 0 and %v for %v", highest, m.Provider)
+	}
+	return nil
+}
+
+// Settings are what an answer is generated with
+type Settings struct {
+	Model       Model   `json:"model"`
+	Temperature float32 `json:"temperature"`
+	// MaxTokens caps the answer's length, not the prompt's. It and Style are empty for models that aren't
+	// Configurable.
+	MaxTokens int    `json:"maxTokens,omitempty"`
+	Style     string `json:"style,omitempty"`
+}
+
+// Key identifies the settings, for use in cache keys
+func (s Settings) Key() string {
+	return strings.Join([]string{s.Model.String(), strconv.FormatFloat(float64(s.Temperature), 'g', -1, 32), strconv.Itoa(s.MaxTokens), s.Style}, "|")
+}
+
+// Usage is the tokens a generation took, as reported by the provider
+type Usage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+}
+
+// EventType is the last event of an answer, reporting what it was generated with
+const EventType = "metadata"
+
+// Metadata says what an answer was generated with and what it cost, so providers can be compared on the same queries.
+// Cached answers didn't cost anything, so their usage is zero.
+type Metadata struct {
+	Settings
+	Usage  Usage `json:"usage"`
+	Cached bool  `json:"cached"`
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/modelproviders"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseModel(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected Model
+		wantErr  bool
+	}{
+		{"openai/gpt-4o", Model{Provider: ProviderOpenAI, Name: "gpt-4o"}, false},
+		{"Groq/meta-llama/llama-4-scout", Model{Provider: ProviderGroq, Name: "meta-llama/llama-4-scout"}, false},
+		{"gpt-4o", Model{}, true},
+		{"mistral/large", Model{}, true},
+		{"anthropic/", Model{}, true},
+		{"raglib/answerer", RaglibAnswerer, false},
+		{"raglib/other", Model{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseModel(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v, Unexpected error: %v", tt.raw, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.raw, got, tt.expected)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	allowed := []Model{
+		{Provider: ProviderAnthropic, Name: "claude-sonnet-4-5"},
+		{Provider: ProviderOpenAI, Name: "gpt-oss-120b"},
+		{Provider: ProviderGroq, Name: "gpt-oss-120b"},
+	}
+
+	tests := []struct {
+		raw      string
+		expected Model
+		wantErr  bool
+	}{
+		{"claude-sonnet-4-5", allowed[0], false},
+		{"groq/gpt-oss-120b", allowed[2], false},
+		{"gpt-oss-120b", Model{}, true},
+		{"openai/gpt-4o", Model{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := Resolve(allowed, tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v, Unexpected error: %v", tt.raw, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%v, Got: %v, Expected: %v", tt.raw, got, tt.expected)
+		}
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	if style, err := ParseStyle(""); err != nil || style != StyleDetailed {
+		t.Errorf("Got: %v, %v, Expected: %v", style, err, StyleDetailed)
+	}
+	if _, err := ParseStyle("haiku"); err == nil {
+		t.Errorf("Expected an unknown style to be rejected")
+	}
+}
+
+func TestValidateTemperature(t *testing.T) {
+	tests := []struct {
+		model       Model
+		temperature float32
+		wantErr     bool
+	}{
+		{Model{Provider: ProviderOpenAI, Name: "gpt-4o"}, 1.5, false},
+		{Model{Provider: ProviderOpenAI, Name: "gpt-4o"}, 2.5, true},
+		{Model{Provider: ProviderAnthropic, Name: "claude-sonnet-4-5"}, 1, false},
+		{Model{Provider: ProviderAnthropic, Name: "claude-sonnet-4-5"}, 1.5, true},
+		{Model{Provider: ProviderGroq, Name: "llama"}, -0.1, true},
+		{Model{Provider: ProviderOpenAI, Name: "gpt-4o"}, float32(math.NaN()), true},
+		{RaglibAnswerer, 0, true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateTemperature(tt.model, tt.temperature); (err != nil) != tt.wantErr {
+			t.Errorf("%v at %v, Unexpected error: %v", tt.model, tt.temperature, err)
+		}
+	}
+}
+
+// scriptedAnswerer streams chunks the way raglib's answerer does, closing responseChan itself
+type scriptedAnswerer []string
+
+func (a scriptedAnswerer) Generate(ctx context.Context, query string, documents []document.Document, responseChan chan<- string, shouldStream bool) error {
+	defer close(responseChan)
+	for _, chunk := range a {
+		responseChan <- chunk
+	}
+	return nil
+}
+
+func TestGeneratorUsesRaglibAnswerer(t *testing.T) {
+	g := NewGenerator(scriptedAnswerer{"Tuesdays ", "<cited>0</cited>."}, nil)
+
+	chunks := make(chan string, 10)
+	usage, err := g.Generate(context.Background(), "when are deploys?", nil, Settings{Model: RaglibAnswerer}, chunks)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var answer strings.Builder
+	for chunk := range chunks {
+		answer.WriteString(chunk)
+	}
+	if answer.String() != "Tuesdays <cited>0</cited>." {
+		t.Errorf("Got: %v, Expected: %v", answer.String(), "Tuesdays <cited>0</cited>.")
+	}
+	if usage != (Usage{}) {
+		t.Errorf("Got: %+v, Expected raglib's answers to report no usage", usage)
+	}
+}
+
+func TestGeneratorStreamsAndReportsUsage(t *testing.T) {
+	var requestBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requestBody = string(body)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, data := range []string{
+			`{"choices":[{"index":0,"delta":{"content":"Tuesdays "}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"<cited>0</cited>."}}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":80,"completion_tokens":5,"total_tokens":85}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+	}))
+	defer ts.Close()
+
+	clientConfig := openai.DefaultConfig("gsk-test")
+	clientConfig.BaseURL = ts.URL
+	g := &Generator{clients: map[string]*openai.Client{ProviderGroq: openai.NewClientWithConfig(clientConfig)}}
+
+	chunks := make(chan string, 10)
+	settings := Settings{Model: Model{Provider: ProviderGroq, Name: "llama-3.3-70b-versatile"}, Temperature: 0.5, MaxTokens: 300, Style: StyleConcise}
+	documents := []document.Document{{Passages: []document.Passage{{Text: "Deploys run on Tuesdays."}}}}
+	usage, err := g.Generate(context.Background(), "when are deploys?", documents, settings, chunks)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var answer strings.Builder
+	for chunk := range chunks {
+		answer.WriteString(chunk)
+	}
+	if answer.String() != "Tuesdays <cited>0</cited>." {
+		t.Errorf("Got: %v, Expected: %v", answer.String(), "Tuesdays <cited>0</cited>.")
+	}
+	if usage != (Usage{PromptTokens: 80, CompletionTokens: 5, TotalTokens: 85}) {
+		t.Errorf("Unexpected usage. Got: %+v", usage)
+	}
+	for _, expected := range []string{`"model":"llama-3.3-70b-versatile"`, `"max_tokens":300`, `"include_usage":true`, "Deploys run on Tuesdays.", styleInstructions[StyleConcise]} {
+		if !strings.Contains(requestBody, expected) {
+			t.Errorf("Expected the request to contain %q, got %v", expected, requestBody)
+		}
+	}
+}
+
+func TestGeneratorWithoutKey(t *testing.T) {
+	facade := modelproviders.NewFacade("sk-test", "", "")
+	clients := NewClients(facade, map[string]string{ProviderOpenAI: "sk-test", ProviderGroq: ""}, nil)
+	if clients[ProviderOpenAI] != facade.OpenAIClient {
+		t.Errorf("Expected OpenAI models to be called with the facade's client")
+	}
+	g := NewGenerator(nil, clients)
+
+	chunks := make(chan string)
+	_, err := g.Generate(context.Background(), "q", nil, Settings{Model: Model{Provider: ProviderGroq, Name: "llama"}}, chunks)
+	if err == nil {
+		t.Errorf("Expected generating with a provider without a key to fail")
+	}
+	if _, ok := <-chunks; ok {
+		t.Errorf("Expected chunks to be closed")
+	}
+}
@@ -0,0 +1,290 @@
+package qdrantsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"raglib-demo/filter"
+	"raglib-demo/fusion"
+	"raglib-demo/ingestion"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// APISource marks documents that came from this retriever, mirroring WebReference.APISource for "exa" and "serp"
+const APISource = "qdrant"
+
+// Retriever searches a collection written by the ingestion pipeline. Unlike raglib's Qdrant retriever it can apply
+// payload filters, and passages from the same source document are grouped into a single document. The query's
+// filter.Filter, if ctx has one, is applied on top of the retriever's own filter.
+type Retriever struct {
+	pointsClient   qdrant.PointsClient
+	embedder       ingestion.Embedder
+	collectionName string
+	filter         *qdrant.Filter
+	// hybrid is nil for dense only search
+	hybrid *HybridWeights
+}
+
+// HybridWeights are how much dense and sparse (BM25) results count for when they're fused
+type HybridWeights struct {
+	Dense  float64
+	Sparse float64
+}
+
+// WithHybrid searches the collection's ingestion.SparseVectorName vector as well as its dense one, fusing the results
+// with weighted reciprocal rank fusion. Exact identifiers, error codes and the like are often missed by dense search
+// alone.
+func (r Retriever) WithHybrid(weights HybridWeights) Retriever {
+	r.hybrid = &weights
+	return r
+}
+
+func NewRetriever(pointsClient qdrant.PointsClient, embedder ingestion.Embedder, collectionName string, filter *qdrant.Filter) Retriever {
+	return Retriever{
+		pointsClient:   pointsClient,
+		embedder:       embedder,
+		collectionName: collectionName,
+		filter:         filter,
+	}
+}
+
+// KeywordFilter builds a filter requiring each payload field to exactly match its value
+func KeywordFilter(fields map[string]string) *qdrant.Filter {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	conditions := make([]*qdrant.Condition, 0, len(fields))
+	for field, value := range fields {
+		conditions = append(conditions, qdrant.NewMatchKeyword(field, value))
+	}
+	return &qdrant.Filter{Must: conditions}
+}
+
+// Conditions are the payload conditions a document has to meet to match f
+func Conditions(f filter.Filter) []*qdrant.Condition {
+	var conditions []*qdrant.Condition
+	for _, tag := range f.Tags {
+		conditions = append(conditions, qdrant.NewMatchKeyword(ingestion.PayloadTags, tag))
+	}
+	if f.SourcePrefix != "" {
+		conditions = append(conditions, qdrant.NewMatchKeyword(ingestion.PayloadSourcePrefixes, f.SourcePrefix))
+	}
+	if f.Author != "" {
+		conditions = append(conditions, qdrant.NewMatchKeyword(ingestion.PayloadAuthor, f.Author))
+	}
+	if f.After != nil || f.Before != nil {
+		dateRange := &qdrant.DatetimeRange{}
+		if f.After != nil {
+			dateRange.Gte = timestamppb.New(*f.After)
+		}
+		if f.Before != nil {
+			dateRange.Lte = timestamppb.New(*f.Before)
+		}
+		conditions = append(conditions, qdrant.NewDatetimeRange(ingestion.PayloadDate, dateRange))
+	}
+	return conditions
+}
+
+// withConditions adds conditions to base without modifying it, base is shared by every query
+func withConditions(base *qdrant.Filter, conditions []*qdrant.Condition) *qdrant.Filter {
+	if len(conditions) == 0 {
+		return base
+	}
+	if base == nil {
+		return &qdrant.Filter{Must: conditions}
+	}
+	return &qdrant.Filter{Must: append(conditions, base.GetMust()...), Should: base.GetShould(), MustNot: base.GetMustNot()}
+}
+
+func (r Retriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	embeddings, err := r.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+	if len(embeddings) != 1 {
+		return nil, fmt.Errorf("expected 1 query embedding, got %d", len(embeddings))
+	}
+
+	queryFilter := withConditions(r.filter, Conditions(filter.FromContext(ctx)))
+	dense := &qdrant.SearchPoints{
+		CollectionName: r.collectionName,
+		Vector:         embeddings[0],
+		Filter:         queryFilter,
+		Limit:          topK,
+		WithPayload:    qdrant.NewWithPayload(true),
+	}
+
+	sparseQuery := ingestion.SparseQuery(query)
+	// A query with no terms, ie only punctuation, has nothing for keyword search to match
+	if r.hybrid == nil || len(sparseQuery.Indices) == 0 {
+		points, err := r.search(ctx, dense)
+		if err != nil {
+			return nil, err
+		}
+		return groupByDocument(points), nil
+	}
+
+	vectorName := ingestion.SparseVectorName
+	sparse := &qdrant.SearchPoints{
+		CollectionName: r.collectionName,
+		Vector:         sparseQuery.Values,
+		SparseIndices:  &qdrant.SparseIndices{Data: sparseQuery.Indices},
+		VectorName:     &vectorName,
+		Filter:         queryFilter,
+		Limit:          topK,
+		WithPayload:    qdrant.NewWithPayload(true),
+	}
+
+	var densePoints, sparsePoints []*qdrant.ScoredPoint
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		densePoints, err = r.search(gctx, dense)
+		return err
+	})
+	g.Go(func() (err error) {
+		sparsePoints, err = r.search(gctx, sparse)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	fused := fusePoints([][]*qdrant.ScoredPoint{densePoints, sparsePoints}, []float64{r.hybrid.Dense, r.hybrid.Sparse}, int(topK))
+	return groupByDocument(fused), nil
+}
+
+func (r Retriever) search(ctx context.Context, request *qdrant.SearchPoints) ([]*qdrant.ScoredPoint, error) {
+	response, err := r.pointsClient.Search(ctx, request)
+	if err != nil {
+		vector := "dense"
+		if request.VectorName != nil {
+			vector = *request.VectorName
+		}
+		return nil, fmt.Errorf("error searching collection %v (%v): %w", r.collectionName, vector, err)
+	}
+	return response.GetResult(), nil
+}
+
+// fusePoints merges ranked lists of passages with weighted reciprocal rank fusion, the same scoring fusion.ReciprocalRank
+// uses for documents. Passages are fused before being grouped so a document's passages are ranked individually.
+func fusePoints(lists [][]*qdrant.ScoredPoint, weights []float64, limit int) []*qdrant.ScoredPoint {
+	type scored struct {
+		point     *qdrant.ScoredPoint
+		score     float64
+		firstSeen int
+	}
+
+	byID := make(map[string]*scored)
+	for i, list := range lists {
+		for rank, point := range list {
+			id := pointID(point.GetId())
+			s, ok := byID[id]
+			if !ok {
+				s = &scored{point: point, firstSeen: len(byID)}
+				byID[id] = s
+			}
+			s.score += weights[i] / (fusion.DefaultRRFK + float64(rank+1))
+		}
+	}
+
+	all := make([]*scored, 0, len(byID))
+	for _, s := range byID {
+		// A zero weight list shouldn't add passages only it found
+		if s.score > 0 {
+			all = append(all, s)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].firstSeen < all[j].firstSeen
+	})
+
+	points := make([]*qdrant.ScoredPoint, 0, min(limit, len(all)))
+	for _, s := range all[:min(limit, len(all))] {
+		points = append(points, s.point)
+	}
+	return points
+}
+
+func pointID(id *qdrant.PointId) string {
+	if uuid := id.GetUuid(); uuid != "" {
+		return uuid
+	}
+	return strconv.FormatUint(id.GetNum(), 10)
+}
+
+// groupByDocument turns scored passages into documents, ordered by each document's best scoring passage
+func groupByDocument(points []*qdrant.ScoredPoint) []document.Document {
+	var (
+		documents []document.Document
+		indexByID = make(map[string]int)
+	)
+
+	for _, point := range points {
+		payload := point.GetPayload()
+		text := payload[ingestion.PayloadText].GetStringValue()
+		if text == "" {
+			continue
+		}
+
+		documentID := payload[ingestion.PayloadDocumentID].GetStringValue()
+		if i, ok := indexByID[documentID]; ok && documentID != "" {
+			documents[i].Passages = append(documents[i].Passages, document.Passage{Text: text})
+			continue
+		}
+
+		indexByID[documentID] = len(documents)
+		documents = append(documents, document.Document{
+			Passages:     []document.Passage{{Text: text}},
+			WebReference: webReference(payload, text),
+		})
+	}
+
+	return documents
+}
+
+// webReference describes a personal document the same way web results are, so the client can render it as a source
+func webReference(payload map[string]*qdrant.Value, text string) *document.WebReference {
+	source := payload[ingestion.PayloadSource].GetStringValue()
+	title := payload[ingestion.PayloadTitle].GetStringValue()
+	if title == "" {
+		title = source
+	}
+	// Documents ingested before dates were recorded only have the ingestion time
+	date := payload[ingestion.PayloadDate].GetStringValue()
+	if date == "" {
+		date = payload[ingestion.PayloadIngestedAt].GetStringValue()
+	}
+
+	return &document.WebReference{
+		Title:         title,
+		Link:          source,
+		DisplayedLink: source,
+		Snippet:       snippet(text),
+		Date:          date,
+		Author:        payload[ingestion.PayloadAuthor].GetStringValue(),
+		APISource:     APISource,
+	}
+}
+
+const snippetLength = 200
+
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= snippetLength {
+		return text
+	}
+	cut := snippetLength
+	for cut > 0 && (text[cut]&0xC0) == 0x80 {
+		cut--
+	}
+	return text[:cut] + "…"
+}
@@ -0,0 +1,162 @@
+package qdrantsearch
+
+import (
+	"context"
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"google.golang.org/grpc"
+	"raglib-demo/filter"
+	"raglib-demo/ingestion"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConditions(t *testing.T) {
+	if conditions := Conditions(filter.Filter{}); len(conditions) != 0 {
+		t.Errorf("Expected no conditions for an empty filter. Got: %v", conditions)
+	}
+
+	after := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	f := filter.Filter{Tags: []string{"deploys", "platform"}, SourcePrefix: "notes/platform", Author: "Ana", After: &after}
+
+	var fields []string
+	var dateRange *qdrant.DatetimeRange
+	for _, c := range Conditions(f) {
+		field := c.GetField()
+		fields = append(fields, field.GetKey())
+		if field.GetDatetimeRange() != nil {
+			dateRange = field.GetDatetimeRange()
+		}
+	}
+
+	expected := []string{ingestion.PayloadTags, ingestion.PayloadTags, ingestion.PayloadSourcePrefixes, ingestion.PayloadAuthor, ingestion.PayloadDate}
+	if len(fields) != len(expected) {
+		t.Fatalf("Unexpected condition fields. Got: %v, Expected: %v", fields, expected)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("Unexpected condition fields. Got: %v, Expected: %v", fields, expected)
+			break
+		}
+	}
+
+	if dateRange == nil || !dateRange.GetGte().AsTime().Equal(after) || dateRange.Lte != nil {
+		t.Errorf("Unexpected date range. Got: %v, Expected: gte %v", dateRange, after)
+	}
+}
+
+func TestWithConditionsKeepsBaseFilter(t *testing.T) {
+	base := KeywordFilter(map[string]string{"team": "platform"})
+	extra := Conditions(filter.Filter{Author: "Ana"})
+
+	combined := withConditions(base, extra)
+	if len(combined.GetMust()) != 2 {
+		t.Errorf("Unexpected combined conditions. Got: %d, Expected: %d", len(combined.GetMust()), 2)
+	}
+	if len(base.GetMust()) != 1 {
+		t.Errorf("Expected the retriever's own filter to be left alone. Got: %d conditions", len(base.GetMust()))
+	}
+	if withConditions(nil, nil) != nil {
+		t.Errorf("Expected no filter when there are no conditions")
+	}
+}
+
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = []float32{1, 0}
+	}
+	return embeddings, nil
+}
+
+// fakePointsClient answers dense and sparse searches with fixed passages, and records the searches it was sent
+type fakePointsClient struct {
+	qdrant.PointsClient
+
+	dense, sparse []*qdrant.ScoredPoint
+
+	mu       sync.Mutex
+	searches []*qdrant.SearchPoints
+}
+
+func (c *fakePointsClient) Search(ctx context.Context, in *qdrant.SearchPoints, opts ...grpc.CallOption) (*qdrant.SearchResponse, error) {
+	c.mu.Lock()
+	c.searches = append(c.searches, in)
+	c.mu.Unlock()
+
+	if in.GetVectorName() == ingestion.SparseVectorName {
+		return &qdrant.SearchResponse{Result: c.sparse}, nil
+	}
+	return &qdrant.SearchResponse{Result: c.dense}, nil
+}
+
+func passage(id, documentID, text string) *qdrant.ScoredPoint {
+	return &qdrant.ScoredPoint{
+		Id: qdrant.NewIDUUID(id),
+		Payload: qdrant.NewValueMap(map[string]any{
+			ingestion.PayloadText:       text,
+			ingestion.PayloadDocumentID: documentID,
+			ingestion.PayloadSource:     documentID + ".md",
+		}),
+	}
+}
+
+func TestHybridQuery(t *testing.T) {
+	points := &fakePointsClient{
+		dense: []*qdrant.ScoredPoint{
+			passage("p1", "runbook", "Restart the service when deploys hang."),
+			passage("p2", "faq", "Deploys go out on Tuesdays."),
+		},
+		sparse: []*qdrant.ScoredPoint{
+			passage("p3", "errors", "ERR_CONN_RESET means the proxy dropped the connection."),
+			passage("p1", "runbook", "Restart the service when deploys hang."),
+		},
+	}
+
+	r := NewRetriever(points, fakeEmbedder{}, "notes", nil).WithHybrid(HybridWeights{Dense: 1, Sparse: 1})
+	docs, err := r.Query(context.Background(), "ERR_CONN_RESET during deploys", 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(points.searches) != 2 {
+		t.Fatalf("Unexpected number of searches. Got: %v, Expected: %v", len(points.searches), 2)
+	}
+
+	// p1 is in both lists so it's first, then p3 which sparse ranked above where dense ranked p2
+	var got []string
+	for _, d := range docs {
+		got = append(got, d.WebReference.Link)
+	}
+	expected := []string{"runbook.md", "errors.md", "faq.md"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected documents. Got: %v, Expected: %v", got, expected)
+	}
+
+	sparseOnly := NewRetriever(points, fakeEmbedder{}, "notes", nil).WithHybrid(HybridWeights{Sparse: 1})
+	docs, err = sparseOnly.Query(context.Background(), "ERR_CONN_RESET during deploys", 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(docs) != 2 || docs[0].WebReference.Link != "errors.md" {
+		t.Errorf("Expected only sparse results when dense has no weight. Got: %+v", docs)
+	}
+}
+
+func TestDenseQuery(t *testing.T) {
+	points := &fakePointsClient{dense: []*qdrant.ScoredPoint{passage("p1", "runbook", "Restart the service.")}}
+
+	docs, err := NewRetriever(points, fakeEmbedder{}, "notes", nil).Query(context.Background(), "restart", 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(points.searches) != 1 || points.searches[0].VectorName != nil {
+		t.Errorf("Expected a single dense search. Got: %v", points.searches)
+	}
+	if len(docs) != 1 {
+		t.Errorf("Unexpected documents. Got: %v, Expected: %v", len(docs), 1)
+	}
+}
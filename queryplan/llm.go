@@ -0,0 +1,48 @@
+package queryplan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"raglib-demo/llm"
+	"strings"
+)
+
+const planSystemPrompt = `You prepare search queries for a search engine. Given a user's query:
+1. Rewrite it with typos fixed and acronyms or abbreviations expanded, keeping the original terms too, ie "k8s" becomes "k8s (Kubernetes)".
+2. If it asks several distinct questions, split the rewritten query into standalone sub-queries, one per question,
+each making sense on its own. If it asks one thing, give a single sub-query equal to the rewritten query.
+Reply with only JSON of the form {"rewritten": "...", "subQueries": ["..."]}, with at most 4 sub-queries.`
+
+// LLM plans queries with a model call, so it can fix any typo and understand which parts of a question depend on
+// each other, at the cost of latency before retrieval starts
+type LLM struct {
+	completer llm.Completer
+}
+
+func NewLLM(completer llm.Completer) LLM {
+	return LLM{completer: completer}
+}
+
+type llmReply struct {
+	Rewritten  string   `json:"rewritten"`
+	SubQueries []string `json:"subQueries"`
+}
+
+func (p LLM) Plan(ctx context.Context, query string) (Plan, error) {
+	reply, err := p.completer.Complete(ctx, planSystemPrompt, "Query: "+query)
+	if err != nil {
+		return Plan{}, fmt.Errorf("error planning query: %w", err)
+	}
+
+	// Models sometimes wrap JSON in a code fence despite being asked not to
+	raw := strings.TrimSpace(reply)
+	if start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}"); start >= 0 && end > start {
+		raw = raw[start : end+1]
+	}
+	var parsed llmReply
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return Plan{}, fmt.Errorf("model replied with an invalid plan, %q: %w", reply, err)
+	}
+	return newPlan(MethodLLM, query, parsed.Rewritten, parsed.SubQueries), nil
+}
@@ -0,0 +1,73 @@
+package queryplan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	MethodOff   = "off"
+	MethodRules = "rules"
+	MethodLLM   = "llm"
+)
+
+// ParseMethod validates a query planning method, an empty method is off
+func ParseMethod(method string) (string, error) {
+	switch method {
+	case "":
+		return MethodOff, nil
+	case MethodOff, MethodRules, MethodLLM:
+		return method, nil
+	default:
+		return "", fmt.Errorf("query planning method, %v, is invalid, expected one of %v, %v or %v", method, MethodOff, MethodRules, MethodLLM)
+	}
+}
+
+// EventType is the SSE event a search's plan is sent in, before any documents
+const EventType = "queryplan"
+
+// MaxSubQueries caps how many retrievals a single search can fan out to
+const MaxSubQueries = 4
+
+// Plan is what a query was turned into before retrieval
+type Plan struct {
+	Original string `json:"original"`
+	Method   string `json:"method"`
+	// Rewritten is the query with typos fixed and acronyms expanded, documents are reranked against it
+	Rewritten string `json:"rewritten"`
+	// SubQueries are retrieved separately and their results fused, there's always at least one
+	SubQueries []string `json:"subQueries"`
+}
+
+// Planner rewrites, expands and decomposes a query before retrieval
+type Planner interface {
+	Plan(ctx context.Context, query string) (Plan, error)
+}
+
+// newPlan cleans up rewritten and subQueries, falling back to query for anything left empty, and keeps at most
+// MaxSubQueries distinct sub-queries
+func newPlan(method, query, rewritten string, subQueries []string) Plan {
+	rewritten = strings.Join(strings.Fields(rewritten), " ")
+	if rewritten == "" {
+		rewritten = query
+	}
+
+	plan := Plan{Original: query, Method: method, Rewritten: rewritten}
+	seen := make(map[string]bool)
+	for _, q := range subQueries {
+		q = strings.Join(strings.Fields(q), " ")
+		if q == "" || seen[strings.ToLower(q)] {
+			continue
+		}
+		seen[strings.ToLower(q)] = true
+		plan.SubQueries = append(plan.SubQueries, q)
+		if len(plan.SubQueries) == MaxSubQueries {
+			break
+		}
+	}
+	if len(plan.SubQueries) == 0 {
+		plan.SubQueries = []string{rewritten}
+	}
+	return plan
+}
@@ -0,0 +1,116 @@
+package queryplan
+
+import (
+	"context"
+	"errors"
+	"raglib-demo/fakes"
+	"reflect"
+	"testing"
+)
+
+func TestParseMethod(t *testing.T) {
+	for raw, expected := range map[string]string{"": MethodOff, "off": MethodOff, "rules": MethodRules, "llm": MethodLLM} {
+		if got, err := ParseMethod(raw); err != nil || got != expected {
+			t.Errorf("Unexpected method for %q. Got: %v (%v), Expected: %v", raw, got, err, expected)
+		}
+	}
+	if _, err := ParseMethod("magic"); err == nil {
+		t.Errorf("Expected an unknown method to be an error")
+	}
+}
+
+func TestRules(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		expectedRewritten  string
+		expectedSubQueries []string
+	}{
+		{
+			name:               "Typos are fixed",
+			query:              "teh postgress databse",
+			expectedRewritten:  "the postgres database",
+			expectedSubQueries: []string{"the postgres database"},
+		},
+		{
+			name:               "Acronyms are expanded once, keeping the acronym",
+			query:              "K8s pods vs k8s jobs",
+			expectedRewritten:  "K8s (Kubernetes) pods vs k8s jobs",
+			expectedSubQueries: []string{"K8s (Kubernetes) pods vs k8s jobs"},
+		},
+		{
+			name:               "Question marks split questions",
+			query:              "what is a crdt? how do vector clocks work?",
+			expectedRewritten:  "what is a crdt (conflict-free replicated data type)? how do vector clocks work?",
+			expectedSubQueries: []string{"what is a crdt (conflict-free replicated data type)", "how do vector clocks work?"},
+		},
+		{
+			name:               "Conjunctions starting a new question split it",
+			query:              "what is raft and how does it compare to paxos",
+			expectedRewritten:  "what is raft and how does it compare to paxos",
+			expectedSubQueries: []string{"what is raft", "how does it compare to paxos"},
+		},
+		{
+			name:               "Conjunctions inside a question don't split it",
+			query:              "rust and go error handling",
+			expectedRewritten:  "rust and go error handling",
+			expectedSubQueries: []string{"rust and go error handling"},
+		},
+		{
+			name:               "Fragments too short to search don't split it",
+			query:              "why? how does tls work",
+			expectedRewritten:  "why? how does tls (transport layer security) work",
+			expectedSubQueries: []string{"why? how does tls (transport layer security) work"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, err := NewRules().Plan(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if plan.Original != tc.query || plan.Method != MethodRules {
+				t.Errorf("Unexpected plan. Got: %+v, Expected the original query and the rules method", plan)
+			}
+			if plan.Rewritten != tc.expectedRewritten {
+				t.Errorf("Unexpected rewritten query. Got: %q, Expected: %q", plan.Rewritten, tc.expectedRewritten)
+			}
+			if !reflect.DeepEqual(plan.SubQueries, tc.expectedSubQueries) {
+				t.Errorf("Unexpected sub-queries. Got: %q, Expected: %q", plan.SubQueries, tc.expectedSubQueries)
+			}
+		})
+	}
+}
+
+func TestLLM(t *testing.T) {
+	reply := "```json\n" + `{"rewritten": "Kubernetes vs  Nomad", "subQueries": ["what is Kubernetes", "What is Kubernetes", "", "what is Nomad", "a", "b", "c"]}` + "\n```"
+	plan, err := NewLLM(fakes.Completer{Reply: reply}).Plan(context.Background(), "k8s vs nomad")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Plan{
+		Original:   "k8s vs nomad",
+		Method:     MethodLLM,
+		Rewritten:  "Kubernetes vs Nomad",
+		SubQueries: []string{"what is Kubernetes", "what is Nomad", "a", "b"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Unexpected plan. Got: %+v, Expected: %+v", plan, expected)
+	}
+
+	plan, err = NewLLM(fakes.Completer{Reply: `{"rewritten": "", "subQueries": []}`}).Plan(context.Background(), "nomad")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if plan.Rewritten != "nomad" || !reflect.DeepEqual(plan.SubQueries, []string{"nomad"}) {
+		t.Errorf("Unexpected plan. Got: %+v, Expected an empty reply to fall back to the query", plan)
+	}
+
+	if _, err := NewLLM(fakes.Completer{Reply: "I'd search for nomad"}).Plan(context.Background(), "nomad"); err == nil {
+		t.Errorf("Expected a reply without JSON to be an error")
+	}
+	if _, err := NewLLM(fakes.Completer{Err: errors.New("rate limited")}).Plan(context.Background(), "nomad"); err == nil {
+		t.Errorf("Expected a failed completion to be an error")
+	}
+}
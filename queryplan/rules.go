@@ -0,0 +1,123 @@
+package queryplan
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// DefaultCorrections are common misspellings in technical searches
+var DefaultCorrections = map[string]string{
+	"accross":          "across",
+	"adress":           "address",
+	"arguement":        "argument",
+	"authentification": "authentication",
+	"begining":         "beginning",
+	"databse":          "database",
+	"definately":       "definitely",
+	"dependancy":       "dependency",
+	"enviroment":       "environment",
+	"fucntion":         "function",
+	"javscript":        "javascript",
+	"kuberentes":       "kubernetes",
+	"lenght":           "length",
+	"occured":          "occurred",
+	"paramter":         "parameter",
+	"perfomance":       "performance",
+	"postgress":        "postgres",
+	"pyhton":           "python",
+	"recieve":          "receive",
+	"retreive":         "retrieve",
+	"seperate":         "separate",
+	"teh":              "the",
+	"untill":           "until",
+}
+
+// DefaultAcronyms are expanded alongside the acronym, so documents that only use one of them still match
+var DefaultAcronyms = map[string]string{
+	"cdn":  "content delivery network",
+	"crdt": "conflict-free replicated data type",
+	"dns":  "domain name system",
+	"iam":  "identity and access management",
+	"jwt":  "JSON web token",
+	"k8s":  "Kubernetes",
+	"llm":  "large language model",
+	"orm":  "object relational mapping",
+	"rag":  "retrieval augmented generation",
+	"rpc":  "remote procedure call",
+	"sla":  "service level agreement",
+	"slo":  "service level objective",
+	"sso":  "single sign-on",
+	"tls":  "transport layer security",
+	"ttl":  "time to live",
+	"vpc":  "virtual private cloud",
+	"wasm": "WebAssembly",
+}
+
+var (
+	wordPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9]*`)
+	// questionBoundary splits "what is X? how does Y work?" after each question mark
+	questionBoundary = regexp.MustCompile(`\?\s+`)
+	// conjoinedQuestion splits "what is X and how does Y work" where a conjunction starts a new question
+	conjoinedQuestion = regexp.MustCompile(`(?i)(?:,?\s+and|;)\s+(what|how|why|when|where|which|who|does|do|is|are|can|should)\b`)
+)
+
+// minSubQueryWords stops rules from splitting off fragments that can't be searched on their own
+const minSubQueryWords = 2
+
+// Rules plans queries without a model call, fixing misspellings and expanding acronyms from fixed lists and splitting
+// questions at question marks and conjunctions. It's free and predictable but misses anything not in its lists.
+type Rules struct {
+	Corrections map[string]string
+	Acronyms    map[string]string
+}
+
+func NewRules() Rules {
+	return Rules{Corrections: DefaultCorrections, Acronyms: DefaultAcronyms}
+}
+
+func (r Rules) Plan(ctx context.Context, query string) (Plan, error) {
+	rewritten := r.Rewrite(query)
+	return newPlan(MethodRules, query, rewritten, splitQuestions(rewritten)), nil
+}
+
+// Rewrite fixes known misspellings and follows each known acronym with its expansion, ie "k8s" becomes
+// "k8s (Kubernetes)"
+func (r Rules) Rewrite(query string) string {
+	expanded := make(map[string]bool)
+	return wordPattern.ReplaceAllStringFunc(query, func(word string) string {
+		lower := strings.ToLower(word)
+		if correction, ok := r.Corrections[lower]; ok {
+			return correction
+		}
+		if expansion, ok := r.Acronyms[lower]; ok && !expanded[lower] {
+			expanded[lower] = true
+			return word + " (" + expansion + ")"
+		}
+		return word
+	})
+}
+
+// splitQuestions splits a compound question into its parts, or returns nil if it only asks one thing
+func splitQuestions(query string) []string {
+	var parts []string
+	for _, question := range questionBoundary.Split(strings.TrimSpace(query), -1) {
+		start := 0
+		for _, match := range conjoinedQuestion.FindAllStringSubmatchIndex(question, -1) {
+			// match[2] is where the question word after the conjunction starts
+			parts = append(parts, question[start:match[0]])
+			start = match[2]
+		}
+		parts = append(parts, question[start:])
+	}
+
+	for _, part := range parts {
+		if len(strings.Fields(part)) < minSubQueryWords {
+			return nil
+		}
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
@@ -0,0 +1,74 @@
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"golang.org/x/sync/errgroup"
+	"math"
+	"raglib-demo/grounding"
+	"raglib-demo/llm"
+	"strconv"
+	"strings"
+)
+
+const relevanceSystemPrompt = `You judge how relevant a document is to a search query. Reply with a single number
+between 0 and 1, where 1 means the document directly answers the query, 0.5 means it's related but only partly
+useful and 0 means it's irrelevant. Reply with only the number.`
+
+// llmDocumentLength caps how much of each document is sent to the model
+const llmDocumentLength = 4000
+
+// llmConcurrency is how many documents are scored at once
+const llmConcurrency = 6
+
+// LLM scores each document's relevance with its own model call. It's pointwise, so scores from different calls are
+// comparable and a cutoff means the same thing for every query.
+type LLM struct {
+	completer llm.Completer
+}
+
+func NewLLM(completer llm.Completer) LLM {
+	return LLM{completer: completer}
+}
+
+func (r LLM) Score(ctx context.Context, query string, documents []document.Document) ([]float64, error) {
+	scores := make([]float64, len(documents))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(llmConcurrency)
+	for i, d := range documents {
+		i, d := i, d
+		g.Go(func() error {
+			score, err := r.score(gctx, query, d)
+			if err != nil {
+				return fmt.Errorf("error scoring document %d: %w", i, err)
+			}
+			scores[i] = score
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+func (r LLM) score(ctx context.Context, query string, d document.Document) (float64, error) {
+	text := grounding.DocumentText(d)
+	if len(text) > llmDocumentLength {
+		text = strings.ToValidUTF8(text[:llmDocumentLength], "")
+	}
+
+	reply, err := r.completer.Complete(ctx, relevanceSystemPrompt, fmt.Sprintf("Query: %s\n\nDocument:\n%s", query, text))
+	if err != nil {
+		return 0, err
+	}
+
+	// ParseFloat accepts NaN and Inf, which would break sorting and can't be encoded as JSON
+	score, err := strconv.ParseFloat(strings.TrimSpace(reply), 64)
+	if err != nil || math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, fmt.Errorf("model replied with a non-numeric score, %q", reply)
+	}
+	return min(max(score, 0), 1), nil
+}
@@ -0,0 +1,89 @@
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"github.com/coopslarhette/raglib/lib/document"
+	"math"
+	"raglib-demo/grounding"
+	"sort"
+)
+
+// Reranker scores how relevant each document is to query, from 0 (irrelevant) to 1 (directly answers it). Scores
+// are returned in the same order as documents.
+type Reranker interface {
+	Score(ctx context.Context, query string, documents []document.Document) ([]float64, error)
+}
+
+const (
+	MethodOff     = "off"
+	MethodLexical = "lexical"
+	MethodLLM     = "llm"
+)
+
+// ParseMethod validates a reranking method, an empty method is off
+func ParseMethod(method string) (string, error) {
+	switch method {
+	case "":
+		return MethodOff, nil
+	case MethodOff, MethodLexical, MethodLLM:
+		return method, nil
+	default:
+		return "", fmt.Errorf("rerank method, %v, is invalid, expected one of %v, %v or %v", method, MethodOff, MethodLexical, MethodLLM)
+	}
+}
+
+// ValidateCutoff checks a relevance cutoff is on the same 0 to 1 scale as scores
+func ValidateCutoff(cutoff float64) error {
+	if math.IsNaN(cutoff) || cutoff < 0 || cutoff > 1 {
+		return fmt.Errorf("rerank cutoff, %v, must be between 0 and 1", cutoff)
+	}
+	return nil
+}
+
+// Rerank orders documents by their relevance score, dropping any that score below cutoff and keeping at most limit.
+// Documents with the same score keep their original order, so ties go to the better fused rank.
+func Rerank(ctx context.Context, reranker Reranker, query string, documents []document.Document, cutoff float64, limit int) ([]document.Document, []float64, error) {
+	scores, err := reranker.Score(ctx, query, documents)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(scores) != len(documents) {
+		return nil, nil, fmt.Errorf("expected %d relevance scores, got %d", len(documents), len(scores))
+	}
+
+	order := make([]int, 0, len(documents))
+	for i := range documents {
+		if scores[i] >= cutoff {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	order = order[:min(limit, len(order))]
+
+	reranked := make([]document.Document, len(order))
+	relevance := make([]float64, len(order))
+	for i, index := range order {
+		reranked[i] = documents[index]
+		relevance[i] = scores[index]
+	}
+	return reranked, relevance, nil
+}
+
+// Lexical scores relevance as the fraction of the query's content words that appear in a document, the same overlap
+// grounding.LexicalJudge uses for citations. It's free, but misses documents that paraphrase the query.
+type Lexical struct{}
+
+func (Lexical) Score(ctx context.Context, query string, documents []document.Document) ([]float64, error) {
+	scores := make([]float64, len(documents))
+	for i, d := range documents {
+		score, err := grounding.LexicalJudge{}.Support(ctx, query, grounding.DocumentText(d))
+		if err != nil {
+			return nil, err
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
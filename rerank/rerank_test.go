@@ -0,0 +1,144 @@
+package rerank
+
+import (
+	"context"
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
+	"math"
+	"raglib-demo/fakes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fixedScores []float64
+
+func (s fixedScores) Score(ctx context.Context, query string, documents []document.Document) ([]float64, error) {
+	return s, nil
+}
+
+func textDocument(text string) document.Document {
+	return document.Document{Passages: []document.Passage{{Text: text}}}
+}
+
+func texts(documents []document.Document) []string {
+	var out []string
+	for _, d := range documents {
+		out = append(out, d.Passages[0].Text)
+	}
+	return out
+}
+
+func TestRerank(t *testing.T) {
+	documents := []document.Document{textDocument("a"), textDocument("b"), textDocument("c"), textDocument("d")}
+
+	testCases := []struct {
+		name              string
+		scores            fixedScores
+		cutoff            float64
+		limit             int
+		expectedTexts     []string
+		expectedRelevance []float64
+	}{
+		{
+			name:              "Ordered by score, ties keep their fused order",
+			scores:            fixedScores{0.2, 0.9, 0.2, 0.5},
+			limit:             4,
+			expectedTexts:     []string{"b", "d", "a", "c"},
+			expectedRelevance: []float64{0.9, 0.5, 0.2, 0.2},
+		},
+		{
+			name:              "Below the cutoff is dropped",
+			scores:            fixedScores{0.2, 0.9, 0.3, 0.5},
+			cutoff:            0.3,
+			limit:             4,
+			expectedTexts:     []string{"b", "d", "c"},
+			expectedRelevance: []float64{0.9, 0.5, 0.3},
+		},
+		{
+			name:              "Limited after reranking",
+			scores:            fixedScores{0.1, 0.2, 0.3, 0.4},
+			limit:             2,
+			expectedTexts:     []string{"d", "c"},
+			expectedRelevance: []float64{0.4, 0.3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reranked, relevance, err := Rerank(context.Background(), tc.scores, "query", documents, tc.cutoff, tc.limit)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := texts(reranked); !reflect.DeepEqual(got, tc.expectedTexts) {
+				t.Errorf("Unexpected documents. Got: %v, Expected: %v", got, tc.expectedTexts)
+			}
+			if !reflect.DeepEqual(relevance, tc.expectedRelevance) {
+				t.Errorf("Unexpected relevance. Got: %v, Expected: %v", relevance, tc.expectedRelevance)
+			}
+		})
+	}
+
+	if _, _, err := Rerank(context.Background(), fixedScores{1}, "query", documents, 0, 4); err == nil {
+		t.Errorf("Expected a score count mismatch to be an error")
+	}
+}
+
+func TestLexical(t *testing.T) {
+	documents := []document.Document{
+		textDocument("Rust has no garbage collector."),
+		textDocument("Go's garbage collector is concurrent and has short pauses."),
+	}
+
+	scores, err := Lexical{}.Score(context.Background(), "go garbage collector pauses", documents)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if scores[1] != 1 || scores[0] >= scores[1] {
+		t.Errorf("Unexpected scores. Got: %v, Expected the Go document to score 1 and higher than the Rust one", scores)
+	}
+}
+
+// scriptedCompleter replies with the score for the first document text the prompt contains
+type scriptedCompleter map[string]string
+
+func (c scriptedCompleter) Complete(ctx context.Context, system string, prompt string) (string, error) {
+	for text, reply := range c {
+		if strings.Contains(prompt, text) {
+			return reply, nil
+		}
+	}
+	return "", errors.New("unexpected prompt")
+}
+
+func TestLLM(t *testing.T) {
+	documents := []document.Document{textDocument("relevant"), textDocument("unrelated"), textDocument("overconfident")}
+	completer := scriptedCompleter{"relevant": "0.8", "unrelated": " 0 ", "overconfident": "7"}
+
+	scores, err := NewLLM(completer).Score(context.Background(), "query", documents)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []float64{0.8, 0, 1}; !reflect.DeepEqual(scores, expected) {
+		t.Errorf("Unexpected scores. Got: %v, Expected: %v", scores, expected)
+	}
+
+	for _, reply := range []string{"very relevant", "NaN", "+Inf"} {
+		if _, err := NewLLM(fakes.Completer{Reply: reply}).Score(context.Background(), "query", documents); err == nil {
+			t.Errorf("Expected a reply of %q to be an error", reply)
+		}
+	}
+}
+
+func TestValidateCutoff(t *testing.T) {
+	for _, cutoff := range []float64{0, 0.5, 1} {
+		if err := ValidateCutoff(cutoff); err != nil {
+			t.Errorf("Unexpected error for %v: %v", cutoff, err)
+		}
+	}
+	for _, cutoff := range []float64{-0.1, 1.1, math.NaN()} {
+		if err := ValidateCutoff(cutoff); err == nil {
+			t.Errorf("Expected a cutoff of %v to be an error", cutoff)
+		}
+	}
+}
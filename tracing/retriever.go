@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"context"
+	"github.com/coopslarhette/raglib/lib/document"
+	"github.com/coopslarhette/raglib/lib/retrieval"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Retriever wraps every query to another retriever in a span
+type Retriever struct {
+	retriever retrieval.Retriever
+	source    string
+}
+
+func NewRetriever(retriever retrieval.Retriever, source string) Retriever {
+	return Retriever{retriever: retriever, source: source}
+}
+
+func (r Retriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	ctx, span := Start(ctx, "retriever.Query",
+		attribute.String("retriever.source", r.source),
+		attribute.Int64("retriever.top_k", int64(topK)),
+	)
+
+	docs, err := r.retriever.Query(ctx, query, topK)
+	span.SetAttributes(attribute.Int("retriever.documents", len(docs)))
+	End(span, err)
+
+	return docs, err
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/coopslarhette/raglib/lib/document"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"raglib-demo/config"
+	"testing"
+)
+
+type stubRetriever struct {
+	docs []document.Document
+	err  error
+}
+
+func (r stubRetriever) Query(ctx context.Context, query string, topK uint64) ([]document.Document, error) {
+	return r.docs, r.err
+}
+
+func TestRetriever(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	docs := []document.Document{{}, {}}
+	NewRetriever(stubRetriever{docs: docs}, "exa").Query(context.Background(), "q", 10)
+	NewRetriever(stubRetriever{err: errors.New("boom")}, "serp").Query(context.Background(), "q", 10)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Got: %v spans, Expected: %v", len(spans), 2)
+	}
+
+	attributes := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	ok := attributes(spans[0])
+	if ok["retriever.source"].AsString() != "exa" || ok["retriever.top_k"].AsInt64() != 10 || ok["retriever.documents"].AsInt64() != 2 {
+		t.Errorf("Got: %v, Expected: source, topK and document count attributes", ok)
+	}
+	if spans[0].Status().Code == codes.Error {
+		t.Errorf("Expected successful query's span not to have an error status")
+	}
+
+	if spans[1].Status().Code != codes.Error || spans[1].Status().Description != "boom" {
+		t.Errorf("Got: %+v, Expected: error status", spans[1].Status())
+	}
+}
+
+func TestSetupWithoutExporter(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: config.TracingExporterNone})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "zipkin"}); err == nil {
+		t.Errorf("Expected an error for an unknown exporter")
+	}
+}
@@ -1,5 +1,9 @@
 const APIURL = process.env.NEXT_PUBLIC_API_URL
+// EventSource can't set headers, so the key is sent as a query parameter. It's visible to anyone using the app, so
+// it should be a key limited to the web corpus.
+const APIKey = process.env.NEXT_PUBLIC_API_KEY
 
 export function toSearchURL(query: string) {
-    return `${APIURL}/search?q=${encodeURIComponent(query)}&corpus=web`
+    const url = `${APIURL}/search?q=${encodeURIComponent(query)}&corpus=web`
+    return APIKey ? `${url}&api_key=${encodeURIComponent(APIKey)}` : url
 }
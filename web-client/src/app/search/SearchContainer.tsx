@@ -16,8 +16,13 @@ interface SearchContainerProps {
 export default function SearchContainer({
     initialQuery,
 }: SearchContainerProps) {
-    const { answerChunks, documents, isResponseLoading, handleSearch } =
-        useAnswerStream(initialQuery)
+    const {
+        answerChunks,
+        documents,
+        queryPlan,
+        isResponseLoading,
+        handleSearch,
+    } = useAnswerStream(initialQuery)
 
     useEffect(() => {
         if (initialQuery.length > 0) {
@@ -36,6 +41,7 @@ export default function SearchContainer({
                 <SearchResults
                     documents={documents}
                     answerChunks={answerChunks}
+                    queryPlan={queryPlan}
                 />
             )}
         </div>
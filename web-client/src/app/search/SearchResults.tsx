@@ -2,7 +2,7 @@
 
 import React, { useMemo, useState } from 'react'
 import styles from './SearchResults.module.css'
-import { SourceDocument, AnswerChunk } from './types'
+import { SourceDocument, AnswerChunk, QueryPlan } from './types'
 import { SourceCard } from './SourceCard'
 import { Card, CardContent, CircularProgress } from '@mui/material'
 import { MarkdownRenderer } from '@/app/search/MarkdownRenderer'
@@ -10,11 +10,13 @@ import { MarkdownRenderer } from '@/app/search/MarkdownRenderer'
 interface SearchResultsProps {
     documents: SourceDocument[]
     answerChunks: AnswerChunk[]
+    queryPlan: QueryPlan | null
 }
 
 export default function SearchResults({
     documents,
     answerChunks,
+    queryPlan,
 }: SearchResultsProps) {
     const [hoveredCitationIndex, setHoveredCitationIndex] = useState<
         null | number
@@ -30,8 +32,35 @@ export default function SearchResults({
         }, '')
     }, [answerChunks])
 
+    // Only worth showing when planning changed what was searched
+    const searchedFor = useMemo(() => {
+        if (queryPlan === null) {
+            return []
+        }
+        if (queryPlan.subQueries.length > 1) {
+            return queryPlan.subQueries
+        }
+        if (queryPlan.rewritten !== queryPlan.original) {
+            return [queryPlan.rewritten]
+        }
+        return []
+    }, [queryPlan])
+
     return (
         <>
+            {searchedFor.length > 0 && (
+                <p className={styles.searchedFor}>
+                    Searched for:{' '}
+                    {searchedFor.map((query, index) => (
+                        <React.Fragment key={query}>
+                            {index > 0 && ', '}
+                            <span className={styles.searchedQuery}>
+                                {query}
+                            </span>
+                        </React.Fragment>
+                    ))}
+                </p>
+            )}
             {documents.length > 0 && (
                 <div className={styles.resultSection}>
                     <h2 className={styles.headers}>Sources</h2>
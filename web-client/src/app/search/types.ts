@@ -19,12 +19,50 @@ export type SourceDocument = {
     passages: Passage[]
     corpus: Corpus
     webReference?: WebReference
+    // Set when the server reranked documents, from 0 to 1
+    relevance?: number
+}
+
+export type DegradedSource = {
+    source: string
+    reason: 'timeout' | 'error' | 'empty'
+    message: string
+}
+
+export type CitationWarning = {
+    // The document index as the model wrote it
+    citation: number
+    reason: 'outofrange' | 'unsupported'
+    score?: number
+    claim?: string
+}
+
+export type Groundedness = {
+    method: 'off' | 'lexical' | 'llm'
+    score: number
+    citations: number
+    dropped: number
+    unsupported: number
+}
+
+export type QueryPlan = {
+    original: string
+    method: 'rules' | 'llm'
+    rewritten: string
+    // Each is retrieved separately and the results fused
+    subQueries: string[]
 }
 
 export type ChunkType =
+    | 'queryplan'
     | 'text'
     | 'citation'
     | 'documentsreference'
+    | 'degradedsources'
+    | 'contextpassages'
+    | 'citationwarning'
+    | 'groundedness'
+    | 'metadata'
     | 'codeblock'
     | 'done'
 
@@ -1,4 +1,9 @@
-import { AnswerChunk, ChunkType, SourceDocument } from '@/app/search/types'
+import {
+    AnswerChunk,
+    ChunkType,
+    QueryPlan,
+    SourceDocument,
+} from '@/app/search/types'
 import { useRouter } from 'next/navigation'
 import { useCallback, useEffect, useReducer, useRef, useState } from 'react'
 import { toSearchURL } from '@/api'
@@ -6,11 +11,13 @@ import { toSearchURL } from '@/api'
 interface AnswerStreamState {
     documents: SourceDocument[]
     answerChunks: AnswerChunk[]
+    queryPlan: QueryPlan | null
 }
 
 type AnswerStreamAction =
     | { type: 'ADD_ANSWER_CHUNK'; payload: AnswerChunk }
     | { type: 'SET_DOCUMENTS'; payload: SourceDocument[] }
+    | { type: 'SET_QUERY_PLAN'; payload: QueryPlan }
     | { type: 'RESET' }
 
 // Lol this might be over-engineered
@@ -26,8 +33,10 @@ function answerStreamReducer(
             }
         case 'SET_DOCUMENTS':
             return { ...state, documents: action.payload }
+        case 'SET_QUERY_PLAN':
+            return { ...state, queryPlan: action.payload }
         case 'RESET':
-            return { documents: [], answerChunks: [] }
+            return { documents: [], answerChunks: [], queryPlan: null }
         default:
             return state
     }
@@ -36,11 +45,12 @@ function answerStreamReducer(
 export const useAnswerStream = (initialQuery: string) => {
     const router = useRouter()
     const [isResponseLoading, setIsResponseLoading] = useState(false)
-    const [{ answerChunks, documents }, dispatch] = useReducer(
+    const [{ answerChunks, documents, queryPlan }, dispatch] = useReducer(
         answerStreamReducer,
         {
             documents: [],
             answerChunks: [],
+            queryPlan: null,
         }
     )
     const eventSourceRef = useRef<EventSource | null>(null)
@@ -73,9 +83,27 @@ export const useAnswerStream = (initialQuery: string) => {
                         },
                     })
                     break
+                case 'queryplan':
+                    dispatch({ type: 'SET_QUERY_PLAN', payload: data })
+                    break
                 case 'documentsreference':
                     dispatch({ type: 'SET_DOCUMENTS', payload: data })
                     break
+                case 'degradedsources':
+                    console.warn('Some sources were unavailable:', data)
+                    break
+                case 'contextpassages':
+                    console.debug('Answer context:', data)
+                    break
+                case 'citationwarning':
+                    console.warn('Citation failed verification:', data)
+                    break
+                case 'groundedness':
+                    console.debug('Answer groundedness:', data)
+                    break
+                case 'metadata':
+                    console.debug('Answer metadata:', data)
+                    break
                 case 'done':
                     eventSource.close()
                     break
@@ -101,6 +129,11 @@ export const useAnswerStream = (initialQuery: string) => {
 
             let lastEventData = null
             const handleError = (err: Event) => {
+                // The browser is reconnecting with Last-Event-ID set, the server will replay what was missed
+                if (eventSource.readyState === EventSource.CONNECTING) {
+                    console.warn('Event source connection dropped, resuming')
+                    return
+                }
                 console.error('There was an error with the event source')
                 if (lastEventData) {
                     try {
@@ -132,9 +165,15 @@ export const useAnswerStream = (initialQuery: string) => {
             eventSourceRef.current = eventSource
             ;(
                 [
+                    'queryplan',
                     'text',
                     'citation',
                     'documentsreference',
+                    'degradedsources',
+                    'contextpassages',
+                    'citationwarning',
+                    'groundedness',
+                    'metadata',
                     'codeblock',
                     'done',
                 ] as ChunkType[]
@@ -155,5 +194,6 @@ export const useAnswerStream = (initialQuery: string) => {
         isResponseLoading,
         answerChunks,
         documents,
+        queryPlan,
     }
 }